@@ -50,7 +50,7 @@ func main() {
 	switch {
 	case *param.Get().Debug == true:
 		config.LogLevel = "debug"
-	case *param.Get().CheckGLB == true || *param.Get().CheckBackend == true || *param.Get().CheckConfig == true:
+	case *param.Get().CheckGLB == true || *param.Get().CheckBackend == true || *param.Get().CheckConfig == true || *param.Get().DNSSECDS == true:
 		config.LogLevel = "warn"
 	default:
 		config.LogLevel = "info"
@@ -78,6 +78,8 @@ func main() {
 		os.Exit(check.GLB())
 	case *param.Get().CheckBackend == true:
 		os.Exit(check.Backend())
+	case *param.Get().DNSSECDS == true:
+		os.Exit(check.DNSSECDS())
 	}
 
 	logging.Configure(config.Get().Logging.Output, config.Get().Logging.Level)
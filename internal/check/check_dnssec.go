@@ -0,0 +1,49 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/schubergphilis/mercury/internal/config"
+	"github.com/schubergphilis/mercury/pkg/dns"
+	"github.com/schubergphilis/mercury/pkg/param"
+)
+
+// DNSSECDS prints the DS records of all DNSSEC signed domains, to be added to the parent zone
+func DNSSECDS() int {
+	var domains []string
+	for domainName, domain := range config.Get().DNS.Domains {
+		if len(domain.DNSSEC.Keys) == 0 {
+			continue
+		}
+
+		if *param.Get().DNSName != "" && *param.Get().DNSName != domainName {
+			continue
+		}
+
+		domains = append(domains, domainName)
+	}
+
+	if len(domains) == 0 {
+		fmt.Println("No DNSSEC signed domains found")
+		return WARNING
+	}
+
+	sort.Strings(domains)
+	exitcode := OK
+	for _, domainName := range domains {
+		domain := config.Get().DNS.Domains[domainName]
+		records, err := dns.DSRecords(domainName, domain.DNSSEC, domain.TTL)
+		if err != nil {
+			fmt.Printf("Error reading DNSSEC keys of %s: %s\n", domainName, err)
+			exitcode = CRITICAL
+			continue
+		}
+
+		for _, ds := range records {
+			fmt.Println(ds.String())
+		}
+	}
+
+	return exitcode
+}
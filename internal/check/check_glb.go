@@ -37,6 +37,7 @@ func otherNodes(nodes []string) (diff []string) {
 func checkLoadbalancerCount(dnsmanager map[string]dns.Domains) (int, error) {
 	clusterNodeCount := len(config.Get().Cluster.Nodes)
 	delete(dnsmanager, "localdns")                        // ignore local dns in GLB check
+	delete(dnsmanager, "dynamicdns")                      // ignore dynamic updates in GLB check
 	delete(dnsmanager, config.Get().Cluster.Binding.Name) // remove self
 	dnsManagerNodeCount := len(dnsmanager)
 	var faultyNodes []string
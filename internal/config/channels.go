@@ -62,5 +62,19 @@ type ClusterPacketClearProxyStatistics struct {
 	BackendName string `json:"backendname"`
 }
 
+// ClusterPacketDNSDynamicZone contains the dynamically updated records of a domain
+type ClusterPacketDNSDynamicZone struct {
+	Domain  string   `json:"domain"`
+	Version int64    `json:"version"`
+	Records []string `json:"records"`
+}
+
+// ClusterPacketDNSZoneSerial contains the serial of a domain, and the hash of the records it was given to
+type ClusterPacketDNSZoneSerial struct {
+	Domain string `json:"domain"`
+	Serial uint32 `json:"serial"`
+	Hash   string `json:"hash"`
+}
+
 // ClusterPacketConfigRequest is the packet type sent for configuration requests
 type ClusterPacketConfigRequest struct{}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"runtime"
 	"strings"
@@ -20,6 +21,7 @@ import (
 	"github.com/schubergphilis/mercury/pkg/logging"
 	"github.com/schubergphilis/mercury/pkg/param"
 	"github.com/schubergphilis/mercury/pkg/tlsconfig"
+	"github.com/schubergphilis/mercury/pkg/tracing"
 
 	"github.com/BurntSushi/toml"
 )
@@ -55,12 +57,13 @@ const (
 
 // Config holds your main config
 type Config struct {
-	Logging      LoggingConfig `toml:"logging" json:"logging"`
-	Cluster      Cluster       `toml:"cluster" json:"cluster"`
-	DNS          dns.Config    `toml:"dns" json:"dns"`
-	Settings     Settings      `toml:"settings" json:"settings"`
-	Loadbalancer Loadbalancer  `toml:"loadbalancer" json:"loadbalancer"`
-	Web          web.Config    `toml:"web" json:"web"`
+	Logging      LoggingConfig  `toml:"logging" json:"logging"`
+	Cluster      Cluster        `toml:"cluster" json:"cluster"`
+	DNS          dns.Config     `toml:"dns" json:"dns"`
+	Settings     Settings       `toml:"settings" json:"settings"`
+	Loadbalancer Loadbalancer   `toml:"loadbalancer" json:"loadbalancer"`
+	Web          web.Config     `toml:"web" json:"web"`
+	Tracing      tracing.Config `toml:"tracing" json:"tracing"`
 }
 
 // Cluster contains the cluster settings
@@ -154,6 +157,119 @@ func (c *Config) ParseConfig() error {
 		return err
 	}
 
+	// Check EDNS client subnet resolvers
+	for _, cidr := range c.DNS.ECSTrusted {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid client subnet trusted resolver network cidr:%s error:%s", cidr, err)
+		}
+	}
+
+	// Check response rate limiting
+	if err := dns.CheckRateLimit(c.DNS.RateLimit); err != nil {
+		return err
+	}
+
+	// Read records of domains from zone files, this is repeated on each reload
+	for domainName, domain := range c.DNS.Domains {
+		if domain.ZoneFile == "" {
+			continue
+		}
+
+		records, err := dns.ReadZoneFile(domainName, domain.ZoneFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read zone file of domain %s: %s", domainName, err)
+		}
+
+		domain.Records = append(domain.Records, records...)
+		c.DNS.Domains[domainName] = domain
+	}
+
+	// Check forward zones
+	for zoneName, zone := range c.DNS.ForwardZones {
+		if err := dns.CheckForwardZone(zoneName, zone); err != nil {
+			return err
+		}
+	}
+
+	// Check views, and read their zone files
+	for viewName, view := range c.DNS.Views {
+		if err := dns.CheckView(viewName, view); err != nil {
+			return err
+		}
+
+		for domainName, domain := range view.Domains {
+			if domain.ZoneFile == "" {
+				continue
+			}
+
+			records, err := dns.ReadZoneFile(domainName, domain.ZoneFile)
+			if err != nil {
+				return fmt.Errorf("Unable to read zone file of domain %s in view %s: %s", domainName, viewName, err)
+			}
+
+			domain.Records = append(domain.Records, records...)
+			view.Domains[domainName] = domain
+		}
+	}
+
+	// Check TSIG keys and zone transfers
+	for name, key := range c.DNS.TSIGKeys {
+		if err := dns.CheckTSIGKey(name, key); err != nil {
+			return err
+		}
+	}
+
+	for domainName, domain := range c.DNS.Domains {
+		if _, ok := c.DNS.TSIGKeys[domain.Transfer.TSIGKey]; domain.Transfer.TSIGKey != "" && !ok {
+			return fmt.Errorf("Unknown TSIG key %s for zone transfers of domain %s", domain.Transfer.TSIGKey, domainName)
+		}
+
+		if _, ok := c.DNS.TSIGKeys[domain.Secondary.TSIGKey]; domain.Secondary.TSIGKey != "" && !ok {
+			return fmt.Errorf("Unknown TSIG key %s for secondary domain %s", domain.Secondary.TSIGKey, domainName)
+		}
+
+		if len(domain.Secondary.Primaries) > 0 && len(domain.Records) > 0 {
+			return fmt.Errorf("Secondary domain %s can not have records, they are transferred from the primary", domainName)
+		}
+
+		for _, cidr := range domain.Transfer.Allow {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("Invalid zone transfer network for domain:%s cidr:%s error:%s", domainName, cidr, err)
+			}
+		}
+
+		if len(domain.Update.Allow) > 0 && domain.Update.TSIGKey == "" {
+			return fmt.Errorf("Dynamic updates of domain %s require a TSIG key", domainName)
+		}
+
+		if _, ok := c.DNS.TSIGKeys[domain.Update.TSIGKey]; domain.Update.TSIGKey != "" && !ok {
+			return fmt.Errorf("Unknown TSIG key %s for dynamic updates of domain %s", domain.Update.TSIGKey, domainName)
+		}
+
+		if len(domain.Secondary.Primaries) > 0 && domain.Update.TSIGKey != "" {
+			return fmt.Errorf("Secondary domain %s can not allow dynamic updates, they must be send to the primary", domainName)
+		}
+
+		if _, ok := c.DNS.TSIGKeys[domain.Push.TSIGKey]; domain.Push.TSIGKey != "" && !ok {
+			return fmt.Errorf("Unknown TSIG key %s for pushing GLB records of domain %s", domain.Push.TSIGKey, domainName)
+		}
+
+		for _, cidr := range domain.Update.Allow {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("Invalid dynamic update network for domain:%s cidr:%s error:%s", domainName, cidr, err)
+			}
+		}
+	}
+
+	// Check DNSSEC keys
+	for domainName, domain := range c.DNS.Domains {
+		if len(domain.DNSSEC.Keys) > 0 {
+			if err := dns.CheckDNSSEC(domainName, domain.DNSSEC); err != nil {
+				return fmt.Errorf("Invalid DNSSEC config for domain %s: %s", domainName, err)
+			}
+		}
+	}
+
 	// Loadbalance defaults
 	if c.Loadbalancer.Settings.DefaultLoadBalanceMethod == "" {
 		c.Loadbalancer.Settings.DefaultLoadBalanceMethod = "roundrobin"
@@ -204,6 +320,22 @@ func (c *Config) ParseConfig() error {
 			p.Listener.OCSPStapling = YES
 		}
 
+		for _, file := range p.Listener.CRLFiles {
+			if _, err := os.Stat(file); err != nil {
+				return fmt.Errorf("Cannot access CRL file for pool:%s file:%s error:%s", poolName, file, err)
+			}
+		}
+
+		if len(p.Listener.CRLFiles) > 0 && p.Listener.CRLRefresh == 0 {
+			p.Listener.CRLRefresh = 3600
+		}
+
+		for _, cidr := range p.Listener.TrustedProxies {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("Invalid trusted proxy network for pool:%s cidr:%s error:%s", poolName, cidr, err)
+			}
+		}
+
 		if p.Listener.MaxConnections == 0 {
 			p.Listener.MaxConnections = 2048
 		}
@@ -251,6 +383,52 @@ func (c *Config) ParseConfig() error {
 				h.DNSEntry.IP = c.Loadbalancer.Pools[poolName].Listener.IP
 			}
 
+			if backend.DNSEntry.DegradedTTL > 0 && backend.DNSEntry.DegradedWindow == 0 {
+				h.DNSEntry.DegradedWindow = 60
+			}
+
+			// service records default to the settings of the listener
+			if backend.DNSEntry.SRV || backend.DNSEntry.SVCB {
+				listener := c.Loadbalancer.Pools[poolName].Listener
+				if strings.Contains(backend.DNSEntry.HostName, "*") {
+					return fmt.Errorf("SRV and SVCB records are not supported for the wildcard dnsentry of pool:%s backend:%s", poolName, backendName)
+				}
+
+				if backend.DNSEntry.Service == "" && (listener.Mode == "http" || listener.Mode == "https") {
+					h.DNSEntry.Service = listener.Mode
+				}
+
+				if h.DNSEntry.Service == "" {
+					return fmt.Errorf("SRV and SVCB records of pool:%s backend:%s require a service name for listener mode %s", poolName, backendName, listener.Mode)
+				}
+
+				if backend.DNSEntry.Protocol == "" {
+					h.DNSEntry.Protocol = "tcp"
+					if listener.Mode == "udp" {
+						h.DNSEntry.Protocol = "udp"
+					}
+				}
+
+				if backend.DNSEntry.Port == 0 {
+					h.DNSEntry.Port = listener.Port
+				}
+
+				if len(backend.DNSEntry.ALPN) == 0 && h.DNSEntry.Service == "https" {
+					h.DNSEntry.ALPN = []string{"h2", "http/1.1"}
+					if listener.HTTPProto == 1 {
+						h.DNSEntry.ALPN = []string{"http/1.1"}
+					}
+				}
+			}
+
+			if backend.BalanceMode.Weight == 0 {
+				h.BalanceMode.Weight = 100
+			}
+
+			if err := dns.CheckOfflineFallback(backend.DNSEntry.OfflineFallback, backend.DNSEntry.FallbackIP, backend.DNSEntry.FallbackIP6); err != nil {
+				return fmt.Errorf("Invalid dnsentry for pool:%s backend:%s error:%s", poolName, backendName, err)
+			}
+
 			if backend.ErrorPage.File != "" {
 				if _, err := os.Stat(backend.ErrorPage.File); err != nil {
 					return fmt.Errorf("Cannot access error page for pool:%s backend:%s file:%s error:%s", poolName, backendName, backend.ErrorPage.File, err)
@@ -267,6 +445,10 @@ func (c *Config) ParseConfig() error {
 				}
 			}
 
+			if backend.ForwardAuth.URL != "" && backend.ForwardAuth.Timeout == 0 {
+				h.ForwardAuth.Timeout = 5
+			}
+
 			for hid, check := range c.Loadbalancer.Pools[poolName].Backends[backendName].HealthChecks {
 				h.HealthChecks[hid] = SetHealthCheckDefault(check)
 				if backend.BalanceMode.ActivePassive == YES {
@@ -459,24 +641,102 @@ func SetDefaultDNSConfig(d *dns.Config) {
 		d.Port = 53
 	}
 
+	if d.RateLimit.NXDomainsPerSecond < 1 {
+		d.RateLimit.NXDomainsPerSecond = d.RateLimit.ResponsesPerSecond
+	}
+
+	if d.RateLimit.ErrorsPerSecond < 1 {
+		d.RateLimit.ErrorsPerSecond = d.RateLimit.ResponsesPerSecond
+	}
+
+	if d.RateLimit.Window < 1 {
+		d.RateLimit.Window = 15
+	}
+
+	if d.RateLimit.Slip == 0 {
+		d.RateLimit.Slip = 2
+	}
+
+	if d.RateLimit.IPv4PrefixLength == 0 {
+		d.RateLimit.IPv4PrefixLength = 24
+	}
+
+	if d.RateLimit.IPv6PrefixLength == 0 {
+		d.RateLimit.IPv6PrefixLength = 56
+	}
+
+	if d.DoT.Port < 1 {
+		d.DoT.Port = 853
+	}
+
+	if d.DoH.Port < 1 {
+		d.DoH.Port = 443
+	}
+
+	if d.DoH.Path == "" {
+		d.DoH.Path = "/dns-query"
+	}
+
+	if d.ForwardCache.Size == 0 {
+		d.ForwardCache.Size = 10000
+	}
+
+	if d.ForwardCache.MaxTTL < 1 {
+		d.ForwardCache.MaxTTL = 86400
+	}
+
+	if d.ForwardCache.NegativeTTL < 1 {
+		d.ForwardCache.NegativeTTL = 3600
+	}
+
+	if d.DynamicStore == "" {
+		d.DynamicStore = "/var/lib/mercury/dns_dynamic.json"
+	}
+
+	for zoneName, zone := range d.ForwardZones {
+		if zone.Timeout < 1 {
+			zone.Timeout = 2
+			d.ForwardZones[zoneName] = zone
+		}
+	}
+
+	if d.SerialStore == "" {
+		d.SerialStore = "/var/lib/mercury/dns_serials.json"
+	}
+
 	if len(d.AllowedRequests) == 0 {
 		// Allow the most common DNS request types
 		d.AllowedRequests = []string{"A", "AAAA", "NS", "MX", "SOA", "TXT", "CAA", "ANY", "CNAME", "MB", "MG", "MR", "WKS", "PTR", "HINFO", "MINFO", "SPF"}
 	}
 
 	for domainName, localDomain := range d.Domains {
-		for rid, record := range localDomain.Records {
+		setDefaultRecords(domainName, localDomain.Records)
 
-			// Add UUID to static records, dynamic ones are auto-generated
-			if record.Statistics == nil {
-				hash := sha256.New()
-				hash.Write([]byte(fmt.Sprintf("%s-%s-%x-%s", domainName, record.Name, record.Type, record.Target)))
-				uuid := fmt.Sprintf("%x", hash.Sum(nil))
-				d.Domains[domainName].Records[rid].UUID = uuid
-				d.Domains[domainName].Records[rid].Statistics = balancer.NewStatistics(uuid, 0)
-				if d.Domains[domainName].Records[rid].LocalNetwork != "" {
-					d.Domains[domainName].Records[rid].Statistics.Topology = []string{d.Domains[domainName].Records[rid].LocalNetwork}
-				}
+		// Signatures are valid for a week by default
+		if len(localDomain.DNSSEC.Keys) > 0 && localDomain.DNSSEC.SignatureValidity < 1 {
+			localDomain.DNSSEC.SignatureValidity = 604800
+			d.Domains[domainName] = localDomain
+		}
+	}
+
+	for viewName, view := range d.Views {
+		for domainName, viewDomain := range view.Domains {
+			setDefaultRecords(viewName+"/"+domainName, viewDomain.Records)
+		}
+	}
+}
+
+// setDefaultRecords adds the UUID and statistics to static records, dynamic ones are auto-generated
+func setDefaultRecords(prefix string, records []dns.Record) {
+	for rid, record := range records {
+		if record.Statistics == nil {
+			hash := sha256.New()
+			hash.Write([]byte(fmt.Sprintf("%s-%s-%x-%s", prefix, record.Name, record.Type, record.Target)))
+			uuid := fmt.Sprintf("%x", hash.Sum(nil))
+			records[rid].UUID = uuid
+			records[rid].Statistics = balancer.NewStatistics(uuid, 0)
+			if records[rid].LocalNetwork != "" {
+				records[rid].Statistics.Topology = []string{records[rid].LocalNetwork}
 			}
 		}
 	}
@@ -564,6 +824,19 @@ func (c *Config) ValidateCertificates() error {
 			return fmt.Errorf("Could not load TLS configuration for Mercury Cluster Service: %s", err)
 		}
 	}
+
+	// Test DNS-over-TLS and DNS-over-HTTPS Certificates
+	if c.DNS.DoT.TLSConfig.CertificateProvided() {
+		if err := c.DNS.DoT.TLSConfig.Valid(); err != nil {
+			return fmt.Errorf("Could not load TLS configuration for DNS-over-TLS: %s", err)
+		}
+	}
+
+	if c.DNS.DoH.TLSConfig.CertificateProvided() {
+		if err := c.DNS.DoH.TLSConfig.Valid(); err != nil {
+			return fmt.Errorf("Could not load TLS configuration for DNS-over-HTTPS: %s", err)
+		}
+	}
 	return nil
 }
 
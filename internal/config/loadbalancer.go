@@ -53,6 +53,10 @@ type LoadbalancerListener struct {
 	ReadTimeout    int                  `json:"readtimeout" toml:"readtimeout" yaml:"readtimeout"`          // read timeout on client reply to server
 	HTTPProto      int                  `json:"httpproto" toml:"httpproto" yaml:"httpproto"`                // force HTP protocol (1 = http/1.x 2 = http/2)
 	OCSPStapling   string               `json:"ocspstapling" toml:"ocspstapling" yaml:"ocspstapling"`       // Enable/Disable OCSP Stapling
+	CRLFiles       []string             `json:"crlfiles" toml:"crlfiles" yaml:"crlfiles"`                   // CRL files to check client certificates against
+	CRLRefresh     int                  `json:"crlrefresh" toml:"crlrefresh" yaml:"crlrefresh"`             // interval in seconds to reload the CRL files
+	ClientOCSP     string               `json:"clientocsp" toml:"clientocsp" yaml:"clientocsp"`             // Enable/Disable OCSP checks of client certificates
+	TrustedProxies []string             `json:"trustedproxies" toml:"trustedproxies" yaml:"trustedproxies"` // networks of proxies to trust the X-Forwarded-For and Forwarded headers of
 	//Error          string              `json:"error" toml:"error"` // error??? - not used
 }
 
@@ -66,10 +70,106 @@ type BackendNode struct {
 
 // DNSEntry for GLB
 type DNSEntry struct {
-	HostName string `json:"hostname" toml:"hostname"`
-	Domain   string `json:"domain" toml:"domain"`
-	IP       string `json:"ip" toml:"ip"`
-	IP6      string `json:"ip6" toml:"ip6"`
+	HostName        string   `json:"hostname" toml:"hostname"`
+	Domain          string   `json:"domain" toml:"domain"`
+	IP              string   `json:"ip" toml:"ip"`
+	IP6             string   `json:"ip6" toml:"ip6"`
+	DegradedTTL     int      `json:"degraded_ttl" toml:"degraded_ttl"`         // ttl while some targets are offline or recently changed status
+	DegradedWindow  int      `json:"degraded_window" toml:"degraded_window"`   // seconds after a status change that the degraded ttl is used
+	MaxAnswers      int      `json:"max_answers" toml:"max_answers"`           // maximum amount of records in a reply after balancing (0 is all)
+	OfflineFallback string   `json:"offline_fallback" toml:"offline_fallback"` // reply if all records are offline: offline, nxdomain, servfail or static
+	FallbackIP      string   `json:"fallback_ip" toml:"fallback_ip"`           // ip of the static offline fallback
+	FallbackIP6     string   `json:"fallback_ip6" toml:"fallback_ip6"`         // ipv6 of the static offline fallback
+	SRV             bool     `json:"srv" toml:"srv"`                           // publish a SRV record of the listener
+	SVCB            bool     `json:"svcb" toml:"svcb"`                         // publish a HTTPS record for https, or a SVCB record for other services
+	Service         string   `json:"service" toml:"service"`                   // service name of the SRV and SVCB records (defaults to the listener mode for http and https)
+	Protocol        string   `json:"protocol" toml:"protocol"`                 // protocol of the SRV record (defaults to the protocol of the listener)
+	Port            int      `json:"port" toml:"port"`                         // port of the SRV and SVCB records (defaults to the listener port)
+	ALPN            []string `json:"alpn" toml:"alpn"`                         // protocols of the SVCB record (defaults to the http versions of https listeners)
+}
+
+// glbName returns the fully qualified name of the A and AAAA records
+func (e DNSEntry) glbName() string {
+	if e.HostName == "" {
+		return strings.TrimSuffix(e.Domain, ".") + "."
+	}
+
+	return e.HostName + "." + strings.TrimSuffix(e.Domain, ".") + "."
+}
+
+// subName returns the name of a record below the hostname
+func (e DNSEntry) subName(prefix string) string {
+	if e.HostName == "" {
+		return prefix
+	}
+
+	return prefix + "." + e.HostName
+}
+
+// SRVName returns the name of the SRV record
+func (e DNSEntry) SRVName() string {
+	return e.subName(fmt.Sprintf("_%s._%s", e.Service, e.Protocol))
+}
+
+// SVCBType returns the type of the service binding record, HTTPS for the https service and SVCB for others
+func (e DNSEntry) SVCBType() string {
+	if e.Service == "https" {
+		return "HTTPS"
+	}
+
+	return "SVCB"
+}
+
+// SVCBName returns the name of the service binding record
+func (e DNSEntry) SVCBName() string {
+	if e.SVCBType() == "HTTPS" {
+		return e.HostName
+	}
+
+	return e.subName("_" + e.Service)
+}
+
+// SRVTarget returns the data of the SRV record, its priority and weight come from the balance settings
+func (e DNSEntry) SRVTarget(balance BalanceMode) string {
+	return fmt.Sprintf("%d %d %d %s", balance.Preference, balance.Weight, e.Port, e.glbName())
+}
+
+// SVCBTarget returns the data of the service binding record, its priority comes from the balance preference
+func (e DNSEntry) SVCBTarget(balance BalanceMode) string {
+	target := e.glbName()
+	if e.SVCBType() == "HTTPS" {
+		target = "."
+	}
+
+	params := []string{fmt.Sprintf("%d", balance.Preference+1), target}
+	if len(e.ALPN) > 0 {
+		params = append(params, "alpn="+strings.Join(e.ALPN, ","))
+	}
+
+	params = append(params, fmt.Sprintf("port=%d", e.Port))
+	if e.IP != "" {
+		params = append(params, "ipv4hint="+e.IP)
+	}
+
+	if e.IP6 != "" {
+		params = append(params, "ipv6hint="+e.IP6)
+	}
+
+	return strings.Join(params, " ")
+}
+
+// Names returns the names of all records published for the dns entry
+func (e DNSEntry) Names() []string {
+	names := []string{e.HostName}
+	if e.SRV {
+		names = append(names, e.SRVName())
+	}
+
+	if e.SVCB && e.SVCBName() != e.HostName {
+		names = append(names, e.SVCBName())
+	}
+
+	return names
 }
 
 // BackendPool nodes and details
@@ -90,6 +190,7 @@ type BackendPool struct {
 	Crossconnects   bool                      `json:"crossconnects" toml:"crossconnects"`     // allow cluster cross-connects (e.g. each server can connect to all backends)
 	ErrorPage       proxy.ErrorPage           `json:"errorpage" toml:"errorpage"`             // alternative error page to show
 	MaintenancePage proxy.ErrorPage           `json:"maintenancepage" toml:"maintenancepage"` // alternative maintenance page to show
+	ForwardAuth     proxy.ForwardAuth         `json:"forwardauth" toml:"forwardauth"`         // authentication service to verify requests with
 }
 
 // BalanceMode Which type of loadbalancing to use
@@ -102,6 +203,7 @@ type BalanceMode struct {
 	ClusterNodes        int      `json:"clusternodes" toml:"clusternodes"`                   // Depricated: affects monitoring only: how many cluster nodes serve this backend
 	ServingClusterNodes int      `json:"serving_cluster_nodes" toml:"serving_cluster_nodes"` // affects monitoring only: how many cluster nodes serve this backend
 	ServingBackendNodes int      `json:"serving_backend_nodes" toml:"serving_backend_nodes"` // affects monitoring only: how many backend nodes serve this backend
+	Weight              int      `json:"weight" toml:"weight"`                               // weight of the SRV records of the backend
 }
 
 // Network Contains network information
@@ -52,3 +52,37 @@ func TestLoadbalancerConfig(t *testing.T) {
 		t.Errorf("Expected addr.SafeName() of %+v to return 192_168_1_1 (got:%s)", addr, val)
 	}
 }
+
+func TestDNSEntryServiceRecords(t *testing.T) {
+	entry := DNSEntry{HostName: "www", Domain: "example.com", IP: "192.0.2.1", SRV: true, SVCB: true, Service: "https", Protocol: "tcp", Port: 443, ALPN: []string{"h2", "http/1.1"}}
+	balance := BalanceMode{Preference: 1, Weight: 100}
+
+	if val := entry.SRVName(); val != "_https._tcp.www" {
+		t.Errorf("Expected SRV name of %+v to be _https._tcp.www (got:%s)", entry, val)
+	}
+
+	if val := entry.SRVTarget(balance); val != "1 100 443 www.example.com." {
+		t.Errorf("Expected SRV target of %+v to be 1 100 443 www.example.com. (got:%s)", entry, val)
+	}
+
+	if val := entry.SVCBType(); val != "HTTPS" {
+		t.Errorf("Expected service binding of %+v to be HTTPS (got:%s)", entry, val)
+	}
+
+	if val := entry.SVCBTarget(balance); val != "2 . alpn=h2,http/1.1 port=443 ipv4hint=192.0.2.1" {
+		t.Errorf("Expected HTTPS target of %+v to be 2 . alpn=h2,http/1.1 port=443 ipv4hint=192.0.2.1 (got:%s)", entry, val)
+	}
+
+	if val := entry.Names(); len(val) != 2 || val[1] != "_https._tcp.www" {
+		t.Errorf("Expected names of %+v to be www and _https._tcp.www (got:%v)", entry, val)
+	}
+
+	entry = DNSEntry{HostName: "", Domain: "example.com", SVCB: true, Service: "ldap", Port: 636}
+	if val := entry.SVCBName(); val != "_ldap" {
+		t.Errorf("Expected SVCB name of %+v to be _ldap (got:%s)", entry, val)
+	}
+
+	if val := entry.SVCBTarget(balance); val != "2 example.com. port=636" {
+		t.Errorf("Expected SVCB target of %+v to be 2 example.com. port=636 (got:%s)", entry, val)
+	}
+}
@@ -86,6 +86,13 @@ func (m *Manager) setupAPI() {
 		template:      "healthchecks",
 	})
 
+	// DNS forward cache and zone export
+	http.Handle("/api/v1/dns/admin/", authenticate(apiDNSAdminHandler{}, string(APITokenSigningKey)))
+	http.Handle("/api/v1/dns/cache", apiDNSCachePublicHandler{})
+	http.Handle("/api/v1/dns/ratelimit", apiDNSRateLimitPublicHandler{})
+	http.Handle("/api/v1/dns/upstreams", apiDNSUpstreamsPublicHandler{})
+	http.Handle("/api/v1/dns/stats", authenticate(apiDNSStatsAdminHandler{}, string(APITokenSigningKey)))
+
 	// Enable login
 	http.Handle("/api/v1/login/", apiLoginHandler{manager: m})
 	http.Handle("/login/", webLoginHandler{
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/dns"
+)
+
+// Public API
+type apiDNSCachePublicHandler struct{}
+
+// Public API returns the statistics of the forward cache
+func (h apiDNSCachePublicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apiWriteData(w, http.StatusOK, apiMessage{Success: true, Data: dns.ForwardCacheStats()})
+}
+
+// Public API
+type apiDNSRateLimitPublicHandler struct{}
+
+// Public API returns the counters of response rate limiting
+func (h apiDNSRateLimitPublicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apiWriteData(w, http.StatusOK, apiMessage{Success: true, Data: dns.RateLimitCounters()})
+}
+
+// Public API
+type apiDNSUpstreamsPublicHandler struct{}
+
+// Public API returns the health of the upstreams of forward zones
+func (h apiDNSUpstreamsPublicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apiWriteData(w, http.StatusOK, apiMessage{Success: true, Data: dns.ForwardZoneStats()})
+}
+
+// Authorized personel only, the statistics contain the names requested and the networks of clients
+type apiDNSStatsAdminHandler struct{}
+
+// Authorized personel only returns the statistics of the dns queries of a time window
+func (h apiDNSStatsAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	window, top, err := queryStatsRequest(r)
+	if err != nil {
+		apiWriteData(w, http.StatusBadRequest, apiMessage{Success: false, Error: err.Error()})
+		return
+	}
+
+	apiWriteData(w, http.StatusOK, apiMessage{Success: true, Data: dns.GetQueryStats(window, top)})
+}
+
+// queryStatsRequest returns the time window (default 5m) and the amount of top names and client networks (default 10) of a request for query statistics
+func queryStatsRequest(r *http.Request) (window time.Duration, top int, err error) {
+	window = 5 * time.Minute
+	if value := r.URL.Query().Get("window"); value != "" {
+		if window, err = time.ParseDuration(value); err != nil {
+			return 0, 0, fmt.Errorf("Invalid window: %s", value)
+		}
+	}
+
+	top = 10
+	if value := r.URL.Query().Get("top"); value != "" {
+		if top, err = strconv.Atoi(value); err != nil || top < 1 {
+			return 0, 0, fmt.Errorf("Invalid top: %s", value)
+		}
+	}
+
+	return window, top, nil
+}
+
+// Authorized personel only
+type apiDNSAdminHandler struct{}
+
+// Private API executes commands on the forward cache, and exports zones
+func (h apiDNSAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	//                             1   2  3   4     5     6      7
+	// expect a url in the format: api v1 dns admin cache ACTION [NAME]
+	// where action is flush, and name optionally limits the flush to a single name
+	// or a url in the format: api v1 dns admin zone DOMAIN
+	path := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	if len(path) < 7 {
+		apiWriteData(w, 405, apiMessage{Success: false, Error: "invalid request"})
+		return
+	}
+
+	switch path[5] {
+	case "cache":
+		h.cache(w, r, path)
+	case "zone":
+		h.zone(w, r, path[6])
+	default:
+		apiWriteData(w, 405, apiMessage{Success: false, Error: "invalid request"})
+	}
+}
+
+// cache executes commands on the forward cache
+func (h apiDNSAdminHandler) cache(w http.ResponseWriter, r *http.Request, path []string) {
+	if r.Method != "POST" {
+		apiWriteData(w, 405, apiMessage{Success: false, Error: "invalid request"})
+		return
+	}
+
+	switch path[6] {
+	case "flush":
+		name := ""
+		if len(path) > 7 {
+			name = path[7]
+		}
+
+		removed := dns.FlushForwardCache(name)
+		apiWriteData(w, http.StatusOK, apiMessage{Success: true, Data: fmt.Sprintf("%d entries removed", removed)})
+	default:
+		apiWriteData(w, 405, apiMessage{Success: false, Error: fmt.Sprintf("unknown action: %s", path[6])})
+	}
+}
+
+// zone writes the records currently served for a domain as a zone file
+func (h apiDNSAdminHandler) zone(w http.ResponseWriter, r *http.Request, domainName string) {
+	if r.Method != "GET" {
+		apiWriteData(w, 405, apiMessage{Success: false, Error: "invalid request"})
+		return
+	}
+
+	zone, err := dns.ZoneFile(domainName)
+	if err != nil {
+		apiWriteData(w, http.StatusNotFound, apiMessage{Success: false, Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/dns; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(zone))
+}
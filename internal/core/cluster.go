@@ -37,6 +37,8 @@ func (manager *Manager) ClusterClient(cl *cluster.Manager) {
 			manager.dnsdiscard <- node
 
 			go clusterDNSUpdateSingleBroadcastAll(cl, node)
+			go clusterDNSDynamicSendAll(cl, node)
+			go clusterDNSSerialSendAll(cl, node)
 
 		case node := <-cl.NodeLeave:
 			log.WithField("func", "core").Debug("Leave")
@@ -57,6 +59,8 @@ func (manager *Manager) ClusterClient(cl *cluster.Manager) {
 				// Ignore config requests from self
 				log.WithField("client", packet.Name).WithField("request", packet.DataType).Info("Sending config")
 				go clusterDNSUpdateSingleBroadcastAll(cl, packet.Name)
+				go clusterDNSDynamicSendAll(cl, packet.Name)
+				go clusterDNSSerialSendAll(cl, packet.Name)
 
 			case "config.ClusterPacketGlobalDNSUpdate":
 				log.WithField("func", "core").Debug("globalDNSUpdate")
@@ -82,6 +86,30 @@ func (manager *Manager) ClusterClient(cl *cluster.Manager) {
 				log.WithField("func", "dns").WithField("client", packet.Name).WithField("request", packet.DataType).WithField("cluster", dnsremove.ClusterNode).WithField("domain", dnsremove.Domain).WithField("hostname", dnsremove.Hostname).Info("Received cluster dns removal")
 				manager.dnsremove <- dnsremove
 
+			case "config.ClusterPacketDNSDynamicZone":
+				log.WithField("func", "core").Debug("dnsDynamicZone")
+				zone := &config.ClusterPacketDNSDynamicZone{}
+				err := packet.Message(zone)
+				if err != nil {
+					log.Warnf("Unable to parse ClusterPacketDNSDynamicZone request: %s", err.Error())
+					continue
+				}
+
+				log.WithField("func", "dns").WithField("client", packet.Name).WithField("request", packet.DataType).WithField("domain", zone.Domain).WithField("version", zone.Version).Debug("Received cluster dynamic dns records")
+				dns.ApplyDynamicZone(dns.DynamicZone{Domain: zone.Domain, Version: zone.Version, Records: zone.Records})
+
+			case "config.ClusterPacketDNSZoneSerial":
+				log.WithField("func", "core").Debug("dnsZoneSerial")
+				serial := &config.ClusterPacketDNSZoneSerial{}
+				err := packet.Message(serial)
+				if err != nil {
+					log.Warnf("Unable to parse ClusterPacketDNSZoneSerial request: %s", err.Error())
+					continue
+				}
+
+				log.WithField("func", "dns").WithField("client", packet.Name).WithField("request", packet.DataType).WithField("domain", serial.Domain).WithField("serial", serial.Serial).Debug("Received cluster zone serial")
+				dns.ApplyZoneSerial(dns.ZoneSerial{Domain: serial.Domain, Serial: serial.Serial, Hash: serial.Hash})
+
 			case "config.ClusterPacketGlbalDNSStatisticsUpdate":
 				log.WithField("func", "core").Debug("globalDNSStatistics")
 				su := &config.ClusterPacketGlbalDNSStatisticsUpdate{}
@@ -130,7 +158,7 @@ func (manager *Manager) ClusterClient(cl *cluster.Manager) {
 				PoolName:          proxyBackendStatistics.PoolName,
 				ClusterNode:       config.Get().Cluster.Binding.Name,
 				UUID:              proxyBackendStatistics.Statistics.UUID,
-				ClientsConnected:  proxyBackendStatistics.Statistics.ClientsConnected,
+				ClientsConnected:  proxyBackendStatistics.Statistics.ClientsConnectedGet(),
 				ClientsConnects:   proxyBackendStatistics.Statistics.ClientsConnects,
 				RX:                proxyBackendStatistics.Statistics.RX,
 				TX:                proxyBackendStatistics.Statistics.TX,
@@ -178,6 +206,14 @@ func (manager *Manager) ClusterClient(cl *cluster.Manager) {
 			// Send update to DNS
 			go manager.sendDNSUpdate(cl, healthcheck.PoolName, healthcheck.BackendName)
 
+		case zone := <-dns.DynamicChanges():
+			log.WithField("func", "dns").WithField("domain", zone.Domain).WithField("version", zone.Version).Info("Sending dynamic dns records to cluster")
+			go clusterDNSDynamicBroadcast(cl, zone)
+
+		case serial := <-dns.SerialChanges():
+			log.WithField("func", "dns").WithField("domain", serial.Domain).WithField("serial", serial.Serial).Debug("Sending zone serial to cluster")
+			go clusterDNSSerialBroadcast(cl, serial)
+
 		case _ = <-manager.dnsrefresh:
 			// On a reload refresh existing and remove unused dns entries
 			log.WithField("func", "core").Debug("dnsreload")
@@ -203,9 +239,11 @@ func (manager *Manager) dnsRefresh(cl *cluster.Manager) {
 
 	for poolName := range config.Get().Loadbalancer.Pools {
 		for backendName, backend := range config.Get().Loadbalancer.Pools[poolName].Backends {
-			for i := len(existingEntries[backend.DNSEntry.Domain]) - 1; i >= 0; i-- {
-				if existingEntries[backend.DNSEntry.Domain][i] == backend.DNSEntry.HostName {
-					existingEntries[backend.DNSEntry.Domain] = append(existingEntries[backend.DNSEntry.Domain][:i], existingEntries[backend.DNSEntry.Domain][i+1:]...)
+			for _, name := range backend.DNSEntry.Names() {
+				for i := len(existingEntries[backend.DNSEntry.Domain]) - 1; i >= 0; i-- {
+					if existingEntries[backend.DNSEntry.Domain][i] == name {
+						existingEntries[backend.DNSEntry.Domain] = append(existingEntries[backend.DNSEntry.Domain][:i], existingEntries[backend.DNSEntry.Domain][i+1:]...)
+					}
 				}
 			}
 			manager.sendDNSUpdate(cl, poolName, backendName)
@@ -299,6 +337,7 @@ func (manager *Manager) updateProxyBackendNode(poolName string, backendName stri
 func (manager *Manager) InitializeCluster() {
 	cluster.ChannelBufferSize = 100
 	cl := cluster.NewManager(config.Get().Cluster.Binding.Name, config.Get().Cluster.Binding.AuthKey)
+	dns.SetElection(cl.Elected)
 	configured := cl.NodesConfigured()
 	for _, node := range config.Get().Cluster.Nodes {
 		if _, ok := configured[node.Name]; ok {
@@ -470,3 +509,23 @@ func clusterDNSUpdateBroadcast(cl *cluster.Manager, clusterNode string, poolName
 	}
 	cl.ToCluster <- dnsupdate
 }
+
+func clusterDNSDynamicBroadcast(cl *cluster.Manager, zone dns.DynamicZone) {
+	cl.ToCluster <- config.ClusterPacketDNSDynamicZone{Domain: zone.Domain, Version: zone.Version, Records: zone.Records}
+}
+
+func clusterDNSDynamicSendAll(cl *cluster.Manager, client string) {
+	for _, zone := range dns.DynamicZones() {
+		cl.ToNode <- cluster.NodeMessage{Node: client, Message: config.ClusterPacketDNSDynamicZone{Domain: zone.Domain, Version: zone.Version, Records: zone.Records}}
+	}
+}
+
+func clusterDNSSerialBroadcast(cl *cluster.Manager, serial dns.ZoneSerial) {
+	cl.ToCluster <- config.ClusterPacketDNSZoneSerial{Domain: serial.Domain, Serial: serial.Serial, Hash: serial.Hash}
+}
+
+func clusterDNSSerialSendAll(cl *cluster.Manager, client string) {
+	for _, serial := range dns.ZoneSerials() {
+		cl.ToNode <- cluster.NodeMessage{Node: client, Message: config.ClusterPacketDNSZoneSerial{Domain: serial.Domain, Serial: serial.Serial, Hash: serial.Hash}}
+	}
+}
@@ -39,6 +39,10 @@ func (manager *Manager) DNSHandler() {
 				Statistics:          stats,
 				UUID:                dnsupdate.BackendUUID,
 				Status:              healthcheckStatusToDNSStatus(dnsupdate.Status),
+				DegradedTTL:         dnsupdate.DNSEntry.DegradedTTL,
+				DegradedWindow:      dnsupdate.DNSEntry.DegradedWindow,
+				MaxAnswers:          dnsupdate.DNSEntry.MaxAnswers,
+				OfflineFallback:     dnsupdate.DNSEntry.OfflineFallback,
 			}
 			// TODO: pass record type along, and get rid of ipv6/ipv4 seperation
 			clog := log.WithField("hostname", dnsupdate.DNSEntry.HostName).WithField("domain", dnsupdate.DNSEntry.Domain).WithField("cluster", dnsupdate.ClusterNode).WithField("backend", dnsupdate.BackendName).WithField("uuid", dnsupdate.BackendUUID).WithField("status", dnsupdate.Status)
@@ -46,6 +50,7 @@ func (manager *Manager) DNSHandler() {
 			if dnsupdate.DNSEntry.IP != "" {
 				record.Type = "A"
 				record.Target = dnsupdate.DNSEntry.IP
+				record.FallbackTarget = dnsupdate.DNSEntry.FallbackIP
 				clog.WithField("target", dnsupdate.DNSEntry.IP).Debug("Received DNS update from cluster")
 				dns.Update(dnsupdate.ClusterNode, dnsupdate.DNSEntry.Domain, record)
 			}
@@ -53,9 +58,27 @@ func (manager *Manager) DNSHandler() {
 			if dnsupdate.DNSEntry.IP6 != "" {
 				record.Type = "AAAA"
 				record.Target = dnsupdate.DNSEntry.IP6
+				record.FallbackTarget = dnsupdate.DNSEntry.FallbackIP6
 				clog.WithField("target", dnsupdate.DNSEntry.IP6).Debug("Received DNS update from cluster")
 				dns.Update(dnsupdate.ClusterNode, dnsupdate.DNSEntry.Domain, record)
 			}
+			// Create service records of the listener if requested, they have the status of the backend like the A and AAAA records
+			record.FallbackTarget = ""
+			if dnsupdate.DNSEntry.SRV {
+				record.Name = dnsupdate.DNSEntry.SRVName()
+				record.Type = "SRV"
+				record.Target = dnsupdate.DNSEntry.SRVTarget(dnsupdate.BalanceMode)
+				clog.WithField("target", record.Target).Debug("Received DNS update from cluster")
+				dns.Update(dnsupdate.ClusterNode, dnsupdate.DNSEntry.Domain, record)
+			}
+
+			if dnsupdate.DNSEntry.SVCB {
+				record.Name = dnsupdate.DNSEntry.SVCBName()
+				record.Type = dnsupdate.DNSEntry.SVCBType()
+				record.Target = dnsupdate.DNSEntry.SVCBTarget(dnsupdate.BalanceMode)
+				clog.WithField("target", record.Target).Debug("Received DNS update from cluster")
+				dns.Update(dnsupdate.ClusterNode, dnsupdate.DNSEntry.Domain, record)
+			}
 
 		case dnsStatistics := <-manager.clusterGlbalDNSStatisticsUpdate:
 			log.Debugf("Received DNS statistics from DNS manager")
@@ -90,7 +113,9 @@ func (manager Manager) InitializeDNSUpdates() {
 
 // StartDNSServer starts the dns server
 func (manager Manager) StartDNSServer() {
+	dns.SetTSIGKeys(config.Get().DNS.TSIGKeys)
 	go dns.Server(config.Get().DNS.Binding, config.Get().DNS.Port, config.Get().DNS.AllowedRequests)
+	go dns.EncryptedServer(config.Get().DNS.Binding, config.Get().DNS.DoT, config.Get().DNS.DoH)
 }
 
 // UpdateDNSConfig adds new records, and removes obsolete records
@@ -98,10 +123,29 @@ func UpdateDNSConfig() {
 	log := logging.For("core/updatednsconfig").WithField("func", "dns")
 	log.WithField("hosts", fmt.Sprintf("%v", config.Get().DNS.AllowForwarding)).Info("Initializing DNS Forwarder")
 	dns.AllowForwarding(config.Get().DNS.AllowForwarding)
+	dns.SetForwardCache(config.Get().DNS.ForwardCache)
+	dns.SetForwardZones(config.Get().DNS.ForwardZones)
+	dns.SetRateLimit(config.Get().DNS.RateLimit)
+
+	log.WithField("hosts", fmt.Sprintf("%v", config.Get().DNS.ECSTrusted)).Info("Initializing DNS client subnet trusted resolvers")
+	dns.TrustClientSubnet(config.Get().DNS.ECSTrusted)
+	dns.UpdateDNSSEC(config.Get().DNS.Domains)
+	dns.UpdateTransfers(config.Get().DNS.Domains)
+	dns.UpdateSecondaries(config.Get().DNS.Domains)
+	dns.SetDynamicStore(config.Get().DNS.DynamicStore)
+	dns.UpdateDynamic(config.Get().DNS.Domains)
+	dns.UpdatePushes(config.Get().DNS.Domains)
+	dns.SetSerialStore(config.Get().DNS.SerialStore)
+	dns.UpdateViews(config.Get().DNS.Views)
 
 	log.Info("Initializing DNS Config Updates")
 	// Loop through all manual entries in the config
 	for domainName, domain := range config.Get().DNS.Domains {
+		// Records of secondary domains are managed by the transfer from their primary
+		if len(domain.Secondary.Primaries) > 0 {
+			continue
+		}
+
 		// Get all current records
 		allRecords := dns.GetAllLocalDomainRecords(domainName)
 
@@ -9,6 +9,7 @@ import (
 	"github.com/schubergphilis/mercury/pkg/cluster"
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
 	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/schubergphilis/mercury/pkg/tracing"
 )
 
 const (
@@ -59,6 +60,9 @@ func Initialize(reload <-chan bool) {
 
 	manager := NewManager()
 
+	// Tracing of proxied requests
+	tracing.Configure(config.Get().Tracing)
+
 	// Create IP's
 	CreateListeners()
 
@@ -100,6 +104,8 @@ func Initialize(reload <-chan bool) {
 			log.WithField("memory", fmt.Sprintf("%5.2fk", float64(stats.Alloc)/1024)).Infof("Memory usage before reload")
 			// Reload log level
 			go logging.Configure(config.Get().Logging.Output, config.Get().Logging.Level)
+			// Reload tracing
+			go tracing.Configure(config.Get().Tracing)
 			// Create new listeners if any
 			CreateListeners()
 			// Start new DNS Listeners (if changed)
@@ -100,6 +100,9 @@ func (manager *Manager) InitializeProxies() {
 				existingProxy.ReadTimeout != pool.Listener.ReadTimeout ||
 				existingProxy.WriteTimeout != pool.Listener.WriteTimeout ||
 				existingProxy.OCSPStapling != pool.Listener.OCSPStapling ||
+				!reflect.DeepEqual(existingProxy.CRLFiles, pool.Listener.CRLFiles) ||
+				existingProxy.CRLRefresh != pool.Listener.CRLRefresh ||
+				existingProxy.ClientOCSP != pool.Listener.ClientOCSP ||
 				!reflect.DeepEqual(existingTLS.CipherSuites, newTLS.CipherSuites) ||
 				!reflect.DeepEqual(existingTLS.CurvePreferences, newTLS.CurvePreferences) ||
 				!reflect.DeepEqual(existingTLS.Certificates, newTLS.Certificates) ||
@@ -109,7 +112,7 @@ func (manager *Manager) InitializeProxies() {
 			if listenerChanged {
 				// Interface changes, we need to restart the proxy, lets stop it
 				certchange := !reflect.DeepEqual(existingTLS.Certificates, newTLS.Certificates)
-				log.WithField("pool", poolname).Debugf("listener changed - mode:%t ip:%t port:%t, maxcon:%t readtimeout:%t writetimeout:%t ocsp:%t cert:%t cypher:%t curve:%t clientauth:%t",
+				log.WithField("pool", poolname).Debugf("listener changed - mode:%t ip:%t port:%t, maxcon:%t readtimeout:%t writetimeout:%t ocsp:%t revocation:%t cert:%t cypher:%t curve:%t clientauth:%t",
 					existingProxy.ListenerMode != pool.Listener.Mode,
 					existingProxy.IP != pool.Listener.IP,
 					existingProxy.Port != pool.Listener.Port,
@@ -117,6 +120,7 @@ func (manager *Manager) InitializeProxies() {
 					existingProxy.ReadTimeout != pool.Listener.ReadTimeout,
 					existingProxy.WriteTimeout != pool.Listener.WriteTimeout,
 					existingProxy.OCSPStapling != pool.Listener.OCSPStapling,
+					!reflect.DeepEqual(existingProxy.CRLFiles, pool.Listener.CRLFiles) || existingProxy.CRLRefresh != pool.Listener.CRLRefresh || existingProxy.ClientOCSP != pool.Listener.ClientOCSP,
 					certchange,
 					!reflect.DeepEqual(existingTLS.CipherSuites, newTLS.CipherSuites),
 					!reflect.DeepEqual(existingTLS.CurvePreferences, newTLS.CurvePreferences),
@@ -124,6 +128,7 @@ func (manager *Manager) InitializeProxies() {
 				log.WithField("pool", poolname).Info("Restarting existing proxy for new listener settings")
 				existingProxy.Stop()
 				existingProxy.SetListener(pool.Listener.Mode, pool.Listener.SourceIP, pool.Listener.IP, pool.Listener.Port, pool.Listener.MaxConnections, newTLS, pool.Listener.ReadTimeout, pool.Listener.WriteTimeout, pool.Listener.HTTPProto, pool.Listener.OCSPStapling)
+				existingProxy.SetClientRevocation(pool.Listener.CRLFiles, pool.Listener.CRLRefresh, pool.Listener.ClientOCSP)
 				go existingProxy.Start()
 			}
 
@@ -162,6 +167,7 @@ func (manager *Manager) InitializeProxies() {
 			}
 
 			newProxy.SetListener(pool.Listener.Mode, pool.Listener.SourceIP, pool.Listener.IP, pool.Listener.Port, pool.Listener.MaxConnections, newTLS, pool.Listener.ReadTimeout, pool.Listener.WriteTimeout, pool.Listener.HTTPProto, pool.Listener.OCSPStapling)
+			newProxy.SetClientRevocation(pool.Listener.CRLFiles, pool.Listener.CRLRefresh, pool.Listener.ClientOCSP)
 			go newProxy.Start()
 			// Register new proxy
 			proxies.pool[poolname] = newProxy
@@ -187,6 +193,8 @@ func (manager *Manager) InitializeProxies() {
 			plog.WithField("file", pool.MaintenancePage.File).WithError(err).Warn("Unable to load Maintenance page")
 		}
 
+		newProxy.SetTrustedProxies(pool.Listener.TrustedProxies)
+
 		//log.Debugf("proxy:%s Proxy has the following backends before init:%+v", poolname, removableBackends)
 		for bid := range removableBackends {
 			plog.WithField("backend", bid).Debug("Backend before init")
@@ -240,6 +248,11 @@ func (manager *Manager) InitializeProxies() {
 				backend.SetACL("out", outboundACLs)
 			}
 
+			if !reflect.DeepEqual(backend.ForwardAuth, backendpool.ForwardAuth) {
+				plog.WithField("backend", backendname).WithField("url", backendpool.ForwardAuth.URL).Debug("Setting forward authentication")
+				backend.SetForwardAuth(backendpool.ForwardAuth)
+			}
+
 			// Check backend Nodes
 			// IF node is local check with local config
 			// IF node is remote update of removal should be sent at config loading
@@ -219,7 +219,7 @@ func WebBackendStatus(w http.ResponseWriter, r *http.Request) {
 func WebGLBStatus(w http.ResponseWriter, r *http.Request) {
 	log := logging.For("core/glbstatus").WithField("func", "web")
 	w.Header().Add("Cache-Control", "max-age=0, no-cache, must-revalidate, proxy-revalidate")
-	dnscache := dns.GetCache()
+	dnscache := dns.GetCacheWithTTL()
 	switch r.Header.Get("Content-type") {
 	case applicationJSONHeader:
 		data, err := json.Marshal(dnscache)
@@ -287,9 +287,12 @@ func WebLocalDNSStatus(w http.ResponseWriter, r *http.Request) {
 		}
 
 		data := struct {
-			DNS  map[string]dns.Domains
-			Page web.Page
-		}{dnscache, *page}
+			DNS       map[string]dns.Domains
+			Cache     dns.CacheStats
+			RateLimit dns.RateLimitStats
+			Upstreams []dns.UpstreamStats
+			Page      web.Page
+		}{dnscache, dns.ForwardCacheStats(), dns.RateLimitCounters(), dns.ForwardZoneStats(), *page}
 
 		err = backendTemplate.ExecuteTemplate(w, "glb", data)
 		if err != nil {
@@ -299,6 +302,70 @@ func WebLocalDNSStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// WebDNSStats Provides a status page for the dns query statistics
+func WebDNSStats(w http.ResponseWriter, r *http.Request) {
+	log := logging.For("core/dnsstats").WithField("func", "web")
+	w.Header().Add("Cache-Control", "max-age=0, no-cache, must-revalidate, proxy-revalidate")
+	window, top, err := queryStatsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the names requested and the networks of clients are only shown to logged in users
+	authenticated, username, err := authenticateUser(r)
+	if err != nil {
+		log.Warnf("Error authenticating user: %s", err)
+	}
+
+	stats := dns.GetQueryStats(window, top)
+	if !authenticated {
+		stats.Names = nil
+		stats.Clients = nil
+	}
+
+	switch r.Header.Get("Content-type") {
+	case applicationJSONHeader:
+		data, err := json.Marshal(stats)
+		if err != nil {
+			fmt.Fprintf(w, "{ error:'%s' }", err)
+		}
+		fmt.Fprint(w, string(data))
+
+	default:
+		clusternode := config.Get().Cluster.Binding.Name
+		title := fmt.Sprintf("Mercury %s - DNS Statistics", clusternode)
+		// the path without the window, so the page is marked in the navigation
+		page := newPage(title, r.URL.Path, username)
+
+		templateNames := []string{"dnsstats.tmpl", "header.tmpl", "footer.tmpl"}
+		backendTemplate, err := web.LoadTemplates("static", templateNames)
+		if err != nil {
+			log.Warnf("Error loading templates: %s", err)
+		}
+
+		data := struct {
+			Stats         dns.QueryStats
+			Windows       []string
+			Top           int
+			Authenticated bool
+			Page          web.Page
+		}{stats, dns.QueryStatsWindows, top, authenticated, *page}
+
+		err = backendTemplate.ExecuteTemplate(w, "dnsstats", data)
+		if err != nil {
+			log.WithField("error", err).Warn("Error executing template")
+		}
+
+	}
+}
+
+// WebMetrics Provides the metrics in the text format of Prometheus
+func WebMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	dns.WriteQueryMetrics(w)
+}
+
 func uptime(t time.Duration) string {
 	if (t.Hours() / 24) > 1 {
 		return fmt.Sprintf("%.0fd", t.Hours()/24)
@@ -377,6 +444,8 @@ func (m *Manager) NewServer(ip string, port int) (s *http.Server, l net.Listener
 
 	http.HandleFunc("/glb", WebGLBStatus)
 	http.HandleFunc("/localdns", WebLocalDNSStatus)
+	http.HandleFunc("/dnsstats", WebDNSStats)
+	http.HandleFunc("/metrics", WebMetrics)
 	http.HandleFunc("/backend", WebBackendStatus)
 	http.HandleFunc("/proxy", WebProxyStatus)
 	http.HandleFunc("/cluster", WebClusterStatus)
@@ -105,6 +105,30 @@ func TestBalancer(t *testing.T) {
 	}
 }
 
+func TestStatisticsCopy(t *testing.T) {
+	stats := []*Statistics{NewStatistics("ID1", 100), NewStatistics("ID2", 100)}
+	stats[1].ClientsConnectedSet(10)
+
+	// clients are counted while the statistics are copied to balance on
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			stats[0].ClientsConnectedAdd(1)
+			stats[0].ClientsConnectedSub(1)
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		records, err := Sort([]Statistics{stats[1].Copy(), stats[0].Copy()}, "127.0.0.1", "", "leastconnected")
+		if err != nil || records[0].UUID != "ID1" {
+			t.Errorf("Leastconnected balancing with copied statistics failed, got:%s expected:ID1 error:%v", records[0].UUID, err)
+		}
+	}
+
+	<-done
+}
+
 var result []Statistics
 
 func benchmarkBalancer(m string, b *testing.B) {
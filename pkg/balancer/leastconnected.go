@@ -5,5 +5,5 @@ type LeastConnected struct{ statistics }
 
 // Less implements LeastConnected based loadbalancing by sorting based on leastconnected counter
 func (s LeastConnected) Less(i, j int) bool {
-	return s.statistics[i].ClientsConnected < s.statistics[j].ClientsConnected
+	return s.statistics[i].ClientsConnectedGet() < s.statistics[j].ClientsConnectedGet()
 }
@@ -3,6 +3,7 @@ package balancer
 import (
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,7 +11,7 @@ import (
 type Statistics struct {
 	*sync.RWMutex
 	UUID              string    `json:"uuid"`
-	ClientsConnected  int64     `json:"clientsconnected"`
+	ClientsConnected  int64     `json:"clientsconnected"` // only changed atomically, so requests can be counted without locking
 	ClientsConnects   int64     `json:"clientsconnects"`
 	RX                int64     `json:"rx"`
 	TX                int64     `json:"tx"`
@@ -37,7 +38,7 @@ func (s *Statistics) Reset() {
 	s.Lock()
 	defer s.Unlock()
 	s.ClientsConnects = 0
-	s.ClientsConnected = 0
+	atomic.StoreInt64(&s.ClientsConnected, 0)
 	s.RX = 0
 	s.TX = 0
 	s.ResponseTimeValue = []float64{}
@@ -46,23 +47,17 @@ func (s *Statistics) Reset() {
 
 // ClientsConnectedAdd adds a client to the counter
 func (s *Statistics) ClientsConnectedAdd(i int64) {
-	s.Lock()
-	defer s.Unlock()
-	s.ClientsConnected += i
+	atomic.AddInt64(&s.ClientsConnected, i)
 }
 
 // ClientsConnectedSub adds a client to the counter
 func (s *Statistics) ClientsConnectedSub(i int64) {
-	s.Lock()
-	defer s.Unlock()
-	s.ClientsConnected -= i
+	atomic.AddInt64(&s.ClientsConnected, -i)
 }
 
 // ClientsConnectedSet adds a client to the counter
 func (s *Statistics) ClientsConnectedSet(count int64) {
-	s.Lock()
-	defer s.Unlock()
-	s.ClientsConnected = count
+	atomic.StoreInt64(&s.ClientsConnected, count)
 }
 
 // ClientsConnectsAdd adds a client to the counter
@@ -195,11 +190,28 @@ func (s *Statistics) UUIDGet() string {
 	return s.UUID
 }
 
-// ClientsConnectedGet returns the clients connected
-func (s *Statistics) ClientsConnectedGet() int64 {
+// Copy returns a copy of the statistics to balance on, the clients connected are read atomically since they change without locking
+func (s *Statistics) Copy() Statistics {
 	s.RLock()
 	defer s.RUnlock()
-	return s.ClientsConnected
+	return Statistics{
+		RWMutex:           s.RWMutex,
+		UUID:              s.UUID,
+		ClientsConnected:  atomic.LoadInt64(&s.ClientsConnected),
+		ClientsConnects:   s.ClientsConnects,
+		RX:                s.RX,
+		TX:                s.TX,
+		Preference:        s.Preference,
+		Topology:          s.Topology,
+		TimeCounter:       s.TimeCounter,
+		TimeTimer:         s.TimeTimer,
+		ResponseTimeValue: s.ResponseTimeValue,
+	}
+}
+
+// ClientsConnectedGet returns the clients connected
+func (s *Statistics) ClientsConnectedGet() int64 {
+	return atomic.LoadInt64(&s.ClientsConnected)
 }
 
 // ClientsConnectsGet returns the clients requests
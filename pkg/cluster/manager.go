@@ -177,6 +177,24 @@ func (m *Manager) quorum() bool {
 	}
 }
 
+// Elected returns true if this node should perform tasks that only one node in the cluster may do
+// the node with the lowest name of all connected nodes is elected, as long as it has quorum
+func (m *Manager) Elected() bool {
+	if !m.quorum() {
+		return false
+	}
+
+	m.connectedNodes.RLock()
+	defer m.connectedNodes.RUnlock()
+	for name := range m.connectedNodes.nodes {
+		if name < m.name {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (m *Manager) updateQuorum() {
 	m.log("%s Cluster quorum state: %t", m.name, m.quorum())
 	select {
@@ -24,6 +24,10 @@ func TestOneClusterNode(t *testing.T) {
 		log.Fatal(err)
 	}
 
+	if !managerONE.Elected() {
+		t.Errorf("expected managerONE to be elected as single cluster node, but it was not")
+	}
+
 	managerONE.ToCluster <- Message{Message: "Hello World"}
 	var wg sync.WaitGroup
 
@@ -112,6 +116,10 @@ func TestTwoClusterNode(t *testing.T) {
 		t.Errorf("expected Join on managerTHREE to be from managerTWO, but got:%s", node)
 	}
 
+	if !managerTHREE.Elected() || managerTWO.Elected() {
+		t.Errorf("expected only managerTHREE to be elected, but got managerTHREE:%t managerTWO:%t", managerTHREE.Elected(), managerTWO.Elected())
+	}
+
 	if timeout = channelWriteTimeout(managerTWO.ToCluster, Message{Message: "Hello World"}, 2); timeout {
 		t.Errorf("expected write to managerTWO.ToCluster to work, but it timedout")
 	}
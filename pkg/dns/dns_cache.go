@@ -0,0 +1,274 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// ForwardCache contains the settings of the cache for forwarded dns requests
+type ForwardCache struct {
+	Size        int `toml:"size" json:"size"`                 // maximum amount of cached answers, -1 disables the cache
+	MaxTTL      int `toml:"max_ttl" json:"max_ttl"`           // maximum seconds an answer is cached
+	NegativeTTL int `toml:"negative_ttl" json:"negative_ttl"` // maximum seconds a negative answer is cached
+	Prefetch    int `toml:"prefetch" json:"prefetch"`         // hits after which an answer is refreshed before it expires, 0 disables prefetching
+}
+
+// CacheStats contains the statistics of the forward cache
+type CacheStats struct {
+	Entries    int     `json:"entries"`
+	Size       int     `json:"size"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	Prefetches int64   `json:"prefetches"`
+	HitRatio   float64 `json:"hit_ratio"` // percentage of requests answered from cache
+}
+
+// cacheEntry is a cached reply of a forwarded request
+type cacheEntry struct {
+	key         string
+	question    dnssrv.Question
+	rcode       int
+	answer      []dnssrv.RR
+	ns          []dnssrv.RR
+	extra       []dnssrv.RR
+	stored      time.Time
+	ttl         uint32
+	hits        int
+	prefetching bool
+	element     *list.Element
+}
+
+// forwardCache holds the cached replies of forwarded requests, the least recently used are removed first
+var forwardCache = struct {
+	sync.Mutex
+	config     ForwardCache
+	entries    map[string]*cacheEntry
+	lru        *list.List
+	hits       int64
+	misses     int64
+	prefetches int64
+}{entries: make(map[string]*cacheEntry), lru: list.New()}
+
+// SetForwardCache sets the forward cache settings
+func SetForwardCache(config ForwardCache) {
+	forwardCache.Lock()
+	defer forwardCache.Unlock()
+	forwardCache.config = config
+	cacheEvict()
+}
+
+// FlushForwardCache removes all cached replies, or only those of name if set, and returns the amount removed
+func FlushForwardCache(name string) int {
+	forwardCache.Lock()
+	defer forwardCache.Unlock()
+	removed := 0
+	for key, entry := range forwardCache.entries {
+		if name == "" || strings.EqualFold(entry.question.Name, dnssrv.Fqdn(name)) {
+			forwardCache.lru.Remove(entry.element)
+			delete(forwardCache.entries, key)
+			removed++
+		}
+	}
+
+	logging.For("dns/cache/flush").WithField("name", name).WithField("removed", removed).Info("Flushed forward cache")
+	return removed
+}
+
+// ForwardCacheStats returns the statistics of the forward cache
+func ForwardCacheStats() CacheStats {
+	forwardCache.Lock()
+	defer forwardCache.Unlock()
+	stats := CacheStats{
+		Entries:    len(forwardCache.entries),
+		Size:       forwardCache.config.Size,
+		Hits:       forwardCache.hits,
+		Misses:     forwardCache.misses,
+		Prefetches: forwardCache.prefetches,
+	}
+
+	if stats.Hits+stats.Misses > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(stats.Hits+stats.Misses) * 100
+	}
+
+	return stats
+}
+
+// cacheKey returns the key of a question in the cache
+func cacheKey(q dnssrv.Question) string {
+	return strings.ToLower(q.Name) + "/" + dnssrv.TypeToString[q.Qtype]
+}
+
+// cacheGet returns the cached reply of a question, with the ttls lowered by the time it was cached
+func cacheGet(q dnssrv.Question) (*dnssrv.Msg, bool) {
+	forwardCache.Lock()
+	defer forwardCache.Unlock()
+	if forwardCache.config.Size < 0 {
+		return nil, false
+	}
+
+	entry, ok := forwardCache.entries[cacheKey(q)]
+	age := uint32(0)
+	if ok {
+		age = uint32(time.Since(entry.stored).Seconds())
+	}
+
+	if !ok || age >= entry.ttl {
+		if ok {
+			forwardCache.lru.Remove(entry.element)
+			delete(forwardCache.entries, entry.key)
+		}
+
+		forwardCache.misses++
+		return nil, false
+	}
+
+	forwardCache.hits++
+	entry.hits++
+	forwardCache.lru.MoveToFront(entry.element)
+
+	// refresh popular answers before they expire, so they keep being answered from cache
+	if forwardCache.config.Prefetch > 0 && entry.hits >= forwardCache.config.Prefetch && !entry.prefetching && entry.ttl-age <= entry.ttl/10 {
+		entry.prefetching = true
+		forwardCache.prefetches++
+		go cachePrefetch(q)
+	}
+
+	m := new(dnssrv.Msg)
+	m.Rcode = entry.rcode
+	m.Answer = cacheCopy(entry.answer, age)
+	m.Ns = cacheCopy(entry.ns, age)
+	m.Extra = cacheCopy(entry.extra, age)
+	return m, true
+}
+
+// cacheCopy returns a copy of records with their ttl lowered by age
+func cacheCopy(rrs []dnssrv.RR, age uint32) []dnssrv.RR {
+	var copies []dnssrv.RR
+	for _, rr := range rrs {
+		c := dnssrv.Copy(rr)
+		c.Header().Ttl -= age
+		copies = append(copies, c)
+	}
+
+	return copies
+}
+
+// cacheTTL returns how long a reply may be cached, negative replies are cached for the SOA minimum (RFC 2308 section 5)
+func cacheTTL(m *dnssrv.Msg) uint32 {
+	config := forwardCache.config
+	if m.Rcode == dnssrv.RcodeNameError || (m.Rcode == dnssrv.RcodeSuccess && len(m.Answer) == 0) {
+		for _, rr := range m.Ns {
+			if soa, ok := rr.(*dnssrv.SOA); ok {
+				ttl := soa.Hdr.Ttl
+				if soa.Minttl < ttl {
+					ttl = soa.Minttl
+				}
+
+				if ttl > uint32(config.NegativeTTL) {
+					ttl = uint32(config.NegativeTTL)
+				}
+
+				return ttl
+			}
+		}
+
+		// negative replies without SOA are not cached
+		return 0
+	}
+
+	if m.Rcode != dnssrv.RcodeSuccess || m.Truncated {
+		return 0
+	}
+
+	ttl := uint32(config.MaxTTL)
+	for _, section := range [][]dnssrv.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dnssrv.TypeOPT && rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+
+	return ttl
+}
+
+// cacheSet stores the reply of a forwarded question
+func cacheSet(q dnssrv.Question, m *dnssrv.Msg) {
+	forwardCache.Lock()
+	defer forwardCache.Unlock()
+	if forwardCache.config.Size < 0 {
+		return
+	}
+
+	key := cacheKey(q)
+	if existing, ok := forwardCache.entries[key]; ok {
+		forwardCache.lru.Remove(existing.element)
+		delete(forwardCache.entries, key)
+	}
+
+	ttl := cacheTTL(m)
+	if ttl == 0 {
+		return
+	}
+
+	entry := &cacheEntry{
+		key:      key,
+		question: q,
+		rcode:    m.Rcode,
+		answer:   cacheCopy(m.Answer, 0),
+		ns:       cacheCopy(m.Ns, 0),
+		stored:   time.Now(),
+		ttl:      ttl,
+	}
+
+	// the OPT record is set per reply
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dnssrv.TypeOPT {
+			entry.extra = append(entry.extra, dnssrv.Copy(rr))
+		}
+	}
+
+	// records are never served with a ttl longer then the answer is cached
+	for _, section := range [][]dnssrv.RR{entry.answer, entry.ns, entry.extra} {
+		for _, rr := range section {
+			if rr.Header().Ttl > ttl {
+				rr.Header().Ttl = ttl
+			}
+		}
+	}
+
+	entry.element = forwardCache.lru.PushFront(entry)
+	forwardCache.entries[key] = entry
+	cacheEvict()
+}
+
+// cacheEvict removes the least recently used replies above the size limit, the caller must hold the cache lock
+func cacheEvict() {
+	for forwardCache.lru.Len() > 0 && forwardCache.lru.Len() > forwardCache.config.Size {
+		entry := forwardCache.lru.Remove(forwardCache.lru.Back()).(*cacheEntry)
+		delete(forwardCache.entries, entry.key)
+	}
+}
+
+// cachePrefetch resolves a question again, and replaces its cached reply
+func cachePrefetch(q dnssrv.Question) {
+	log := logging.For("dns/cache/prefetch").WithField("name", q.Name).WithField("type", dnssrv.TypeToString[q.Qtype])
+	reply, err := forwardResolve(q)
+	if err != nil || reply == nil {
+		log.WithField("error", err).Debug("Failed to prefetch forwarded dns")
+		forwardCache.Lock()
+		if entry, ok := forwardCache.entries[cacheKey(q)]; ok {
+			entry.prefetching = false
+		}
+		forwardCache.Unlock()
+		return
+	}
+
+	log.Debug("Prefetched forwarded dns")
+	cacheSet(q, reply)
+}
@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func cacheReply(rcode int, rrs ...string) *dnssrv.Msg {
+	m := new(dnssrv.Msg)
+	m.Rcode = rcode
+	for _, s := range rrs {
+		rr, _ := dnssrv.NewRR(s)
+		if rr.Header().Rrtype == dnssrv.TypeSOA {
+			m.Ns = append(m.Ns, rr)
+		} else {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+
+	return m
+}
+
+func TestForwardCache(t *testing.T) {
+	logging.Configure("stdout", "error")
+	SetForwardCache(ForwardCache{Size: 2, MaxTTL: 300, NegativeTTL: 60})
+	defer SetForwardCache(ForwardCache{})
+	FlushForwardCache("")
+
+	qA := dnssrv.Question{Name: "www.cache.example.", Qtype: dnssrv.TypeA, Qclass: dnssrv.ClassINET}
+	qNX := dnssrv.Question{Name: "nx.cache.example.", Qtype: dnssrv.TypeA, Qclass: dnssrv.ClassINET}
+	qMX := dnssrv.Question{Name: "cache.example.", Qtype: dnssrv.TypeMX, Qclass: dnssrv.ClassINET}
+
+	// positive answers are cached for the lowest ttl, limited to the max ttl
+	_, ok := cacheGet(qA)
+	assert.False(t, ok)
+	cacheSet(qA, cacheReply(dnssrv.RcodeSuccess, "www.cache.example. 3600 IN A 127.0.5.1"))
+	m, ok := cacheGet(qA)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(300), m.Answer[0].Header().Ttl)
+
+	// negative answers are cached for the SOA minimum, limited to the negative ttl
+	soa := "cache.example. 3600 IN SOA ns1.cache.example. hostmaster.cache.example. 1 3600 600 86400 30"
+	cacheSet(qNX, cacheReply(dnssrv.RcodeNameError, soa))
+	m, ok = cacheGet(qNX)
+	assert.True(t, ok)
+	assert.Equal(t, dnssrv.RcodeNameError, m.Rcode)
+	assert.Equal(t, uint32(30), cacheTTL(cacheReply(dnssrv.RcodeNameError, soa)))
+	assert.Equal(t, uint32(0), cacheTTL(cacheReply(dnssrv.RcodeNameError)))
+	assert.Equal(t, uint32(0), cacheTTL(cacheReply(dnssrv.RcodeServerFailure)))
+
+	// the least recently used answer is removed above the size limit
+	cacheGet(qA)
+	cacheSet(qMX, cacheReply(dnssrv.RcodeSuccess, "cache.example. 60 IN MX 10 mail.cache.example."))
+	_, ok = cacheGet(qNX)
+	assert.False(t, ok)
+	_, ok = cacheGet(qA)
+	assert.True(t, ok)
+
+	stats := ForwardCacheStats()
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, int64(4), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
+	assert.Equal(t, float64(4)/6*100, stats.HitRatio)
+
+	// expired answers are removed
+	forwardCache.Lock()
+	forwardCache.entries[cacheKey(qMX)].stored = time.Now().Add(-61 * time.Second)
+	forwardCache.Unlock()
+	_, ok = cacheGet(qMX)
+	assert.False(t, ok)
+
+	// answers can be flushed by name
+	assert.Equal(t, 1, FlushForwardCache("www.cache.example"))
+	_, ok = cacheGet(qA)
+	assert.False(t, ok)
+
+	// a disabled cache stores nothing
+	SetForwardCache(ForwardCache{Size: -1, MaxTTL: 300, NegativeTTL: 60})
+	cacheSet(qA, cacheReply(dnssrv.RcodeSuccess, "www.cache.example. 3600 IN A 127.0.5.1"))
+	_, ok = cacheGet(qA)
+	assert.False(t, ok)
+}
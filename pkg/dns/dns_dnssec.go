@@ -0,0 +1,615 @@
+package dns
+
+import (
+	"crypto"
+	"encoding/base32"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// DNSSEC contains the online signing settings of a domain
+type DNSSEC struct {
+	Keys              []string `toml:"keys" json:"keys"`                             // BIND style key files (Kexample.com.+013+12345), requires both the .key and .private file
+	NSEC3             bool     `toml:"nsec3" json:"nsec3"`                           // use NSEC3 instead of NSEC for negative answers
+	NSEC3Iterations   uint16   `toml:"nsec3_iterations" json:"nsec3_iterations"`     // additional NSEC3 hash iterations
+	NSEC3Salt         string   `toml:"nsec3_salt" json:"nsec3_salt"`                 // hex encoded NSEC3 salt
+	SignatureValidity int      `toml:"signature_validity" json:"signature_validity"` // validity of generated signatures in seconds
+}
+
+// signatureInceptionOffset is subtracted from the signature inception to allow for clock skew
+const signatureInceptionOffset = time.Hour
+
+// maxSignatureCache is the amount of signatures cached per zone before expired signatures are removed
+const maxSignatureCache = 10000
+
+// signingKey is a DNSKEY with its private key
+type signingKey struct {
+	dnskey *dnssrv.DNSKEY
+	signer crypto.Signer
+}
+
+// zoneSigner signs the answers of a single zone
+type zoneSigner struct {
+	sync.Mutex
+	zone   string // fqdn of the zone
+	config DNSSEC
+	ttl    uint32
+	keys   []signingKey
+	cache  map[string]*dnssrv.RRSIG
+}
+
+// newZoneSigner loads the keys of a zone
+func newZoneSigner(zone string, config DNSSEC, ttl int) (*zoneSigner, error) {
+	if len(config.Keys) == 0 {
+		return nil, fmt.Errorf("No DNSSEC keys defined for %s", zone)
+	}
+
+	if config.SignatureValidity <= 0 {
+		config.SignatureValidity = 604800
+	}
+
+	if ttl <= 0 {
+		ttl = 3600
+	}
+
+	z := &zoneSigner{
+		zone:   dnssrv.Fqdn(strings.ToLower(zone)),
+		config: config,
+		ttl:    uint32(ttl),
+		cache:  make(map[string]*dnssrv.RRSIG),
+	}
+
+	for _, file := range config.Keys {
+		key, err := readSigningKey(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.EqualFold(key.dnskey.Hdr.Name, z.zone) {
+			return nil, fmt.Errorf("DNSSEC key %s is for zone %s, expected %s", file, key.dnskey.Hdr.Name, z.zone)
+		}
+
+		key.dnskey.Hdr.Ttl = z.ttl
+		z.keys = append(z.keys, key)
+	}
+
+	return z, nil
+}
+
+// readSigningKey reads a BIND style .key and .private file
+func readSigningKey(file string) (signingKey, error) {
+	base := strings.TrimSuffix(strings.TrimSuffix(file, ".key"), ".private")
+
+	pub, err := os.Open(base + ".key")
+	if err != nil {
+		return signingKey{}, fmt.Errorf("Failed to open DNSSEC public key: %s", err)
+	}
+	defer pub.Close()
+
+	rr, err := dnssrv.ReadRR(pub, base+".key")
+	if err != nil {
+		return signingKey{}, fmt.Errorf("Failed to parse DNSSEC public key %s.key: %s", base, err)
+	}
+
+	dnskey, ok := rr.(*dnssrv.DNSKEY)
+	if !ok {
+		return signingKey{}, fmt.Errorf("File %s.key does not contain a DNSKEY record", base)
+	}
+
+	priv, err := os.Open(base + ".private")
+	if err != nil {
+		return signingKey{}, fmt.Errorf("Failed to open DNSSEC private key: %s", err)
+	}
+	defer priv.Close()
+
+	privkey, err := dnskey.ReadPrivateKey(priv, base+".private")
+	if err != nil {
+		return signingKey{}, fmt.Errorf("Failed to parse DNSSEC private key %s.private: %s", base, err)
+	}
+
+	signer, ok := privkey.(crypto.Signer)
+	if !ok {
+		return signingKey{}, fmt.Errorf("DNSSEC private key %s.private can not be used for signing", base)
+	}
+
+	return signingKey{dnskey: dnskey, signer: signer}, nil
+}
+
+// equal returns true if both signers use the same keys and settings
+func (z *zoneSigner) equal(n *zoneSigner) bool {
+	if z.zone != n.zone || z.ttl != n.ttl || !reflect.DeepEqual(z.config, n.config) || len(z.keys) != len(n.keys) {
+		return false
+	}
+
+	for i := range z.keys {
+		if z.keys[i].dnskey.String() != n.keys[i].dnskey.String() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dnskeys returns the DNSKEY records of the zone for the requested name
+func (z *zoneSigner) dnskeys(name string) (rrs []dnssrv.RR) {
+	for _, k := range z.keys {
+		dnskey := *k.dnskey
+		dnskey.Hdr.Name = name
+		rrs = append(rrs, &dnskey)
+	}
+
+	return
+}
+
+// nsec3param returns the NSEC3PARAM record of the zone for the requested name
+func (z *zoneSigner) nsec3param(name string) dnssrv.RR {
+	return &dnssrv.NSEC3PARAM{
+		Hdr:        dnssrv.RR_Header{Name: name, Rrtype: dnssrv.TypeNSEC3PARAM, Class: dnssrv.ClassINET, Ttl: 0},
+		Hash:       dnssrv.SHA1,
+		Iterations: z.config.NSEC3Iterations,
+		SaltLength: uint8(len(z.config.NSEC3Salt) / 2),
+		Salt:       z.salt(),
+	}
+}
+
+func (z *zoneSigner) salt() string {
+	if z.config.NSEC3Salt == "" {
+		return "-"
+	}
+
+	return strings.ToUpper(z.config.NSEC3Salt)
+}
+
+// keysFor returns the keys used to sign a record type, key signing keys sign the DNSKEY set and zone signing keys the rest
+// if there is only one type of key, it is used for all records
+func (z *zoneSigner) keysFor(rrtype uint16) []signingKey {
+	var ksk, zsk []signingKey
+	for _, k := range z.keys {
+		if k.dnskey.Flags&dnssrv.SEP == dnssrv.SEP {
+			ksk = append(ksk, k)
+		} else {
+			zsk = append(zsk, k)
+		}
+	}
+
+	switch {
+	case len(ksk) == 0:
+		return zsk
+	case len(zsk) == 0:
+		return ksk
+	case rrtype == dnssrv.TypeDNSKEY:
+		return ksk
+	default:
+		return zsk
+	}
+}
+
+// signSection adds the RRSIG records for each RRset in a message section that belongs to the zone
+func (z *zoneSigner) signSection(section []dnssrv.RR) []dnssrv.RR {
+	log := logging.For("dns/dnssec/sign").WithField("zone", z.zone)
+
+	var order []string
+	rrsets := make(map[string][]dnssrv.RR)
+	for _, rr := range section {
+		h := rr.Header()
+		if h.Rrtype == dnssrv.TypeOPT || h.Rrtype == dnssrv.TypeRRSIG || !dnssrv.IsSubDomain(z.zone, strings.ToLower(h.Name)) {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%d/%d", strings.ToLower(h.Name), h.Class, h.Rrtype)
+		if _, ok := rrsets[key]; !ok {
+			order = append(order, key)
+		}
+
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	for _, key := range order {
+		sigs, err := z.sign(rrsets[key])
+		if err != nil {
+			log.WithField("rrset", key).WithField("error", err).Error("Failed to sign RRset")
+			continue
+		}
+
+		section = append(section, sigs...)
+	}
+
+	return section
+}
+
+// sign returns the signatures of a RRset, signatures are cached until the RRset changes or they are half way their validity
+func (z *zoneSigner) sign(rrset []dnssrv.RR) ([]dnssrv.RR, error) {
+	now := time.Now()
+	validity := time.Duration(z.config.SignatureValidity) * time.Second
+	setKey := rrsetKey(rrset)
+	name := rrset[0].Header().Name
+
+	var sigs []dnssrv.RR
+	for _, k := range z.keysFor(rrset[0].Header().Rrtype) {
+		cacheKey := fmt.Sprintf("%d/%d/%s", k.dnskey.KeyTag(), k.dnskey.Algorithm, setKey)
+
+		z.Lock()
+		cached, ok := z.cache[cacheKey]
+		z.Unlock()
+
+		if ok && time.Unix(int64(cached.Expiration), 0).Sub(now) > validity/2 {
+			sig := *cached
+			sig.Hdr.Name = name
+			sigs = append(sigs, &sig)
+			continue
+		}
+
+		sig := &dnssrv.RRSIG{
+			Hdr:        dnssrv.RR_Header{Ttl: rrset[0].Header().Ttl},
+			Algorithm:  k.dnskey.Algorithm,
+			KeyTag:     k.dnskey.KeyTag(),
+			SignerName: z.zone,
+			Inception:  uint32(now.Add(-signatureInceptionOffset).Unix()),
+			Expiration: uint32(now.Add(validity).Unix()),
+		}
+
+		if err := sig.Sign(k.signer, rrset); err != nil {
+			return nil, err
+		}
+
+		z.Lock()
+		if len(z.cache) >= maxSignatureCache {
+			z.expireCache(now, validity)
+		}
+		cachedSig := *sig
+		z.cache[cacheKey] = &cachedSig
+		z.Unlock()
+
+		sigs = append(sigs, sig)
+	}
+
+	return sigs, nil
+}
+
+// expireCache removes signatures that would no longer be served, or all if that is not enough
+func (z *zoneSigner) expireCache(now time.Time, validity time.Duration) {
+	for key, sig := range z.cache {
+		if time.Unix(int64(sig.Expiration), 0).Sub(now) <= validity/2 {
+			delete(z.cache, key)
+		}
+	}
+
+	if len(z.cache) >= maxSignatureCache {
+		z.cache = make(map[string]*dnssrv.RRSIG)
+	}
+}
+
+// rrsetKey returns a case insensitive key identifying the content of a RRset
+func rrsetKey(rrset []dnssrv.RR) string {
+	var rdata []string
+	for _, rr := range rrset {
+		rdata = append(rdata, strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+
+	sort.Strings(rdata)
+	h := rrset[0].Header()
+	return fmt.Sprintf("%s/%d/%d/%d/%s", strings.ToLower(h.Name), h.Class, h.Rrtype, h.Ttl, strings.Join(rdata, "/"))
+}
+
+// denial returns a NSEC or NSEC3 record proving that the requested type does not exist at name
+// records are generated per request (RFC 4470), names without records are answered as existing names without data
+func (z *zoneSigner) denial(name string, types []uint16, ttl uint32) dnssrv.RR {
+	if z.config.NSEC3 {
+		hash := dnssrv.HashName(name, dnssrv.SHA1, z.config.NSEC3Iterations, z.config.NSEC3Salt)
+		bitmap := types
+		if len(bitmap) > 0 {
+			bitmap = appendType(bitmap, dnssrv.TypeRRSIG)
+		}
+
+		return &dnssrv.NSEC3{
+			Hdr:        dnssrv.RR_Header{Name: strings.ToLower(hash) + "." + z.zone, Rrtype: dnssrv.TypeNSEC3, Class: dnssrv.ClassINET, Ttl: ttl},
+			Hash:       dnssrv.SHA1,
+			Iterations: z.config.NSEC3Iterations,
+			SaltLength: uint8(len(z.config.NSEC3Salt) / 2),
+			Salt:       z.salt(),
+			HashLength: 20,
+			NextDomain: nextHash(hash),
+			TypeBitMap: bitmap,
+		}
+	}
+
+	return &dnssrv.NSEC{
+		Hdr:        dnssrv.RR_Header{Name: name, Rrtype: dnssrv.TypeNSEC, Class: dnssrv.ClassINET, Ttl: ttl},
+		NextDomain: "\\000." + name,
+		TypeBitMap: appendType(appendType(types, dnssrv.TypeRRSIG), dnssrv.TypeNSEC),
+	}
+}
+
+// nameErrorDenial returns the NSEC or NSEC3 records proving that the requested name and the wildcard of its closest encloser do not exist
+// like other denials they are generated per request, and only cover the denied names (RFC 4470, RFC 7129)
+func (z *zoneSigner) nameErrorDenial(view, qname, hostName, domainName string, ttl uint32) []dnssrv.RR {
+	qname = strings.ToLower(dnssrv.Fqdn(qname))
+	labels := dnssrv.SplitDomainName(strings.ToLower(hostName))
+	encloser, nextCloser := "", qname
+	for i := 1; i <= len(labels); i++ {
+		encloser = strings.Join(labels[i:], ".")
+		nextCloser = strings.Join(labels[i-1:], ".") + "." + z.zone
+		if encloser == "" || nameExists(view, encloser, domainName) {
+			break
+		}
+	}
+
+	encloserName := z.zone
+	if encloser != "" {
+		encloserName = encloser + "." + z.zone
+	}
+
+	if z.config.NSEC3 {
+		return []dnssrv.RR{
+			z.denial(encloserName, typesAtName(view, encloser, domainName, z), ttl),
+			z.cover(nextCloser, ttl),
+			z.cover("*."+encloserName, ttl),
+		}
+	}
+
+	return []dnssrv.RR{z.cover(qname, ttl), z.cover("*."+encloserName, ttl)}
+}
+
+// cover returns a NSEC or NSEC3 record of which the owner and next name lie directly around a name that does not exist
+func (z *zoneSigner) cover(name string, ttl uint32) dnssrv.RR {
+	if z.config.NSEC3 {
+		hash := dnssrv.HashName(name, dnssrv.SHA1, z.config.NSEC3Iterations, z.config.NSEC3Salt)
+		return &dnssrv.NSEC3{
+			Hdr:        dnssrv.RR_Header{Name: strings.ToLower(previousHash(hash)) + "." + z.zone, Rrtype: dnssrv.TypeNSEC3, Class: dnssrv.ClassINET, Ttl: ttl},
+			Hash:       dnssrv.SHA1,
+			Iterations: z.config.NSEC3Iterations,
+			SaltLength: uint8(len(z.config.NSEC3Salt) / 2),
+			Salt:       z.salt(),
+			HashLength: 20,
+			NextDomain: nextHash(hash),
+		}
+	}
+
+	return &dnssrv.NSEC{
+		Hdr:        dnssrv.RR_Header{Name: previousName(name), Rrtype: dnssrv.TypeNSEC, Class: dnssrv.ClassINET, Ttl: ttl},
+		NextDomain: "\\000." + name,
+		TypeBitMap: []uint16{dnssrv.TypeRRSIG, dnssrv.TypeNSEC},
+	}
+}
+
+// previousName returns a name that sorts just before name in canonical order (RFC 4471), by decrementing the last octet of
+// the first label and filling it up with \255, so no name of the zone can lie in between
+func previousName(name string) string {
+	wire := make([]byte, 255)
+	end, err := dnssrv.PackDomainName(dnssrv.Fqdn(name), wire, 0, nil, false)
+	if err != nil || wire[0] == 0 {
+		return name
+	}
+
+	label := append([]byte{}, wire[1:1+wire[0]]...)
+	rest := wire[1+wire[0] : end]
+	last := len(label) - 1
+	if label[last] == 0 {
+		label = label[:last]
+	} else {
+		label[last]--
+		if label[last] >= 'A' && label[last] <= 'Z' {
+			// upper case sorts as lower case, use the octet before the letters instead
+			label[last] = 'A' - 1
+		}
+
+		for len(label) < 63 && 1+len(label)+1+len(rest) <= 255 {
+			label = append(label, 0xff)
+		}
+	}
+
+	if len(label) == 0 {
+		previous, _, _ := dnssrv.UnpackDomainName(rest, 0)
+		return previous
+	}
+
+	previous, _, err := dnssrv.UnpackDomainName(append(append([]byte{byte(len(label))}, label...), rest...), 0)
+	if err != nil {
+		return name
+	}
+
+	return previous
+}
+
+// appendType adds a type to a sorted type bitmap
+func appendType(types []uint16, t uint16) []uint16 {
+	for _, existing := range types {
+		if existing == t {
+			return types
+		}
+	}
+
+	result := append(append([]uint16{}, types...), t)
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// nextHash returns the base32 encoded hash that directly follows the given hash
+func nextHash(hash string) string {
+	encoding := base32.HexEncoding.WithPadding(base32.NoPadding)
+	b, err := encoding.DecodeString(hash)
+	if err != nil {
+		return hash
+	}
+
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			break
+		}
+	}
+
+	return encoding.EncodeToString(b)
+}
+
+// previousHash returns the base32 encoded hash that directly precedes the given hash
+func previousHash(hash string) string {
+	encoding := base32.HexEncoding.WithPadding(base32.NoPadding)
+	b, err := encoding.DecodeString(hash)
+	if err != nil {
+		return hash
+	}
+
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]--
+		if b[i] != 0xff {
+			break
+		}
+	}
+
+	return encoding.EncodeToString(b)
+}
+
+// getSigner returns the signer of a zone, or nil if the zone is not signed
+func getSigner(domainName string) *zoneSigner {
+	dnsmanager.RLock()
+	defer dnsmanager.RUnlock()
+	return dnsmanager.signers[strings.ToLower(strings.TrimSuffix(domainName, "."))]
+}
+
+// dnssecRequested returns true if the client set the DNSSEC OK bit
+func dnssecRequested(m *dnssrv.Msg) bool {
+	opt := m.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// typesAtName returns the record types served for a name, including the DNSSEC types of the zone apex
+func typesAtName(view, hostName, domainName string, signer *zoneSigner) []uint16 {
+	searchHost := strings.ToLower(hostName)
+
+	var types []uint16
+	if d := currentIndex().view(view).domain(domainName); d != nil {
+		for _, rtype := range d.types[searchHost] {
+			if t, ok := dnssrv.StringToType[rtype]; ok {
+				types = appendType(types, t)
+			}
+		}
+	}
+
+	if searchHost == "" {
+		types = appendType(types, dnssrv.TypeDNSKEY)
+		if signer.config.NSEC3 {
+			types = appendType(types, dnssrv.TypeNSEC3PARAM)
+		}
+	}
+
+	return types
+}
+
+// zoneSOA returns the SOA record of a zone
+func zoneSOA(domainName string) *dnssrv.SOA {
+	records := getAllRecords("", "", domainName, "SOA")
+	if len(records) == 0 {
+		return nil
+	}
+
+	record := records[0]
+	if record.TTL == 0 {
+		record.TTL = 10
+	}
+
+	rr, err := dnssrv.NewRR(fmt.Sprintf("%s. %d SOA %s", domainName, record.TTL, record.Target))
+	if err != nil {
+		return nil
+	}
+
+	soa, _ := rr.(*dnssrv.SOA)
+	return soa
+}
+
+// negativeTTL returns the ttl of negative answers, the SOA minimum or the ttl of the SOA if lower (RFC 2308)
+func negativeTTL(soa *dnssrv.SOA) uint32 {
+	if soa.Hdr.Ttl < soa.Minttl {
+		return soa.Hdr.Ttl
+	}
+
+	return soa.Minttl
+}
+
+// signResponse adds the negative proof for empty answers, and signs all RRsets in the message of this zone
+func (z *zoneSigner) signResponse(m *dnssrv.Msg, view, qname, hostName, domainName string) {
+	if len(m.Answer) == 0 {
+		m.Authoritative = true
+		if soa := zoneSOA(domainName); soa != nil {
+			m.Ns = append(m.Ns, soa, z.denial(qname, typesAtName(view, hostName, domainName, z), negativeTTL(soa)))
+		}
+	}
+
+	m.Answer = z.signSection(m.Answer)
+	m.Ns = z.signSection(m.Ns)
+	m.Extra = z.signSection(m.Extra)
+}
+
+// signNameError adds the proof that the requested name does not exist to a reply with the SOA of the zone, and signs all RRsets in the message of this zone
+func (z *zoneSigner) signNameError(m *dnssrv.Msg, view, qname, hostName, domainName string) {
+	for _, rr := range m.Ns {
+		if soa, ok := rr.(*dnssrv.SOA); ok {
+			m.Ns = append(m.Ns, z.nameErrorDenial(view, qname, hostName, domainName, negativeTTL(soa))...)
+			break
+		}
+	}
+
+	m.Answer = z.signSection(m.Answer)
+	m.Ns = z.signSection(m.Ns)
+	m.Extra = z.signSection(m.Extra)
+}
+
+// UpdateDNSSEC loads the signing keys of all domains with DNSSEC enabled
+// signers of which the keys and settings did not change are kept, including their signature cache
+func UpdateDNSSEC(domains map[string]Domain) {
+	log := logging.For("dns/dnssec/update")
+	signers := make(map[string]*zoneSigner)
+	for domainName, domain := range domains {
+		if len(domain.DNSSEC.Keys) == 0 {
+			continue
+		}
+
+		zone := strings.ToLower(domainName)
+		signer, err := newZoneSigner(zone, domain.DNSSEC, domain.TTL)
+		if err != nil {
+			log.WithField("domain", zone).WithField("error", err).Error("Failed to load DNSSEC keys, domain will not be signed")
+			continue
+		}
+
+		if existing := getSigner(zone); existing != nil && existing.equal(signer) {
+			signer = existing
+		} else {
+			log.WithField("domain", zone).WithField("keys", len(signer.keys)).WithField("nsec3", domain.DNSSEC.NSEC3).Info("DNSSEC signing enabled")
+		}
+
+		signers[zone] = signer
+	}
+
+	dnsmanager.Lock()
+	defer dnsmanager.Unlock()
+	dnsmanager.signers = signers
+}
+
+// CheckDNSSEC returns an error if the DNSSEC keys of a domain can not be used
+func CheckDNSSEC(domainName string, config DNSSEC) error {
+	_, err := newZoneSigner(domainName, config, 0)
+	return err
+}
+
+// DSRecords returns the DS records to publish in the parent zone for the key signing keys of a domain
+func DSRecords(domainName string, config DNSSEC, ttl int) ([]*dnssrv.DS, error) {
+	z, err := newZoneSigner(domainName, config, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	var ds []*dnssrv.DS
+	for _, k := range z.keysFor(dnssrv.TypeDNSKEY) {
+		ds = append(ds, k.dnskey.ToDS(dnssrv.SHA256))
+	}
+
+	return ds, nil
+}
@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+const signedDomain = "signed.example"
+
+var testRecordsSigned = []Record{
+	{UUID: "s-soa", Name: "", Type: "SOA", Target: "ns1.signed.example. hostmaster.signed.example. 1 3600 600 86400 300", TTL: 3600, Status: Online, Local: true},
+	{UUID: "s-ns", Name: "", Type: "NS", Target: "ns1.signed.example.", TTL: 3600, Status: Online, Local: true},
+	{UUID: "s-a", Name: "www", Type: "A", Target: "127.0.0.10", TTL: 60, Status: Online, Local: true},
+	{UUID: "s-nx", Name: "gone", Type: "A", Target: "127.0.0.11", TTL: 60, OfflineFallback: FallbackNXDomain, Status: Offline, Local: true},
+}
+
+func writeTestKey(t *testing.T, dir string, flags uint16) string {
+	key := &dnssrv.DNSKEY{
+		Hdr:       dnssrv.RR_Header{Name: signedDomain + ".", Rrtype: dnssrv.TypeDNSKEY, Class: dnssrv.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dnssrv.ECDSAP256SHA256,
+	}
+
+	priv, err := key.Generate(256)
+	assert.Nil(t, err)
+
+	base := filepath.Join(dir, fmt.Sprintf("K%s.+013+%05d", signedDomain, key.KeyTag()))
+	assert.Nil(t, ioutil.WriteFile(base+".key", []byte(key.String()+"\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(base+".private", []byte(key.PrivateKeyString(priv)), 0600))
+	return base
+}
+
+func signedQuery(name string, qtype uint16) *dnssrv.Msg {
+	m := new(dnssrv.Msg)
+	m.SetQuestion(name, qtype)
+	m.SetEdns0(4096, true)
+	m.Rcode, _ = parseQuery(m, "127.0.0.1:12345")
+	return m
+}
+
+func rrsOfType(rrs []dnssrv.RR, rrtype uint16) (result []dnssrv.RR) {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == rrtype {
+			result = append(result, rr)
+		}
+	}
+
+	return
+}
+
+func TestDNSSEC(t *testing.T) {
+	logging.Configure("stdout", "error")
+	dir, err := ioutil.TempDir("", "dnssec")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ksk := writeTestKey(t, dir, 257)
+	zsk := writeTestKey(t, dir, 256)
+	loadRecords("localdns", signedDomain, testRecordsSigned)
+
+	config := DNSSEC{Keys: []string{ksk + ".key", zsk}, SignatureValidity: 3600}
+	UpdateDNSSEC(map[string]Domain{signedDomain: {DNSSEC: config}})
+	defer UpdateDNSSEC(map[string]Domain{})
+	signer := getSigner(signedDomain)
+	assert.NotNil(t, signer)
+
+	// DNSKEY set is signed by the key signing key
+	m := signedQuery(signedDomain+".", dnssrv.TypeDNSKEY)
+	keys := rrsOfType(m.Answer, dnssrv.TypeDNSKEY)
+	assert.Len(t, keys, 2)
+	sigs := rrsOfType(m.Answer, dnssrv.TypeRRSIG)
+	assert.Len(t, sigs, 1)
+	assert.Nil(t, sigs[0].(*dnssrv.RRSIG).Verify(signer.keysFor(dnssrv.TypeDNSKEY)[0].dnskey, keys))
+
+	// A records are signed by the zone signing key, and the signature is cached
+	m = signedQuery("www."+signedDomain+".", dnssrv.TypeA)
+	records := rrsOfType(m.Answer, dnssrv.TypeA)
+	assert.Len(t, records, 1)
+	sigs = rrsOfType(m.Answer, dnssrv.TypeRRSIG)
+	assert.Len(t, sigs, 1)
+	zskKey := signer.keysFor(dnssrv.TypeA)[0].dnskey
+	assert.Nil(t, sigs[0].(*dnssrv.RRSIG).Verify(zskKey, records))
+	assert.True(t, m.Authoritative)
+
+	m = signedQuery("www."+signedDomain+".", dnssrv.TypeA)
+	assert.Equal(t, sigs[0].String(), rrsOfType(m.Answer, dnssrv.TypeRRSIG)[0].String())
+
+	// Without the DO bit no signatures are added
+	m = new(dnssrv.Msg)
+	m.SetQuestion("www."+signedDomain+".", dnssrv.TypeA)
+	parseQuery(m, "127.0.0.1:12345")
+	assert.Len(t, rrsOfType(m.Answer, dnssrv.TypeRRSIG), 0)
+
+	// Missing types are denied with a signed NSEC record
+	m = signedQuery("www."+signedDomain+".", dnssrv.TypeTXT)
+	assert.Len(t, m.Answer, 0)
+	nsec := rrsOfType(m.Ns, dnssrv.TypeNSEC)
+	assert.Len(t, nsec, 1)
+	assert.Equal(t, []uint16{dnssrv.TypeA, dnssrv.TypeRRSIG, dnssrv.TypeNSEC}, nsec[0].(*dnssrv.NSEC).TypeBitMap)
+	assert.Equal(t, uint32(300), nsec[0].Header().Ttl)
+	assert.Len(t, rrsOfType(m.Ns, dnssrv.TypeSOA), 1)
+	assert.Len(t, rrsOfType(m.Ns, dnssrv.TypeRRSIG), 2)
+
+	// names of which the records fall back to nxdomain are denied with NSEC records covering the name and the wildcard
+	m = signedQuery("gone."+signedDomain+".", dnssrv.TypeA)
+	assert.Equal(t, dnssrv.RcodeNameError, m.Rcode)
+	assert.Len(t, m.Answer, 0)
+	assert.Len(t, rrsOfType(m.Ns, dnssrv.TypeSOA), 1)
+	nsec = rrsOfType(m.Ns, dnssrv.TypeNSEC)
+	if assert.Len(t, nsec, 2) {
+		assert.Equal(t, previousName("gone."+signedDomain+"."), nsec[0].Header().Name)
+		assert.Equal(t, "\\000.gone."+signedDomain+".", nsec[0].(*dnssrv.NSEC).NextDomain)
+		assert.Equal(t, previousName("*."+signedDomain+"."), nsec[1].Header().Name)
+		assert.Equal(t, "\\000.*."+signedDomain+".", nsec[1].(*dnssrv.NSEC).NextDomain)
+		assert.Equal(t, uint32(300), nsec[0].Header().Ttl)
+	}
+
+	sigs = rrsOfType(m.Ns, dnssrv.TypeRRSIG)
+	assert.Len(t, sigs, 3)
+	for _, sig := range sigs {
+		rrsig := sig.(*dnssrv.RRSIG)
+		for _, rr := range m.Ns {
+			if rr.Header().Rrtype == rrsig.TypeCovered && rr.Header().Name == rrsig.Hdr.Name {
+				assert.Nil(t, rrsig.Verify(zskKey, []dnssrv.RR{rr}))
+			}
+		}
+	}
+
+	// NSEC3 denial uses the hashed owner name
+	config.NSEC3 = true
+	config.NSEC3Salt = "abcd"
+	UpdateDNSSEC(map[string]Domain{signedDomain: {DNSSEC: config}})
+	m = signedQuery("missing."+signedDomain+".", dnssrv.TypeTXT)
+	nsec3 := rrsOfType(m.Ns, dnssrv.TypeNSEC3)
+	assert.Len(t, nsec3, 1)
+	assert.True(t, nsec3[0].(*dnssrv.NSEC3).Match("missing."+signedDomain+"."))
+	assert.Len(t, rrsOfType(m.Ns, dnssrv.TypeRRSIG), 2)
+
+	// and proves the closest encloser, the next closer name and the wildcard do not exist for a name error (RFC 5155)
+	m = signedQuery("gone."+signedDomain+".", dnssrv.TypeA)
+	assert.Equal(t, dnssrv.RcodeNameError, m.Rcode)
+	nsec3 = rrsOfType(m.Ns, dnssrv.TypeNSEC3)
+	if assert.Len(t, nsec3, 3) {
+		assert.True(t, nsec3[0].(*dnssrv.NSEC3).Match(signedDomain+"."))
+		assert.Contains(t, nsec3[0].(*dnssrv.NSEC3).TypeBitMap, dnssrv.TypeSOA)
+		assert.True(t, nsec3[1].(*dnssrv.NSEC3).Cover("gone."+signedDomain+"."))
+		assert.True(t, nsec3[2].(*dnssrv.NSEC3).Cover("*."+signedDomain+"."))
+	}
+
+	assert.Len(t, rrsOfType(m.Ns, dnssrv.TypeRRSIG), 4)
+
+	// DS records are generated for the key signing key
+	ds, err := DSRecords(signedDomain, config, 3600)
+	assert.Nil(t, err)
+	assert.Len(t, ds, 1)
+	assert.Equal(t, uint8(dnssrv.SHA256), ds[0].DigestType)
+}
+
+func TestNextHash(t *testing.T) {
+	assert.Equal(t, "00000000000000000000000000000001", nextHash("00000000000000000000000000000000"))
+	assert.Equal(t, "00000000000000000000000000000110", nextHash("0000000000000000000000000000010V"))
+	assert.Equal(t, "0000000000000000000000000000010V", previousHash("00000000000000000000000000000110"))
+}
+
+func TestPreviousName(t *testing.T) {
+	filler := strings.Repeat("\\255", 59)
+	assert.Equal(t, "gond"+filler+".example.", previousName("gone.example."))
+	assert.Equal(t, "\\)"+strings.Repeat("\\255", 62)+".example.", previousName("*.example."))
+	assert.Equal(t, "a.example.", previousName("a\\000.example."))
+	assert.Equal(t, "example.", previousName("\\000.example."))
+}
@@ -0,0 +1,231 @@
+package dns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// DynamicUpdate contains the settings for dynamic updates (RFC 2136) of a domain
+type DynamicUpdate struct {
+	Allow   []string `toml:"allow" json:"allow"`       // cidrs of clients allowed to update the zone
+	TSIGKey string   `toml:"tsig_key" json:"tsig_key"` // name of the tsig key clients must sign their update with
+}
+
+// DynamicZone is the set of dynamically added records of a domain
+type DynamicZone struct {
+	Domain  string   `json:"domain"`
+	Version int64    `json:"version"` // time of the last change in nanoseconds, the newest version wins within the cluster
+	Records []string `json:"records"` // records in zone file format
+}
+
+// dynamicNode is the node name under which dynamic records are served
+const dynamicNode = "dynamicdns"
+
+// dynamic holds the dynamic records of all domains
+var dynamic = struct {
+	sync.Mutex
+	zones   map[string]DynamicZone
+	updates map[string]DynamicUpdate
+	store   string
+	changes chan DynamicZone
+}{
+	zones:   make(map[string]DynamicZone),
+	updates: make(map[string]DynamicUpdate),
+	changes: make(chan DynamicZone, 100),
+}
+
+// DynamicChanges returns the channel on which local changes to dynamic zones are send, to be replicated to the cluster
+func DynamicChanges() <-chan DynamicZone {
+	return dynamic.changes
+}
+
+// SetDynamicStore sets the file dynamic records are persisted in, and loads the records stored in it
+func SetDynamicStore(file string) {
+	log := logging.For("dns/dynamic/store").WithField("file", file)
+	dynamic.Lock()
+	defer dynamic.Unlock()
+	if dynamic.store == file {
+		return
+	}
+
+	dynamic.store = file
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return
+	}
+
+	if err != nil {
+		log.WithField("error", err).Warn("Unable to read dynamic dns records")
+		return
+	}
+
+	var zones []DynamicZone
+	if err := json.Unmarshal(data, &zones); err != nil {
+		log.WithField("error", err).Warn("Unable to parse dynamic dns records")
+		return
+	}
+
+	for _, zone := range zones {
+		if existing, ok := dynamic.zones[zone.Domain]; !ok || zone.Version > existing.Version {
+			dynamic.zones[zone.Domain] = zone
+		}
+	}
+
+	log.WithField("domains", len(zones)).Info("Loaded dynamic dns records")
+	syncDynamicNode()
+}
+
+// UpdateDynamic sets the dynamic update settings of all domains, only records of domains allowing updates are served
+func UpdateDynamic(domains map[string]Domain) {
+	updates := make(map[string]DynamicUpdate)
+	for domainName, domain := range domains {
+		if len(domain.Update.Allow) > 0 || domain.Update.TSIGKey != "" {
+			updates[strings.ToLower(domainName)] = domain.Update
+		}
+	}
+
+	dynamic.Lock()
+	defer dynamic.Unlock()
+	dynamic.updates = updates
+	syncDynamicNode()
+}
+
+// getDynamicUpdate returns the dynamic update settings of a domain
+func getDynamicUpdate(domainName string) (DynamicUpdate, bool) {
+	dynamic.Lock()
+	defer dynamic.Unlock()
+	update, ok := dynamic.updates[strings.ToLower(strings.TrimSuffix(domainName, "."))]
+	return update, ok
+}
+
+// DynamicZones returns the dynamic records of all domains
+func DynamicZones() []DynamicZone {
+	dynamic.Lock()
+	defer dynamic.Unlock()
+	var zones []DynamicZone
+	for _, zone := range dynamic.zones {
+		zones = append(zones, zone)
+	}
+
+	return zones
+}
+
+// ApplyDynamicZone applies dynamic records received from the cluster, if they are newer then the ones we have
+func ApplyDynamicZone(zone DynamicZone) bool {
+	dynamic.Lock()
+	defer dynamic.Unlock()
+	zone.Domain = strings.ToLower(zone.Domain)
+	if existing, ok := dynamic.zones[zone.Domain]; ok && zone.Version <= existing.Version {
+		return false
+	}
+
+	logging.For("dns/dynamic/apply").WithField("domain", zone.Domain).WithField("version", zone.Version).WithField("records", len(zone.Records)).Info("Applying dynamic dns records from cluster")
+	dynamic.zones[zone.Domain] = zone
+	syncDynamicNode()
+	persistDynamic()
+	return true
+}
+
+// setDynamicRecords replaces the dynamic records of a domain, the caller must hold the dynamic lock
+func setDynamicRecords(domainName string, rrs []dnssrv.RR) {
+	log := logging.For("dns/dynamic/set").WithField("domain", domainName)
+	version := time.Now().UnixNano()
+	if existing, ok := dynamic.zones[domainName]; ok && version <= existing.Version {
+		version = existing.Version + 1
+	}
+
+	zone := DynamicZone{Domain: domainName, Version: version}
+	for _, rr := range rrs {
+		zone.Records = append(zone.Records, rr.String())
+	}
+
+	sort.Strings(zone.Records)
+	dynamic.zones[domainName] = zone
+	syncDynamicNode()
+	persistDynamic()
+
+	select {
+	case dynamic.changes <- zone:
+	default:
+		log.Warn("Dynamic dns change queue is full, change is not replicated to the cluster")
+	}
+}
+
+// dynamicRRs returns the dynamic records of a domain, the caller must hold the dynamic lock
+func dynamicRRs(domainName string) []dnssrv.RR {
+	var rrs []dnssrv.RR
+	for _, record := range dynamic.zones[domainName].Records {
+		if rr, err := dnssrv.NewRR(record); err == nil && rr != nil {
+			rrs = append(rrs, rr)
+		}
+	}
+
+	return rrs
+}
+
+// syncDynamicNode serves the dynamic records of domains allowing updates, the caller must hold the dynamic lock
+func syncDynamicNode() {
+	domains := make(map[string]Domain)
+	for domainName := range dynamic.updates {
+		var records []Record
+		for _, rr := range dynamicRRs(domainName) {
+			if record, ok := rrToRecord(domainName, rr); ok {
+				records = append(records, record)
+			}
+		}
+
+		if len(records) > 0 {
+			domains[domainName] = Domain{Records: records}
+		}
+	}
+
+	defer reindex()
+	dnsmanager.Lock()
+	defer dnsmanager.Unlock()
+	dnsmanager.node[dynamicNode] = Domains{Domains: domains}
+}
+
+// persistDynamic writes the dynamic records to the store, the caller must hold the dynamic lock
+func persistDynamic() {
+	if dynamic.store == "" {
+		return
+	}
+
+	log := logging.For("dns/dynamic/persist").WithField("file", dynamic.store)
+	var zones []DynamicZone
+	for _, zone := range dynamic.zones {
+		zones = append(zones, zone)
+	}
+
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Domain < zones[j].Domain })
+	data, err := json.MarshalIndent(zones, "", "  ")
+	if err != nil {
+		log.WithField("error", err).Warn("Unable to encode dynamic dns records")
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dynamic.store), 0750); err != nil {
+		log.WithField("error", err).Warn("Unable to create directory for dynamic dns records")
+		return
+	}
+
+	// write to a temporary file first, so a crash never leaves a partial store behind
+	tmp := dynamic.store + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		log.WithField("error", err).Warn("Unable to write dynamic dns records")
+		return
+	}
+
+	if err := os.Rename(tmp, dynamic.store); err != nil {
+		log.WithField("error", err).Warn("Unable to write dynamic dns records")
+	}
+}
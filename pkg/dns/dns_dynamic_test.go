@@ -0,0 +1,176 @@
+package dns
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/balancer"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+const dynamicDomain = "dyn.example"
+
+var testRecordsDynamic = []Record{
+	{UUID: "d-soa", Name: "", Type: "SOA", Target: "ns1.dyn.example. hostmaster.dyn.example. ###SERIAL### 3600 600 86400 300", TTL: 3600, Status: Online, Local: true},
+	{UUID: "d-a", Name: "www", Type: "A", Target: "127.0.4.1", TTL: 60, Status: Online, Local: true},
+}
+
+func TestDynamicUpdate(t *testing.T) {
+	logging.Configure("stdout", "error")
+	loadRecords("localdns", dynamicDomain, testRecordsDynamic)
+
+	dir, err := ioutil.TempDir("", "mercury-dynamic")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := filepath.Join(dir, "dns_dynamic.json")
+	SetDynamicStore(store)
+	defer SetDynamicStore("")
+
+	secret := "ZHluYW1pYy1zZWNyZXQ="
+	SetTSIGKeys(map[string]TSIGKey{"update-key": {Secret: secret}})
+	defer SetTSIGKeys(map[string]TSIGKey{})
+	UpdateDynamic(map[string]Domain{dynamicDomain: {Update: DynamicUpdate{TSIGKey: "update-key"}}})
+	defer UpdateDynamic(map[string]Domain{})
+
+	secrets := map[string]string{"update-key.": secret}
+	addr, stop := startTestServer(t, secrets)
+	defer stop()
+
+	c := &dnssrv.Client{Net: "tcp", TsigSecret: secrets}
+	txt, _ := dnssrv.NewRR("_acme-challenge.dyn.example. 60 IN TXT \"token\"")
+	sendUpdate := func(sign bool, prereq []dnssrv.RR, insert []dnssrv.RR, remove []dnssrv.RR) int {
+		m := new(dnssrv.Msg)
+		m.SetUpdate(dynamicDomain + ".")
+		m.Answer = prereq
+		m.Insert(insert)
+		m.RemoveRRset(remove)
+		if sign {
+			m.SetTsig("update-key.", dnssrv.HmacSHA256, 300, time.Now().Unix())
+		}
+
+		reply, _, err := c.Exchange(m, addr)
+		assert.Nil(t, err)
+		return reply.Rcode
+	}
+
+	query := func() *dnssrv.Msg {
+		m := new(dnssrv.Msg)
+		m.SetQuestion("_acme-challenge.dyn.example.", dnssrv.TypeTXT)
+		parseQuery(m, "127.0.0.1:12345")
+		return m
+	}
+
+	// unsigned updates are refused
+	assert.Equal(t, dnssrv.RcodeNotAuth, sendUpdate(false, nil, []dnssrv.RR{txt}, nil))
+
+	// signed update adds the record, and sends it to the cluster
+	assert.Equal(t, dnssrv.RcodeSuccess, sendUpdate(true, nil, []dnssrv.RR{txt}, nil))
+	assert.True(t, answerTarget(query(), "token"))
+	select {
+	case zone := <-DynamicChanges():
+		assert.Equal(t, dynamicDomain, zone.Domain)
+		assert.Len(t, zone.Records, 1)
+	case <-time.After(time.Second):
+		t.Error("Dynamic change was not send to the cluster")
+	}
+
+	// prerequisite that the rrset does not exist fails
+	notUsed := new(dnssrv.Msg)
+	notUsed.RRsetNotUsed([]dnssrv.RR{txt})
+	assert.Equal(t, dnssrv.RcodeYXRrset, sendUpdate(true, notUsed.Answer, []dnssrv.RR{txt}, nil))
+
+	// records outside of the zone are rejected
+	other, _ := dnssrv.NewRR("www.other.example. 60 IN A 127.0.0.1")
+	assert.Equal(t, dnssrv.RcodeNotZone, sendUpdate(true, nil, []dnssrv.RR{other}, nil))
+
+	// the record survives a restart
+	data, err := ioutil.ReadFile(store)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "token")
+
+	dynamic.Lock()
+	dynamic.store = ""
+	dynamic.zones = make(map[string]DynamicZone)
+	syncDynamicNode()
+	dynamic.Unlock()
+	assert.False(t, answerTarget(query(), "token"))
+	SetDynamicStore(store)
+	assert.True(t, answerTarget(query(), "token"))
+
+	// static records can't be removed, dynamic ones can
+	www, _ := dnssrv.NewRR("www.dyn.example. 60 IN A 127.0.4.1")
+	assert.Equal(t, dnssrv.RcodeSuccess, sendUpdate(true, nil, nil, []dnssrv.RR{txt, www}))
+	assert.False(t, answerTarget(query(), "token"))
+	select {
+	case <-DynamicChanges():
+	case <-time.After(time.Second):
+		t.Error("Dynamic change was not send to the cluster")
+	}
+
+	m := new(dnssrv.Msg)
+	m.SetQuestion("www.dyn.example.", dnssrv.TypeA)
+	parseQuery(m, "127.0.0.1:12345")
+	assert.True(t, answerTarget(m, "127.0.4.1"))
+
+	// only newer versions from the cluster are applied
+	current := DynamicZones()[0]
+	assert.False(t, ApplyDynamicZone(DynamicZone{Domain: dynamicDomain, Version: current.Version - 1, Records: []string{txt.String()}}))
+	assert.False(t, answerTarget(query(), "token"))
+	assert.True(t, ApplyDynamicZone(DynamicZone{Domain: dynamicDomain, Version: current.Version + 1, Records: []string{txt.String()}}))
+	assert.True(t, answerTarget(query(), "token"))
+}
+
+func TestDynamicUpdateConcurrent(t *testing.T) {
+	logging.Configure("stdout", "error")
+	loadRecords("localdns", "race.example", []Record{{UUID: "race-soa", Name: "", Type: "SOA", Target: "ns1.race.example. hostmaster.race.example. ###SERIAL### 3600 600 86400 300", TTL: 3600, Status: Online, Local: true}})
+	UpdateDynamic(map[string]Domain{"race.example": {Update: DynamicUpdate{Allow: []string{"127.0.0.0/8"}}}})
+	defer UpdateDynamic(map[string]Domain{})
+
+	// dynamic updates change the records of the dns manager, while the cluster updates the GLB records
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			txt, _ := dnssrv.NewRR("txt" + strconv.Itoa(i) + ".race.example. 60 IN TXT \"token\"")
+			r := new(dnssrv.Msg)
+			r.SetUpdate("race.example.")
+			r.Insert([]dnssrv.RR{txt})
+			m := new(dnssrv.Msg)
+			m.SetReply(r)
+			handleUpdate(&testResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}}, r, m)
+			assert.Equal(t, dnssrv.RcodeSuccess, m.Rcode)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			status := Online
+			if i%2 == 0 {
+				status = Offline
+			}
+
+			Update("racenode"+strconv.Itoa(i%5), "race.example", Record{UUID: "race-www", Name: "www", Type: "A", Target: "127.0.14.1", TTL: 60, Status: status, Statistics: balancer.NewStatistics("race-www", 1)})
+		}
+	}()
+
+	wg.Wait()
+	m := new(dnssrv.Msg)
+	m.SetQuestion("txt49.race.example.", dnssrv.TypeTXT)
+	parseQuery(m, "127.0.0.1:12345")
+	assert.True(t, answerTarget(m, "token"))
+
+	// the changes are not replicated in this test
+	for len(dynamic.changes) > 0 {
+		<-dynamic.changes
+	}
+}
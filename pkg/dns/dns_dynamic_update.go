@@ -0,0 +1,285 @@
+package dns
+
+import (
+	"strings"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// handleUpdate processes a dynamic update (RFC 2136) of a local domain
+func handleUpdate(w dnssrv.ResponseWriter, r *dnssrv.Msg, m *dnssrv.Msg) {
+	clientIP := remoteIP(w.RemoteAddr())
+	log := logging.For("dns/server/update").WithField("client", clientIP)
+	if len(r.Question) != 1 || r.Question[0].Qtype != dnssrv.TypeSOA {
+		m.SetRcode(r, dnssrv.RcodeFormatError)
+		return
+	}
+
+	domainName := strings.ToLower(strings.TrimSuffix(r.Question[0].Name, "."))
+	log = log.WithField("domain", domainName)
+	update, ok := getDynamicUpdate(domainName)
+	if !ok || !localZone("", domainName) {
+		log.Warn("Dynamic update refused, domain does not allow updates")
+		m.SetRcode(r, dnssrv.RcodeNotAuth)
+		return
+	}
+
+	if rcode := clientAllowed(w, r, update.Allow, update.TSIGKey, clientIP); rcode != dnssrv.RcodeSuccess {
+		log.WithField("rcode", dnssrv.RcodeToString[rcode]).Warn("Dynamic update refused")
+		m.SetRcode(r, rcode)
+		return
+	}
+
+	// updates are processed one at a time, so the prerequisites can't change while applying them
+	dynamic.Lock()
+	defer dynamic.Unlock()
+
+	if rcode := checkPrerequisites(domainName, r.Answer); rcode != dnssrv.RcodeSuccess {
+		log.WithField("rcode", dnssrv.RcodeToString[rcode]).Info("Dynamic update prerequisites not met")
+		m.SetRcode(r, rcode)
+		return
+	}
+
+	rrs, changed, rcode := applyUpdates(domainName, dynamicRRs(domainName), r.Ns)
+	if rcode != dnssrv.RcodeSuccess {
+		log.WithField("rcode", dnssrv.RcodeToString[rcode]).Warn("Dynamic update rejected")
+		m.SetRcode(r, rcode)
+		return
+	}
+
+	if changed {
+		setDynamicRecords(domainName, rrs)
+		log.WithField("updates", len(r.Ns)).WithField("records", len(rrs)).Info("Dynamic update applied")
+	}
+}
+
+// acceptMsg accepts dynamic updates, which can contain any number of records, in addition to the messages accepted by default
+func acceptMsg(dh dnssrv.Header) dnssrv.MsgAcceptAction {
+	opcode := int(dh.Bits>>11) & 0xF
+	if opcode != dnssrv.OpcodeUpdate {
+		return dnssrv.DefaultMsgAcceptFunc(dh)
+	}
+
+	if isResponse := dh.Bits&(1<<15) != 0; isResponse {
+		return dnssrv.MsgIgnore
+	}
+
+	if dh.Qdcount != 1 {
+		return dnssrv.MsgReject
+	}
+
+	return dnssrv.MsgAccept
+}
+
+// relativeName returns the name of a record relative to its domain
+func relativeName(domainName, name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(name), dnssrv.Fqdn(domainName)), ".")
+}
+
+// isMetaType returns true for types that can't be stored in a zone
+func isMetaType(t uint16) bool {
+	return t == dnssrv.TypeOPT || (t >= 128 && t <= 255)
+}
+
+// nameInUse returns true if any record exists for the name in a view
+func nameInUse(view, domainName, hostName string) bool {
+	d := currentIndex().view(view).domain(domainName)
+	return d != nil && d.names[hostName]
+}
+
+// servedRRs returns the records of a name and type as they are served to clients
+func servedRRs(domainName, hostName string, rtype uint16) []dnssrv.RR {
+	var rrs []dnssrv.RR
+	for _, record := range getAllRecords("", hostName, domainName, dnssrv.TypeToString[rtype]) {
+		if rr, err := recordRR(domainName, record); err == nil && rr != nil {
+			rrs = append(rrs, rr)
+		}
+	}
+
+	return rrs
+}
+
+// containsRR returns true if an identical record, ignoring the ttl, is in the list
+func containsRR(rrs []dnssrv.RR, rr dnssrv.RR) bool {
+	for _, r := range rrs {
+		if dnssrv.IsDuplicate(r, rr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPrerequisites checks the prerequisite section of an update (RFC 2136 section 3.2)
+func checkPrerequisites(domainName string, prereqs []dnssrv.RR) int {
+	type nameType struct {
+		name  string
+		rtype uint16
+	}
+
+	values := make(map[nameType][]dnssrv.RR)
+	for _, rr := range prereqs {
+		h := rr.Header()
+		if h.Ttl != 0 {
+			return dnssrv.RcodeFormatError
+		}
+
+		if !dnssrv.IsSubDomain(dnssrv.Fqdn(domainName), strings.ToLower(h.Name)) {
+			return dnssrv.RcodeNotZone
+		}
+
+		name := relativeName(domainName, h.Name)
+		switch h.Class {
+		case dnssrv.ClassANY:
+			if h.Rdlength != 0 {
+				return dnssrv.RcodeFormatError
+			}
+
+			if h.Rrtype == dnssrv.TypeANY && !nameInUse("", domainName, name) {
+				return dnssrv.RcodeNameError
+			}
+
+			if h.Rrtype != dnssrv.TypeANY && len(servedRRs(domainName, name, h.Rrtype)) == 0 {
+				return dnssrv.RcodeNXRrset
+			}
+
+		case dnssrv.ClassNONE:
+			if h.Rdlength != 0 {
+				return dnssrv.RcodeFormatError
+			}
+
+			if h.Rrtype == dnssrv.TypeANY && nameInUse("", domainName, name) {
+				return dnssrv.RcodeYXDomain
+			}
+
+			if h.Rrtype != dnssrv.TypeANY && len(servedRRs(domainName, name, h.Rrtype)) > 0 {
+				return dnssrv.RcodeYXRrset
+			}
+
+		case dnssrv.ClassINET:
+			key := nameType{name: name, rtype: h.Rrtype}
+			values[key] = append(values[key], rr)
+
+		default:
+			return dnssrv.RcodeFormatError
+		}
+	}
+
+	// value dependent prerequisites must match the complete rrset
+	for key, rrs := range values {
+		served := servedRRs(domainName, key.name, key.rtype)
+		if len(served) == 0 {
+			return dnssrv.RcodeNXRrset
+		}
+
+		for _, rr := range rrs {
+			if !containsRR(served, rr) {
+				return dnssrv.RcodeNXRrset
+			}
+		}
+
+		for _, rr := range served {
+			if !containsRR(rrs, rr) {
+				return dnssrv.RcodeNXRrset
+			}
+		}
+	}
+
+	return dnssrv.RcodeSuccess
+}
+
+// applyUpdates applies the update section (RFC 2136 section 3.4) to the dynamic records of a domain
+func applyUpdates(domainName string, rrs []dnssrv.RR, updates []dnssrv.RR) ([]dnssrv.RR, bool, int) {
+	log := logging.For("dns/server/update").WithField("domain", domainName)
+
+	// check all updates before applying any of them
+	for _, rr := range updates {
+		h := rr.Header()
+		if !dnssrv.IsSubDomain(dnssrv.Fqdn(domainName), strings.ToLower(h.Name)) {
+			return nil, false, dnssrv.RcodeNotZone
+		}
+
+		switch h.Class {
+		case dnssrv.ClassINET:
+			if isMetaType(h.Rrtype) {
+				return nil, false, dnssrv.RcodeFormatError
+			}
+
+		case dnssrv.ClassANY:
+			if h.Ttl != 0 || h.Rdlength != 0 || (h.Rrtype != dnssrv.TypeANY && isMetaType(h.Rrtype)) {
+				return nil, false, dnssrv.RcodeFormatError
+			}
+
+		case dnssrv.ClassNONE:
+			if h.Ttl != 0 || isMetaType(h.Rrtype) {
+				return nil, false, dnssrv.RcodeFormatError
+			}
+
+		default:
+			return nil, false, dnssrv.RcodeFormatError
+		}
+	}
+
+	changed := false
+	for _, rr := range updates {
+		h := rr.Header()
+		name := relativeName(domainName, h.Name)
+
+		// the SOA and its serial are managed by the server
+		if h.Rrtype == dnssrv.TypeSOA {
+			log.WithField("name", h.Name).Debug("Ignoring update of SOA record")
+			continue
+		}
+
+		switch h.Class {
+		case dnssrv.ClassINET:
+			add := dnssrv.Copy(rr)
+			add.Header().Name = strings.ToLower(h.Name)
+			kept := rrs[:0]
+			for _, existing := range rrs {
+				if !dnssrv.IsDuplicate(existing, add) {
+					kept = append(kept, existing)
+				}
+			}
+
+			rrs = append(kept, add)
+			changed = true
+
+		case dnssrv.ClassANY:
+			kept := rrs[:0]
+			for _, existing := range rrs {
+				eh := existing.Header()
+				match := relativeName(domainName, eh.Name) == name && (h.Rrtype == dnssrv.TypeANY || eh.Rrtype == h.Rrtype)
+				if match && name == "" && h.Rrtype == dnssrv.TypeANY && eh.Rrtype == dnssrv.TypeNS {
+					match = false
+				}
+
+				if match {
+					changed = true
+				} else {
+					kept = append(kept, existing)
+				}
+			}
+
+			rrs = kept
+
+		case dnssrv.ClassNONE:
+			remove := dnssrv.Copy(rr)
+			remove.Header().Class = dnssrv.ClassINET
+			remove.Header().Name = strings.ToLower(h.Name)
+			kept := rrs[:0]
+			for _, existing := range rrs {
+				if dnssrv.IsDuplicate(existing, remove) {
+					changed = true
+				} else {
+					kept = append(kept, existing)
+				}
+			}
+
+			rrs = kept
+		}
+	}
+
+	return rrs, changed, dnssrv.RcodeSuccess
+}
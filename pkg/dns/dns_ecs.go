@@ -0,0 +1,104 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// TrustClientSubnet sets the networks of resolvers which are allowed to send a EDNS Client Subnet (RFC 7871)
+func TrustClientSubnet(cidr []string) {
+	log := logging.For("dns/trustclientsubnet")
+	var cidrs []*net.IPNet
+	for _, c := range cidr {
+		_, ipnet, err := net.ParseCIDR(c)
+		if err != nil {
+			log.WithField("cidr", c).Warn("Invalid cidr in client subnet trusted resolvers")
+			continue
+		}
+
+		cidrs = append(cidrs, ipnet)
+	}
+
+	dnsmanager.Lock()
+	defer dnsmanager.Unlock()
+	dnsmanager.ECSTrusted = cidrs
+}
+
+// clientSubnetTrusted returns true if the resolver is allowed to send a client subnet
+func clientSubnetTrusted(resolver net.IP) bool {
+	dnsmanager.RLock()
+	defer dnsmanager.RUnlock()
+	for _, cidr := range dnsmanager.ECSTrusted {
+		if cidr.Contains(resolver) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestClientSubnet returns the client subnet of a request if it was send by a trusted resolver
+// the returned option is a copy with scope 0, to be added to the reply
+func requestClientSubnet(opt *dnssrv.OPT, resolver net.IP) *dnssrv.EDNS0_SUBNET {
+	if opt == nil {
+		return nil
+	}
+
+	for _, o := range opt.Option {
+		ecs, ok := o.(*dnssrv.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+
+		if !clientSubnetTrusted(resolver) {
+			logging.For("dns/server/clientsubnet").WithField("resolver", resolver).Debug("Ignoring client subnet of untrusted resolver")
+			return nil
+		}
+
+		reply := *ecs
+		reply.SourceScope = 0
+		return &reply
+	}
+
+	return nil
+}
+
+// replyClientSubnet returns the client subnet option of a reply, or nil if there is none
+func replyClientSubnet(m *dnssrv.Msg) *dnssrv.EDNS0_SUBNET {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dnssrv.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+
+	return nil
+}
+
+// clientSubnetIP returns the address of the client subnet, or nil if the client requested to not use its subnet
+func clientSubnetIP(ecs *dnssrv.EDNS0_SUBNET) net.IP {
+	if ecs == nil || ecs.SourceNetmask == 0 || ecs.Address == nil {
+		return nil
+	}
+
+	return ecs.Address
+}
+
+// clientAwareBalanceMode returns true if the answer of the balance mode depends on the client ip
+func clientAwareBalanceMode(mode string) bool {
+	for _, m := range strings.Split(mode, ",") {
+		switch m {
+		case "topology":
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/balancer"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// testResponseWriter captures the reply of a dns request
+type testResponseWriter struct {
+	dnssrv.ResponseWriter
+	remote net.Addr
+	msg    *dnssrv.Msg
+}
+
+func (w *testResponseWriter) RemoteAddr() net.Addr { return w.remote }
+func (w *testResponseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
+}
+func (w *testResponseWriter) WriteMsg(m *dnssrv.Msg) error { w.msg = m; return nil }
+
+func ecsQuery(resolver string, subnet string) (*dnssrv.Msg, *dnssrv.EDNS0_SUBNET) {
+	r := new(dnssrv.Msg)
+	r.SetQuestion("www.ecs.example.", dnssrv.TypeA)
+	r.SetEdns0(4096, false)
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	ones, _ := ipnet.Mask.Size()
+	r.IsEdns0().Option = append(r.IsEdns0().Option, &dnssrv.EDNS0_SUBNET{Code: dnssrv.EDNS0SUBNET, Family: 1, SourceNetmask: uint8(ones), Address: ipnet.IP})
+
+	w := &testResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP(resolver), Port: 12345}}
+	handleDNSRequest(w, r)
+	return w.msg, replyClientSubnet(w.msg)
+}
+
+func TestClientSubnet(t *testing.T) {
+	logging.Configure("stdout", "error")
+	for _, r := range []Record{
+		{UUID: "ecs-1", Name: "www", Type: "A", Target: "127.0.1.1", BalanceMode: "topology", LocalNetwork: "10.1.0.0/16", Status: Online},
+		{UUID: "ecs-2", Name: "www", Type: "A", Target: "127.0.1.2", BalanceMode: "topology", LocalNetwork: "10.2.0.0/16", Status: Online},
+	} {
+		r.Statistics = &balancer.Statistics{UUID: r.UUID, Topology: []string{r.LocalNetwork}, RWMutex: new(sync.RWMutex)}
+		Update("ecs-"+r.UUID, "ecs.example", r)
+	}
+
+	TrustClientSubnet([]string{"192.168.0.0/24"})
+	defer TrustClientSubnet([]string{})
+
+	// trusted resolver, balanced on client subnet and scope is echoed
+	m, ecs := ecsQuery("192.168.0.1", "10.2.3.0/24")
+	assert.Len(t, m.Answer, 1)
+	assert.True(t, answerTarget(m, "127.0.1.2"))
+	assert.NotNil(t, ecs)
+	assert.Equal(t, uint8(24), ecs.SourceNetmask)
+	assert.Equal(t, uint8(24), ecs.SourceScope)
+	assert.Equal(t, "10.2.3.0", ecs.Address.String())
+
+	m, _ = ecsQuery("192.168.0.1", "10.1.3.0/24")
+	assert.True(t, answerTarget(m, "127.0.1.1"))
+
+	// untrusted resolver, client subnet is ignored and not echoed
+	m, ecs = ecsQuery("192.168.1.1", "10.2.3.0/24")
+	assert.Len(t, m.Answer, 2)
+	assert.Nil(t, ecs)
+
+	// source prefix 0 means the client subnet should not be used
+	m, ecs = ecsQuery("192.168.0.1", "0.0.0.0/0")
+	assert.Len(t, m.Answer, 2)
+	assert.NotNil(t, ecs)
+	assert.Equal(t, uint8(0), ecs.SourceScope)
+
+	assert.False(t, clientAwareBalanceMode("roundrobin"))
+	assert.True(t, clientAwareBalanceMode("topology,preference"))
+}
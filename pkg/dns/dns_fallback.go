@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+
+	dnssrv "github.com/miekg/dns"
+)
+
+// Offline fallbacks, the reply if all records of a record set are offline
+const (
+	FallbackOffline  = "offline"  // reply with the offline records (default)
+	FallbackNXDomain = "nxdomain" // reply that the name does not exist
+	FallbackServFail = "servfail" // reply with a server failure, so resolvers try the next nameserver
+	FallbackStatic   = "static"   // reply with the fallback target
+)
+
+// CheckOfflineFallback validates the offline fallback of a record, and its fallback targets for A and AAAA records
+func CheckOfflineFallback(fallback string, targets ...string) error {
+	switch fallback {
+	case "", FallbackOffline, FallbackNXDomain, FallbackServFail:
+		return nil
+	case FallbackStatic:
+	default:
+		return fmt.Errorf("Invalid offline fallback %s, must be one of: %s, %s, %s or %s", fallback, FallbackOffline, FallbackNXDomain, FallbackServFail, FallbackStatic)
+	}
+
+	found := false
+	for _, target := range targets {
+		if target == "" {
+			continue
+		}
+
+		if net.ParseIP(target) == nil {
+			return fmt.Errorf("Invalid fallback target %s, it must be an ip", target)
+		}
+
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("Offline fallback %s requires a fallback target", FallbackStatic)
+	}
+
+	return nil
+}
+
+// offlineFallbackRecords returns the records to reply with if all records of a record set are offline
+func offlineFallbackRecords(offlineRecords []Record) []Record {
+	switch offlineRecords[0].OfflineFallback {
+	case FallbackNXDomain, FallbackServFail:
+		return nil
+	case FallbackStatic:
+		if offlineRecords[0].FallbackTarget == "" {
+			return nil
+		}
+
+		record := offlineRecords[0]
+		record.Target = record.FallbackTarget
+		return []Record{record}
+	}
+
+	return offlineRecords
+}
+
+// offlineFallback returns the offline fallback of a record set if all of its records are offline, or "" if there are online records
+func offlineFallback(view, hostName, domainName string, qtype uint16) string {
+	searchName := hostName
+	if wildcard, isWildcard := wildcardName(view, hostName, domainName); isWildcard {
+		searchName = wildcard
+	}
+
+	entries := currentIndex().view(view).lookup(domainName, searchName, dnssrv.TypeToString[qtype])
+	for _, entry := range entries {
+		if entry.record.Status == Online {
+			return ""
+		}
+	}
+
+	if len(entries) == 0 {
+		return ""
+	}
+
+	return entries[0].record.OfflineFallback
+}
+
+// limitAnswers returns the first records after balancing, up to the maximum amount of answers of the record set
+func limitAnswers(records []Record) []Record {
+	if max := records[0].MaxAnswers; max > 0 && len(records) > max {
+		return records[:max]
+	}
+
+	return records
+}
@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnswersAndFallback(t *testing.T) {
+	logging.Configure("stdout", "error")
+	loadRecords("localdns", "fallback.example", []Record{{UUID: "fb-soa", Name: "", Type: "SOA", Target: "ns1.fallback.example. hostmaster.fallback.example. ###SERIAL### 3600 600 86400 300", TTL: 3600, Status: Online, Local: true}})
+	for i, node := range []string{"fbnode1", "fbnode2", "fbnode3"} {
+		target := []string{"127.0.13.1", "127.0.13.2", "127.0.13.3"}[i]
+		loadRecords(node, "fallback.example", []Record{
+			{UUID: "fb-all-" + node, Name: "all", Type: "A", Target: target, TTL: 60, BalanceMode: "roundrobin", Status: Online},
+			{UUID: "fb-top-" + node, Name: "top", Type: "A", Target: target, TTL: 60, BalanceMode: "roundrobin", MaxAnswers: 2, Status: Online},
+		})
+	}
+
+	loadRecords("fbnode1", "fallback.example", []Record{
+		{UUID: "fb-off", Name: "off", Type: "A", Target: "127.0.13.4", TTL: 60, Status: Offline},
+		{UUID: "fb-nx", Name: "nx", Type: "A", Target: "127.0.13.5", TTL: 60, OfflineFallback: FallbackNXDomain, Status: Offline},
+		{UUID: "fb-sf", Name: "sf", Type: "A", Target: "127.0.13.6", TTL: 60, OfflineFallback: FallbackServFail, Status: Offline},
+		{UUID: "fb-static", Name: "static", Type: "A", Target: "127.0.13.7", TTL: 60, OfflineFallback: FallbackStatic, FallbackTarget: "192.0.2.80", Status: Offline},
+	})
+
+	query := func(name string) (*dnssrv.Msg, int) {
+		m := new(dnssrv.Msg)
+		m.SetQuestion(name, dnssrv.TypeA)
+		rcode, _ := parseQuery(m, "127.0.0.1:12345")
+		return m, rcode
+	}
+
+	// all online records are returned, unless the answers are limited
+	m, _ := query("all.fallback.example.")
+	assert.True(t, answerCount(m, 3))
+	m, _ = query("top.fallback.example.")
+	assert.True(t, answerCount(m, 2))
+
+	// by default the offline records are returned if none are online
+	m, rcode := query("off.fallback.example.")
+	assert.Equal(t, dnssrv.RcodeSuccess, rcode)
+	assert.True(t, answerTarget(m, "127.0.13.4"))
+
+	m, rcode = query("nx.fallback.example.")
+	assert.Equal(t, dnssrv.RcodeNameError, rcode)
+	assert.True(t, answerCount(m, 0))
+	if assert.Len(t, m.Ns, 1) {
+		assert.Equal(t, dnssrv.TypeSOA, m.Ns[0].Header().Rrtype)
+	}
+
+	m, rcode = query("sf.fallback.example.")
+	assert.Equal(t, dnssrv.RcodeServerFailure, rcode)
+	assert.True(t, answerCount(m, 0))
+
+	m, rcode = query("static.fallback.example.")
+	assert.Equal(t, dnssrv.RcodeSuccess, rcode)
+	assert.True(t, answerCount(m, 1))
+	assert.True(t, answerTarget(m, "192.0.2.80"))
+
+	assert.Nil(t, CheckOfflineFallback("", "", ""))
+	assert.Nil(t, CheckOfflineFallback(FallbackStatic, "192.0.2.80", ""))
+	assert.NotNil(t, CheckOfflineFallback(FallbackStatic, "", ""))
+	assert.NotNil(t, CheckOfflineFallback(FallbackStatic, "www.example.com", ""))
+	assert.NotNil(t, CheckOfflineFallback("drop"))
+}
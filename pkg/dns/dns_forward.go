@@ -0,0 +1,283 @@
+package dns
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// ForwardZone contains the upstream resolvers that forwarded requests for a zone are send to, instead of resolving them from the root
+type ForwardZone struct {
+	Upstreams     []string `toml:"upstreams" json:"upstreams"`             // ip or ip:port of the upstream resolvers, tried in order
+	Timeout       int      `toml:"timeout" json:"timeout"`                 // seconds to wait for an upstream before trying the next
+	TLS           bool     `toml:"tls" json:"tls"`                         // use DNS-over-TLS to the upstreams
+	TLSServerName string   `toml:"tls_server_name" json:"tls_server_name"` // name verified in the certificate of the upstreams
+}
+
+// UpstreamStats contains the health of an upstream resolver
+type UpstreamStats struct {
+	Zone         string  `json:"zone"`
+	Address      string  `json:"address"`
+	TLS          bool    `json:"tls"`
+	Online       bool    `json:"online"`
+	Queries      int64   `json:"queries"`
+	Failures     int64   `json:"failures"`
+	ResponseTime float64 `json:"responsetime"` // of the last reply in milliseconds
+	LastError    string  `json:"lasterror"`
+}
+
+// upstreamMaxFailures is the amount of consecutive failures after which an upstream is offline
+const upstreamMaxFailures = 3
+
+// upstreamProbeInterval is how often offline upstreams are checked for recovery
+const upstreamProbeInterval = 30 * time.Second
+
+// upstream is an upstream resolver of a forward zone
+type upstream struct {
+	sync.Mutex
+	address  string
+	online   bool
+	failed   int // consecutive failures
+	queries  int64
+	failures int64
+	rtt      time.Duration
+	err      string
+}
+
+// forwardZone is a forward zone with its upstreams
+type forwardZone struct {
+	name      string
+	config    ForwardZone
+	upstreams []*upstream
+}
+
+// forwarders holds the forward zones, by zone name without trailing dot, the root zone is ""
+var forwarders = struct {
+	sync.RWMutex
+	once  sync.Once
+	zones map[string]*forwardZone
+}{zones: make(map[string]*forwardZone)}
+
+// CheckForwardZone validates the settings of a forward zone
+func CheckForwardZone(name string, zone ForwardZone) error {
+	if len(zone.Upstreams) == 0 {
+		return fmt.Errorf("Forward zone %s has no upstreams", name)
+	}
+
+	for _, address := range zone.Upstreams {
+		host := address
+		if h, _, err := net.SplitHostPort(address); err == nil {
+			host = h
+		}
+
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("Invalid upstream %s for forward zone %s, it must be an ip or ip:port", address, name)
+		}
+	}
+
+	return nil
+}
+
+// SetForwardZones sets the forward zones, the health of upstreams that did not change is kept
+func SetForwardZones(zones map[string]ForwardZone) {
+	forwarders.once.Do(func() {
+		go probeUpstreams()
+	})
+
+	forwarders.Lock()
+	defer forwarders.Unlock()
+	existing := forwarders.zones
+	forwarders.zones = make(map[string]*forwardZone)
+	for name, config := range zones {
+		zoneName := strings.ToLower(strings.Trim(name, "."))
+		zone := &forwardZone{name: zoneName, config: config}
+		for _, address := range config.Upstreams {
+			address = upstreamAddress(address, config.TLS)
+			u := &upstream{address: address, online: true}
+			if old, ok := existing[zoneName]; ok && old.config.TLS == config.TLS {
+				for _, o := range old.upstreams {
+					if o.address == address {
+						u = o
+					}
+				}
+			}
+
+			zone.upstreams = append(zone.upstreams, u)
+		}
+
+		forwarders.zones[zoneName] = zone
+	}
+}
+
+// upstreamAddress adds the default port to the address of an upstream
+func upstreamAddress(address string, useTLS bool) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+
+	if useTLS {
+		return net.JoinHostPort(address, "853")
+	}
+
+	return net.JoinHostPort(address, "53")
+}
+
+// ForwardZoneStats returns the health of the upstreams of all forward zones
+func ForwardZoneStats() (stats []UpstreamStats) {
+	forwarders.RLock()
+	defer forwarders.RUnlock()
+	for _, zone := range forwarders.zones {
+		for _, u := range zone.upstreams {
+			u.Lock()
+			stats = append(stats, UpstreamStats{
+				Zone:         zone.name + ".",
+				Address:      u.address,
+				TLS:          zone.config.TLS,
+				Online:       u.online,
+				Queries:      u.queries,
+				Failures:     u.failures,
+				ResponseTime: float64(u.rtt) / float64(time.Millisecond),
+				LastError:    u.err,
+			})
+			u.Unlock()
+		}
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Zone < stats[j].Zone })
+	return stats
+}
+
+// forwardZoneFor returns the forward zone with the longest suffix of a name, or nil if none matches
+func forwardZoneFor(name string) *forwardZone {
+	forwarders.RLock()
+	defer forwarders.RUnlock()
+	if len(forwarders.zones) == 0 {
+		return nil
+	}
+
+	labels := dnssrv.SplitDomainName(strings.ToLower(name))
+	for i := 0; i <= len(labels); i++ {
+		if zone, ok := forwarders.zones[strings.Join(labels[i:], ".")]; ok {
+			return zone
+		}
+	}
+
+	return nil
+}
+
+// forwardResolve resolves a forwarded request with the upstreams of its forward zone, or from the root if it is not in a forward zone
+func forwardResolve(q dnssrv.Question) (*dnssrv.Msg, error) {
+	if zone := forwardZoneFor(q.Name); zone != nil {
+		return zone.resolve(q)
+	}
+
+	return dnsmanager.Resolver.Resolve(q.Name, dnssrv.TypeToString[q.Qtype])
+}
+
+// resolve sends a request to the upstreams of the zone until one replies, online upstreams are tried first
+func (z *forwardZone) resolve(q dnssrv.Question) (*dnssrv.Msg, error) {
+	log := logging.For("dns/forward/resolve").WithField("zone", z.name+".").WithField("name", q.Name).WithField("type", dnssrv.TypeToString[q.Qtype])
+	var online, offline []*upstream
+	for _, u := range z.upstreams {
+		u.Lock()
+		if u.online {
+			online = append(online, u)
+		} else {
+			offline = append(offline, u)
+		}
+		u.Unlock()
+	}
+
+	var err error
+	for _, u := range append(online, offline...) {
+		var reply *dnssrv.Msg
+		reply, err = z.exchange(u, q)
+		if err == nil {
+			return reply, nil
+		}
+
+		log.WithField("upstream", u.address).WithField("error", err).Warn("Upstream resolver failed, trying next")
+	}
+
+	return nil, fmt.Errorf("All upstreams of forward zone %s failed, last error: %s", z.name+".", err)
+}
+
+// exchange sends a request to an upstream, and updates its health
+func (z *forwardZone) exchange(u *upstream, q dnssrv.Question) (*dnssrv.Msg, error) {
+	m := new(dnssrv.Msg)
+	m.SetQuestion(q.Name, q.Qtype)
+	m.RecursionDesired = true
+	m.SetEdns0(dnssrv.DefaultMsgSize, false)
+
+	c := &dnssrv.Client{Net: "udp", Timeout: time.Duration(z.config.Timeout) * time.Second}
+	if z.config.TLS {
+		c.Net = "tcp-tls"
+		c.TLSConfig = &tls.Config{ServerName: z.config.TLSServerName}
+	}
+
+	reply, rtt, err := c.Exchange(m, u.address)
+	if err == nil && reply.Truncated && !z.config.TLS {
+		c.Net = "tcp"
+		reply, rtt, err = c.Exchange(m, u.address)
+	}
+
+	if err == nil && (reply.Rcode == dnssrv.RcodeServerFailure || reply.Rcode == dnssrv.RcodeRefused) {
+		err = fmt.Errorf("Upstream replied with %s", dnssrv.RcodeToString[reply.Rcode])
+	}
+
+	u.Lock()
+	defer u.Unlock()
+	u.queries++
+	if err != nil {
+		u.failures++
+		u.failed++
+		u.err = err.Error()
+		if u.online && u.failed >= upstreamMaxFailures {
+			logging.For("dns/forward/health").WithField("zone", z.name+".").WithField("upstream", u.address).WithField("error", err).Warn("Upstream resolver is offline")
+			u.online = false
+		}
+
+		return nil, err
+	}
+
+	u.failed = 0
+	u.rtt = rtt
+	if !u.online {
+		logging.For("dns/forward/health").WithField("zone", z.name+".").WithField("upstream", u.address).Info("Upstream resolver is online")
+		u.online = true
+	}
+
+	return reply, nil
+}
+
+// probeUpstreams checks offline upstreams for recovery, by requesting the SOA of their zone
+func probeUpstreams() {
+	ticker := time.NewTicker(upstreamProbeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		forwarders.RLock()
+		zones := make([]*forwardZone, 0, len(forwarders.zones))
+		for _, zone := range forwarders.zones {
+			zones = append(zones, zone)
+		}
+		forwarders.RUnlock()
+
+		for _, zone := range zones {
+			for _, u := range zone.upstreams {
+				u.Lock()
+				online := u.online
+				u.Unlock()
+				if !online {
+					go zone.exchange(u, dnssrv.Question{Name: dnssrv.Fqdn(zone.name), Qtype: dnssrv.TypeSOA, Qclass: dnssrv.ClassINET})
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// testUpstream answers all A requests with the same address
+type testUpstream struct{}
+
+func (h testUpstream) ServeDNS(w dnssrv.ResponseWriter, r *dnssrv.Msg) {
+	m := new(dnssrv.Msg)
+	m.SetReply(r)
+	rr, _ := dnssrv.NewRR(r.Question[0].Name + " 60 IN A 10.3.3.3")
+	m.Answer = []dnssrv.RR{rr}
+	extra, _ := dnssrv.NewRR("ns.corp.example. 60 IN A 10.3.3.4")
+	m.Extra = []dnssrv.RR{extra}
+	m.SetEdns0(512, false)
+	w.WriteMsg(m)
+}
+
+func TestForwardZones(t *testing.T) {
+	logging.Configure("stdout", "error")
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &dnssrv.Server{PacketConn: conn, Handler: testUpstream{}}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	// an upstream that is not listening
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	dead := closed.LocalAddr().String()
+	closed.Close()
+
+	zones := map[string]ForwardZone{"corp.example.": {Upstreams: []string{dead, conn.LocalAddr().String()}, Timeout: 1}}
+	SetForwardZones(zones)
+	defer SetForwardZones(map[string]ForwardZone{})
+	SetForwardCache(ForwardCache{Size: -1})
+	defer SetForwardCache(ForwardCache{})
+	AllowForwarding([]string{"127.0.0.0/8"})
+	defer AllowForwarding([]string{})
+
+	// the zone with the longest suffix is used
+	assert.NotNil(t, forwardZoneFor("host.CORP.example."))
+	assert.Nil(t, forwardZoneFor("www.example.org."))
+
+	// requests fail over to the next upstream
+	for i := 0; i < upstreamMaxFailures; i++ {
+		m := new(dnssrv.Msg)
+		m.SetQuestion("host.corp.example.", dnssrv.TypeA)
+		rcode, _ := parseQuery(m, "127.0.0.1:12345")
+		assert.Equal(t, -1, rcode)
+		assert.True(t, answerTarget(m, "10.3.3.3"))
+	}
+
+	// upstreams are offline after consecutive failures, and are tried last
+	_, err = forwardResolve(dnssrv.Question{Name: "other.corp.example.", Qtype: dnssrv.TypeA, Qclass: dnssrv.ClassINET})
+	assert.Nil(t, err)
+	stats := ForwardZoneStats()
+	if assert.Len(t, stats, 2) {
+		assert.Equal(t, "corp.example.", stats[0].Zone)
+		assert.False(t, stats[0].Online)
+		assert.Equal(t, int64(upstreamMaxFailures), stats[0].Failures)
+		assert.True(t, stats[1].Online)
+		assert.Equal(t, int64(upstreamMaxFailures+1), stats[1].Queries)
+	}
+
+	// the health of upstreams is kept when the config is reloaded
+	SetForwardZones(zones)
+	assert.False(t, ForwardZoneStats()[0].Online)
+
+	// the additional records of the upstream are passed on, the OPT record of the client is kept
+	m := new(dnssrv.Msg)
+	m.SetQuestion("host.corp.example.", dnssrv.TypeA)
+	m.SetEdns0(1232, false)
+	parseQuery(m, "127.0.0.1:12345")
+	assert.Len(t, m.Extra, 2)
+	if assert.NotNil(t, m.IsEdns0()) {
+		assert.Equal(t, uint16(1232), m.IsEdns0().UDPSize())
+	}
+
+	// requests fail if no upstream replies
+	SetForwardZones(map[string]ForwardZone{".": {Upstreams: []string{dead}, Timeout: 1}})
+	_, err = forwardResolve(dnssrv.Question{Name: "www.example.org.", Qtype: dnssrv.TypeA, Qclass: dnssrv.ClassINET})
+	assert.NotNil(t, err)
+	assert.Equal(t, ".", ForwardZoneStats()[0].Zone)
+
+	assert.NotNil(t, CheckForwardZone("corp.example", ForwardZone{}))
+	assert.NotNil(t, CheckForwardZone("corp.example", ForwardZone{Upstreams: []string{"resolver.example"}}))
+	assert.Nil(t, CheckForwardZone("corp.example", ForwardZone{Upstreams: []string{"10.0.0.1", "[2001:db8::1]:5353"}}))
+}
@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/schubergphilis/mercury/pkg/balancer"
+)
+
+// indexKey identifies the records of a name and type in a domain
+type indexKey struct {
+	name  string
+	rtype string
+}
+
+// indexedRecord is a record with the cluster node that serves it
+type indexedRecord struct {
+	node   string
+	record Record
+}
+
+// domainIndex holds the records of a domain of all cluster nodes, indexed by name and type
+type domainIndex struct {
+	records    map[indexKey][]indexedRecord
+	names      map[string]bool                   // names that have records
+	types      map[string][]string               // record types per name
+	exists     map[string]bool                   // names that have records, or names with records below them
+	statistics map[string][]*balancer.Statistics // statistics per record uuid
+}
+
+// recordIndex is a read-only snapshot of all dns records
+// it is replaced as a whole when records change, so queries never wait for cluster updates
+type recordIndex struct {
+	domains map[string]*domainIndex
+	views   map[string]*recordIndex // records served to the clients of each view
+}
+
+// dnsindex holds the current *recordIndex
+var dnsindex atomic.Value
+
+func init() {
+	dnsindex.Store(&recordIndex{domains: make(map[string]*domainIndex)})
+}
+
+// currentIndex returns the current snapshot of all dns records
+func currentIndex() *recordIndex {
+	return dnsindex.Load().(*recordIndex)
+}
+
+// view returns the index of a view, or the index itself for the default view
+func (i *recordIndex) view(name string) *recordIndex {
+	if v, ok := i.views[name]; ok {
+		return v
+	}
+
+	return i
+}
+
+// domain returns the index of a domain, or nil if it is not a local domain
+func (i *recordIndex) domain(domainName string) *domainIndex {
+	return i.domains[strings.ToLower(domainName)]
+}
+
+// lookup returns the records of a name and type in a domain
+func (i *recordIndex) lookup(domainName, hostName, rtype string) []indexedRecord {
+	d := i.domain(domainName)
+	if d == nil {
+		return nil
+	}
+
+	return d.records[indexKey{name: strings.ToLower(hostName), rtype: rtype}]
+}
+
+// reindexLock makes sure index updates are stored in the order the records changed
+var reindexLock sync.Mutex
+
+// reindex replaces the index of the given domains, or of all domains if none are given
+// the records are copied under a short read lock, the index itself is built without holding the dnsmanager lock
+func reindex(domainNames ...string) {
+	reindexLock.Lock()
+	defer reindexLock.Unlock()
+
+	all := len(domainNames) == 0
+	wanted := make(map[string]bool)
+	for _, domainName := range domainNames {
+		wanted[strings.ToLower(domainName)] = true
+	}
+
+	// records per domain and cluster node
+	copies := make(map[string]map[string][]Record)
+	dnsmanager.RLock()
+	for nodeName, node := range dnsmanager.node {
+		for domainName, domain := range node.Domains {
+			searchDomain := strings.ToLower(domainName)
+			if !all && !wanted[searchDomain] {
+				continue
+			}
+
+			if copies[searchDomain] == nil {
+				copies[searchDomain] = make(map[string][]Record)
+			}
+
+			copies[searchDomain][nodeName] = append(copies[searchDomain][nodeName], domain.Records...)
+		}
+	}
+	dnsmanager.RUnlock()
+
+	current := currentIndex()
+	next := &recordIndex{domains: make(map[string]*domainIndex, len(current.domains))}
+	if !all {
+		for name, d := range current.domains {
+			if !wanted[name] {
+				next.domains[name] = d
+			}
+		}
+	}
+
+	for domainName, nodes := range copies {
+		next.domains[domainName] = indexDomain(nodes)
+	}
+
+	// views serve the same domains, with their own records replacing the records of the same names
+	next.views = make(map[string]*recordIndex)
+	for viewName, domains := range viewRecords() {
+		view := &recordIndex{domains: make(map[string]*domainIndex, len(next.domains))}
+		for domainName, d := range next.domains {
+			view.domains[domainName] = d
+		}
+
+		previous := current.views[viewName]
+		for domainName, records := range domains {
+			if !all && !wanted[domainName] && previous != nil && previous.domains[domainName] != nil {
+				view.domains[domainName] = previous.domains[domainName]
+				continue
+			}
+
+			view.domains[domainName] = indexDomain(viewNodes(viewName, copies[domainName], records))
+		}
+
+		next.views[viewName] = view
+	}
+
+	dnsindex.Store(next)
+}
+
+// indexDomain builds the index of a domain from the records of all cluster nodes
+func indexDomain(nodes map[string][]Record) *domainIndex {
+	// a domain without records is still a local domain
+	d := &domainIndex{
+		records:    make(map[indexKey][]indexedRecord),
+		names:      make(map[string]bool),
+		types:      make(map[string][]string),
+		exists:     map[string]bool{"": true},
+		statistics: make(map[string][]*balancer.Statistics),
+	}
+
+	for nodeName, records := range nodes {
+		for _, record := range records {
+			name := strings.ToLower(record.Name)
+			key := indexKey{name: name, rtype: record.Type}
+			if len(d.records[key]) == 0 {
+				d.types[name] = append(d.types[name], record.Type)
+			}
+
+			d.records[key] = append(d.records[key], indexedRecord{node: nodeName, record: record})
+			d.names[name] = true
+			labels := strings.Split(name, ".")
+			for i := range labels {
+				d.exists[strings.Join(labels[i:], ".")] = true
+			}
+
+			if record.Statistics != nil {
+				d.statistics[record.UUID] = append(d.statistics[record.UUID], record.Statistics)
+			}
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/balancer"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordIndex(t *testing.T) {
+	logging.Configure("stdout", "error")
+	record := Record{UUID: "i-a", Name: "www", Type: "A", Target: "127.0.8.1", TTL: 60, Status: Online, Statistics: balancer.NewStatistics("i-a", 0)}
+	Update("indexnode1", "index.example", record)
+	record.Target = "127.0.8.2"
+	record.UUID = "i-b"
+	record.Statistics = balancer.NewStatistics("i-b", 0)
+	Update("indexnode2", "index.example", record)
+
+	// records of all nodes are found by name and type, case insensitive
+	assert.Len(t, currentIndex().lookup("INDEX.example", "WWW", "A"), 2)
+	assert.Len(t, currentIndex().lookup("index.example", "www", "AAAA"), 0)
+	assert.True(t, localZone("", "index.example"))
+
+	// a snapshot does not change when records are updated
+	snapshot := currentIndex()
+	MarkOffline("indexnode1")
+	for _, entry := range snapshot.lookup("index.example", "www", "A") {
+		assert.Equal(t, Online, entry.record.Status)
+	}
+
+	assert.Len(t, getAllRecords("", "www", "index.example", "A"), 1)
+
+	// counters are updated for the record that was answered
+	updateCounter("index.example", record)
+	updateCounter("index.example", record)
+	assert.Equal(t, int64(2), record.Statistics.ClientsConnectedGet())
+
+	// discarded nodes are removed from the index
+	Discard("indexnode1")
+	Discard("indexnode2")
+	assert.Len(t, currentIndex().lookup("index.example", "www", "A"), 0)
+	assert.False(t, localZone("", "index.example"))
+}
+
+// loadBenchmarkRecords loads records spread over several cluster nodes
+func loadBenchmarkRecords(b *testing.B, count int) {
+	logging.Configure("stdout", "error")
+	dnsmanager.Lock()
+	for n := 0; n < 3; n++ {
+		var records []Record
+		for i := n; i < count; i += 3 {
+			uuid := fmt.Sprintf("bench-%d", i)
+			records = append(records, Record{UUID: uuid, Name: fmt.Sprintf("host%d", i/3), Type: "A", Target: fmt.Sprintf("10.%d.%d.%d", n, (i/256)%256, i%256), TTL: 60, Status: Online, BalanceMode: "roundrobin", Statistics: balancer.NewStatistics(uuid, 0)})
+		}
+
+		dnsmanager.node[fmt.Sprintf("benchnode%d", n)] = Domains{Domains: map[string]Domain{"bench.example": {Records: records}}}
+	}
+	dnsmanager.Unlock()
+	reindex()
+
+	runtime.GC()
+	b.ResetTimer()
+}
+
+func BenchmarkGetAllRecords(b *testing.B) {
+	loadBenchmarkRecords(b, 3000)
+	for i := 0; i < b.N; i++ {
+		getAllRecords("", fmt.Sprintf("host%d", i%1000), "bench.example", "A")
+	}
+}
+
+func BenchmarkParseQuery(b *testing.B) {
+	loadBenchmarkRecords(b, 3000)
+	for i := 0; i < b.N; i++ {
+		m := new(dnssrv.Msg)
+		m.SetQuestion(fmt.Sprintf("host%d.bench.example.", i%1000), dnssrv.TypeA)
+		parseQuery(m, "127.0.0.1:12345")
+	}
+}
+
+func BenchmarkParseQueryParallel(b *testing.B) {
+	loadBenchmarkRecords(b, 3000)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			m := new(dnssrv.Msg)
+			m.SetQuestion(fmt.Sprintf("host%d.bench.example.", i%1000), dnssrv.TypeA)
+			parseQuery(m, "127.0.0.1:12345")
+			i++
+		}
+	})
+}
+
+func BenchmarkParseQueryDuringUpdates(b *testing.B) {
+	loadBenchmarkRecords(b, 3000)
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		record := Record{UUID: "bench-update", Name: "updated", Type: "A", TTL: 60, Status: Online, Statistics: balancer.NewStatistics("bench-update", 0)}
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			record.Target = fmt.Sprintf("10.9.%d.%d", (i/256)%256, i%256)
+			Update("benchnode0", "bench.example", record)
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		m := new(dnssrv.Msg)
+		m.SetQuestion(fmt.Sprintf("host%d.bench.example.", i%1000), dnssrv.TypeA)
+		parseQuery(m, "127.0.0.1:12345")
+	}
+
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,278 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// Push contains the settings to push the GLB records of a domain to an external primary with dynamic updates (RFC 2136)
+type Push struct {
+	Primary string `toml:"primary" json:"primary"`   // primary nameserver (ip:port) to send the updates to
+	Zone    string `toml:"zone" json:"zone"`         // zone to update on the primary, defaults to the domain
+	TSIGKey string `toml:"tsig_key" json:"tsig_key"` // name of the tsig key to sign the updates with
+	Delay   int    `toml:"delay" json:"delay"`       // seconds to wait for more changes before sending an update
+}
+
+// pushElectionInterval is how often the election is checked, and failed updates are retried
+var pushElectionInterval = 5 * time.Second
+
+// pushKey identifies a GLB rrset
+type pushKey struct {
+	name  string
+	rtype string
+}
+
+// pusher sends the GLB records of a domain to its external primary
+type pusher struct {
+	domain  string
+	config  Push
+	sent    map[pushKey]string // rrsets known to be on the primary, nil if unknown
+	dirty   bool               // the last update failed
+	changed chan bool
+	quit    chan bool
+}
+
+// pushers holds the running pushers, and the election of the node that sends the updates
+var pushers = struct {
+	sync.Mutex
+	domains map[string]*pusher
+	elected func() bool
+}{domains: make(map[string]*pusher)}
+
+// SetElection sets the function that reports if this node is elected to send updates to external primaries
+func SetElection(elected func() bool) {
+	pushers.Lock()
+	defer pushers.Unlock()
+	pushers.elected = elected
+}
+
+// pushElected returns true if this node should send updates to external primaries
+func pushElected() bool {
+	pushers.Lock()
+	defer pushers.Unlock()
+	return pushers.elected != nil && pushers.elected()
+}
+
+// UpdatePushes starts pushing the GLB records of new domains, and stops the ones no longer configured
+func UpdatePushes(domains map[string]Domain) {
+	log := logging.For("dns/push/update")
+	pushers.Lock()
+	defer pushers.Unlock()
+
+	for domainName, p := range pushers.domains {
+		if domain, ok := domains[domainName]; !ok || !reflect.DeepEqual(domain.Push, p.config) {
+			log.WithField("domain", domainName).Info("Stopping push of GLB records")
+			close(p.quit)
+			delete(pushers.domains, domainName)
+		}
+	}
+
+	for domainName, domain := range domains {
+		if domain.Push.Primary == "" {
+			continue
+		}
+
+		if _, ok := pushers.domains[domainName]; ok {
+			continue
+		}
+
+		p := &pusher{
+			domain:  strings.ToLower(domainName),
+			config:  domain.Push,
+			changed: make(chan bool, 1),
+			quit:    make(chan bool),
+		}
+
+		log.WithField("domain", domainName).WithField("primary", domain.Push.Primary).Info("Starting push of GLB records")
+		pushers.domains[domainName] = p
+		go p.run()
+	}
+}
+
+// pushChanged signals the pusher of a domain that its GLB records changed, or all pushers if domain is empty
+func pushChanged(domainName string) {
+	pushers.Lock()
+	defer pushers.Unlock()
+	for name, p := range pushers.domains {
+		if domainName == "" || strings.EqualFold(name, domainName) {
+			select {
+			case p.changed <- true:
+			default:
+			}
+		}
+	}
+}
+
+// delay returns the time to wait for more changes before sending an update
+func (p *pusher) delay() time.Duration {
+	if p.config.Delay > 0 {
+		return time.Duration(p.config.Delay) * time.Second
+	}
+
+	return 5 * time.Second
+}
+
+// run sends updates once the records did not change for the delay, and only if this node is elected
+func (p *pusher) run() {
+	log := logging.For("dns/push/run").WithField("domain", p.domain)
+	timer := time.NewTimer(p.delay())
+	defer timer.Stop()
+	ticker := time.NewTicker(pushElectionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.quit:
+			return
+
+		case <-p.changed:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+
+			timer.Reset(p.delay())
+			continue
+
+		case <-ticker.C:
+			// a newly elected node pushes all records, since it does not know what the previous node sent
+			// so forget what was sent as soon as another node is elected
+			if !pushElected() {
+				p.sent = nil
+				continue
+			}
+
+			if p.sent != nil && !p.dirty {
+				continue
+			}
+
+		case <-timer.C:
+		}
+
+		if !pushElected() {
+			p.sent = nil
+			continue
+		}
+
+		if err := p.push(); err != nil {
+			log.WithField("error", err).Warn("Failed to push GLB records to primary")
+			p.dirty = true
+			continue
+		}
+
+		p.dirty = false
+	}
+}
+
+// glbRRsets returns the GLB records of a domain as they are served to clients
+func glbRRsets(domainName string) map[pushKey][]dnssrv.RR {
+	keys := make(map[pushKey]bool)
+	dnsmanager.RLock()
+	for nodeName := range dnsmanager.node {
+		for _, record := range dnsmanager.node[nodeName].Domains[domainName].Records {
+			if !record.Local {
+				keys[pushKey{name: strings.ToLower(record.Name), rtype: record.Type}] = true
+			}
+		}
+	}
+	dnsmanager.RUnlock()
+
+	rrsets := make(map[pushKey][]dnssrv.RR)
+	for key := range keys {
+		for _, record := range getAllRecords("", key.name, domainName, key.rtype) {
+			if record.Local {
+				continue
+			}
+
+			if rr, err := recordRR(domainName, record); err == nil && rr != nil && !containsRR(rrsets[key], rr) {
+				rrsets[key] = append(rrsets[key], rr)
+			}
+		}
+	}
+
+	return rrsets
+}
+
+// rrsetString returns a comparable representation of an rrset
+func rrsetString(rrs []dnssrv.RR) string {
+	var s []string
+	for _, rr := range rrs {
+		s = append(s, rr.String())
+	}
+
+	sort.Strings(s)
+	return strings.Join(s, "\n")
+}
+
+// push sends the rrsets that changed since the last update to the primary
+func (p *pusher) push() error {
+	log := logging.For("dns/push/send").WithField("domain", p.domain).WithField("primary", p.config.Primary)
+	zone := p.config.Zone
+	if zone == "" {
+		zone = p.domain
+	}
+
+	rrsets := glbRRsets(p.domain)
+	sent := make(map[pushKey]string)
+	m := new(dnssrv.Msg)
+	m.SetUpdate(dnssrv.Fqdn(strings.ToLower(zone)))
+	for key, rrs := range rrsets {
+		sent[key] = rrsetString(rrs)
+		if p.sent != nil && p.sent[key] == sent[key] {
+			continue
+		}
+
+		m.RemoveRRset(rrs[:1])
+		m.Insert(rrs)
+	}
+
+	for key := range p.sent {
+		if _, ok := rrsets[key]; !ok {
+			owner := dnssrv.Fqdn(p.domain)
+			if key.name != "" {
+				owner = key.name + "." + owner
+			}
+
+			m.RemoveRRset([]dnssrv.RR{&dnssrv.ANY{Hdr: dnssrv.RR_Header{Name: owner, Rrtype: dnssrv.StringToType[key.rtype], Class: dnssrv.ClassINET}}})
+		}
+	}
+
+	if len(m.Ns) == 0 {
+		p.sent = sent
+		return nil
+	}
+
+	primary := p.config.Primary
+	if _, _, err := net.SplitHostPort(primary); err != nil {
+		primary = net.JoinHostPort(primary, "53")
+	}
+
+	c := &dnssrv.Client{Net: "tcp", Timeout: secondaryTimeout}
+	if p.config.TSIGKey != "" {
+		c.TsigSecret = tsigSecrets()
+		m.SetTsig(dnssrv.Fqdn(strings.ToLower(p.config.TSIGKey)), tsigAlgorithm(p.config.TSIGKey), 300, time.Now().Unix())
+	}
+
+	reply, _, err := c.Exchange(m, primary)
+	if err != nil {
+		return fmt.Errorf("Update request to %s failed: %s", primary, err)
+	}
+
+	if reply.Rcode != dnssrv.RcodeSuccess {
+		return fmt.Errorf("Update request to %s failed: %s", primary, dnssrv.RcodeToString[reply.Rcode])
+	}
+
+	log.WithField("records", len(m.Ns)).Info("Pushed GLB records to primary")
+	p.sent = sent
+	return nil
+}
@@ -0,0 +1,104 @@
+package dns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/balancer"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// testExternalPrimary receives the updates pushed to it
+type testExternalPrimary struct {
+	updates chan *dnssrv.Msg
+}
+
+func (p *testExternalPrimary) ServeDNS(w dnssrv.ResponseWriter, r *dnssrv.Msg) {
+	m := new(dnssrv.Msg)
+	m.SetReply(r)
+	if r.IsTsig() == nil || w.TsigStatus() != nil {
+		m.SetRcode(r, dnssrv.RcodeNotAuth)
+	} else {
+		p.updates <- r
+		tsig := r.IsTsig()
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+
+	w.WriteMsg(m)
+}
+
+func glbRecord(target string, status Status) Record {
+	return Record{Name: "www", Type: "A", Target: target, TTL: 30, Status: status, UUID: "push-" + target, Statistics: balancer.NewStatistics("push-"+target, 0)}
+}
+
+func TestPush(t *testing.T) {
+	logging.Configure("stdout", "error")
+	secret := "cHVzaC1zZWNyZXQ="
+	SetTSIGKeys(map[string]TSIGKey{"push-key": {Secret: secret}})
+	defer SetTSIGKeys(map[string]TSIGKey{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	primary := &testExternalPrimary{updates: make(chan *dnssrv.Msg, 10)}
+	server := &dnssrv.Server{Listener: listener, Net: "tcp", TsigSecret: map[string]string{"push-key.": secret}, MsgAcceptFunc: acceptMsg, Handler: primary}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	pushElectionInterval = 200 * time.Millisecond
+	defer func() { pushElectionInterval = 5 * time.Second }()
+
+	elected := int32(1)
+	SetElection(func() bool { return atomic.LoadInt32(&elected) == 1 })
+	defer SetElection(nil)
+	UpdatePushes(map[string]Domain{"push.example": {Push: Push{Primary: listener.Addr().String(), Zone: "example", TSIGKey: "push-key", Delay: 1}}})
+	defer UpdatePushes(map[string]Domain{})
+
+	waitForUpdate := func() *dnssrv.Msg {
+		select {
+		case m := <-primary.updates:
+			return m
+		case <-time.After(4 * time.Second):
+			return nil
+		}
+	}
+
+	// changes within the delay are send in a single update
+	Update("push1", "push.example", glbRecord("10.0.5.1", Online))
+	Update("push2", "push.example", glbRecord("10.0.5.2", Online))
+	m := waitForUpdate()
+	if assert.NotNil(t, m) {
+		assert.Equal(t, "example.", m.Question[0].Name)
+		assert.Len(t, m.Ns, 3)
+		assert.Equal(t, uint16(dnssrv.ClassANY), m.Ns[0].Header().Class)
+		assert.Contains(t, m.Ns[1].String()+m.Ns[2].String(), "10.0.5.1")
+		assert.Contains(t, m.Ns[1].String()+m.Ns[2].String(), "10.0.5.2")
+	}
+
+	// an offline record is removed from the rrset
+	Update("push2", "push.example", glbRecord("10.0.5.2", Offline))
+	m = waitForUpdate()
+	if assert.NotNil(t, m) {
+		assert.Len(t, m.Ns, 2)
+		assert.Contains(t, m.Ns[1].String(), "10.0.5.1")
+	}
+
+	// only the elected node sends updates
+	atomic.StoreInt32(&elected, 0)
+	Update("push2", "push.example", glbRecord("10.0.5.2", Online))
+	assert.Nil(t, waitForUpdate())
+
+	// a node elected again pushes all records, also without changes while another node was elected
+	atomic.StoreInt32(&elected, 1)
+	assert.NotNil(t, waitForUpdate())
+	atomic.StoreInt32(&elected, 0)
+	time.Sleep(2 * pushElectionInterval)
+	atomic.StoreInt32(&elected, 1)
+	m = waitForUpdate()
+	if assert.NotNil(t, m) {
+		assert.Len(t, m.Ns, 3)
+	}
+}
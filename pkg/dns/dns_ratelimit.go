@@ -0,0 +1,241 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// RateLimit contains the settings of response rate limiting, which limits identical udp responses to a client network
+type RateLimit struct {
+	ResponsesPerSecond int      `toml:"responses_per_second" json:"responses_per_second"` // identical responses per second to a client network, 0 disables rate limiting
+	NXDomainsPerSecond int      `toml:"nxdomains_per_second" json:"nxdomains_per_second"` // NXDOMAIN responses per second for a zone to a client network
+	ErrorsPerSecond    int      `toml:"errors_per_second" json:"errors_per_second"`       // error responses per second to a client network
+	Window             int      `toml:"window" json:"window"`                             // seconds over which the rate is measured
+	Slip               int      `toml:"slip" json:"slip"`                                 // every nth limited response is send truncated, -1 drops them all
+	IPv4PrefixLength   int      `toml:"ipv4_prefix_length" json:"ipv4_prefix_length"`     // prefix length that groups ipv4 clients into a network
+	IPv6PrefixLength   int      `toml:"ipv6_prefix_length" json:"ipv6_prefix_length"`     // prefix length that groups ipv6 clients into a network
+	Exempt             []string `toml:"exempt" json:"exempt"`                             // networks (cidr) that are never rate limited
+}
+
+// RateLimitStats contains the counters of response rate limiting
+type RateLimitStats struct {
+	Enabled   bool  `json:"enabled"`
+	Clients   int   `json:"clients"` // amount of tracked client network and response combinations
+	Responses int64 `json:"responses"`
+	Dropped   int64 `json:"dropped"`
+	Slipped   int64 `json:"slipped"`
+}
+
+// rateLimitAction is what happens with a response after rate limiting
+type rateLimitAction int
+
+const (
+	rateLimitSend rateLimitAction = iota
+	rateLimitDrop
+	rateLimitSlip
+)
+
+// rateLimitAccount tracks the credit of a client network for a response
+type rateLimitAccount struct {
+	balance float64
+	updated time.Time
+	limited int
+}
+
+// rateLimiter holds the rate limit accounts of all client networks
+var rateLimiter = struct {
+	sync.Mutex
+	config    RateLimit
+	exempt    []*net.IPNet
+	accounts  map[string]*rateLimitAccount
+	pruned    time.Time
+	responses int64
+	dropped   int64
+	slipped   int64
+}{accounts: make(map[string]*rateLimitAccount)}
+
+// CheckRateLimit returns an error if the rate limit settings are invalid
+func CheckRateLimit(config RateLimit) error {
+	for _, cidr := range config.Exempt {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid rate limit exempt network cidr:%s error:%s", cidr, err)
+		}
+	}
+
+	if config.IPv4PrefixLength < 0 || config.IPv4PrefixLength > 32 {
+		return fmt.Errorf("Invalid rate limit ipv4 prefix length: %d", config.IPv4PrefixLength)
+	}
+
+	if config.IPv6PrefixLength < 0 || config.IPv6PrefixLength > 128 {
+		return fmt.Errorf("Invalid rate limit ipv6 prefix length: %d", config.IPv6PrefixLength)
+	}
+
+	return nil
+}
+
+// SetRateLimit sets the response rate limiting settings
+func SetRateLimit(config RateLimit) {
+	log := logging.For("dns/ratelimit/set")
+	var exempt []*net.IPNet
+	for _, cidr := range config.Exempt {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.WithField("cidr", cidr).WithField("error", err).Warn("Invalid rate limit exempt network")
+			continue
+		}
+
+		exempt = append(exempt, network)
+	}
+
+	rateLimiter.Lock()
+	defer rateLimiter.Unlock()
+	rateLimiter.config = config
+	rateLimiter.exempt = exempt
+	rateLimiter.accounts = make(map[string]*rateLimitAccount)
+}
+
+// RateLimitCounters returns the counters of response rate limiting
+func RateLimitCounters() RateLimitStats {
+	rateLimiter.Lock()
+	defer rateLimiter.Unlock()
+	return RateLimitStats{
+		Enabled:   rateLimiter.config.ResponsesPerSecond > 0,
+		Clients:   len(rateLimiter.accounts),
+		Responses: rateLimiter.responses,
+		Dropped:   rateLimiter.dropped,
+		Slipped:   rateLimiter.slipped,
+	}
+}
+
+// rateLimitKey returns the account key and rate of a response to a client network
+func rateLimitKey(network string, r *dnssrv.Msg, m *dnssrv.Msg) (string, int) {
+	config := rateLimiter.config
+	switch m.Rcode {
+	case dnssrv.RcodeSuccess:
+		if len(r.Question) == 0 {
+			return network + "/empty", config.ResponsesPerSecond
+		}
+
+		return network + "/" + strings.ToLower(r.Question[0].Name) + "/" + dnssrv.TypeToString[r.Question[0].Qtype], config.ResponsesPerSecond
+
+	case dnssrv.RcodeNameError:
+		// random subdomains of a zone count as the same response
+		zone := ""
+		for _, rr := range m.Ns {
+			if rr.Header().Rrtype == dnssrv.TypeSOA {
+				zone = strings.ToLower(rr.Header().Name)
+			}
+		}
+
+		if zone == "" && len(r.Question) > 0 {
+			zone = strings.ToLower(r.Question[0].Name)
+		}
+
+		return network + "/nxdomain/" + zone, config.NXDomainsPerSecond
+	}
+
+	return network + "/error", config.ErrorsPerSecond
+}
+
+// rateLimit decides if a udp response to a client is send, dropped, or send truncated so the client retries over TCP
+func rateLimit(clientIP net.IP, r *dnssrv.Msg, m *dnssrv.Msg) rateLimitAction {
+	rateLimiter.Lock()
+	defer rateLimiter.Unlock()
+	config := rateLimiter.config
+	if config.ResponsesPerSecond <= 0 || clientIP == nil {
+		return rateLimitSend
+	}
+
+	for _, network := range rateLimiter.exempt {
+		if network.Contains(clientIP) {
+			return rateLimitSend
+		}
+	}
+
+	rateLimiter.responses++
+	mask := net.CIDRMask(config.IPv6PrefixLength, 128)
+	if ip := clientIP.To4(); ip != nil {
+		clientIP = ip
+		mask = net.CIDRMask(config.IPv4PrefixLength, 32)
+	}
+
+	key, rate := rateLimitKey(clientIP.Mask(mask).String(), r, m)
+	if rate <= 0 {
+		return rateLimitSend
+	}
+
+	window := float64(config.Window)
+	if window < 1 {
+		window = 1
+	}
+
+	now := time.Now()
+	rateLimitPrune(now, window)
+	account, ok := rateLimiter.accounts[key]
+	if !ok {
+		account = &rateLimitAccount{balance: float64(rate), updated: now}
+		rateLimiter.accounts[key] = account
+	}
+
+	// credit is earned at the rate per second, up to one second of responses, and spent per response
+	account.balance += now.Sub(account.updated).Seconds() * float64(rate)
+	if account.balance > float64(rate) {
+		account.balance = float64(rate)
+	}
+
+	account.updated = now
+	account.balance--
+	if account.balance >= 0 {
+		account.limited = 0
+		return rateLimitSend
+	}
+
+	// a client that keeps sending stays limited for at most the window
+	if account.balance < -window*float64(rate) {
+		account.balance = -window * float64(rate)
+	}
+
+	account.limited++
+	if config.Slip > 0 && account.limited%config.Slip == 0 {
+		rateLimiter.slipped++
+		return rateLimitSlip
+	}
+
+	rateLimiter.dropped++
+	return rateLimitDrop
+}
+
+// rateLimitPrune removes accounts that have earned back their credit, the caller must hold the rate limiter lock
+func rateLimitPrune(now time.Time, window float64) {
+	if now.Sub(rateLimiter.pruned).Seconds() < window {
+		return
+	}
+
+	rateLimiter.pruned = now
+	for key, account := range rateLimiter.accounts {
+		if now.Sub(account.updated).Seconds() > window+1 {
+			delete(rateLimiter.accounts, key)
+		}
+	}
+}
+
+// rateLimitSlipReply strips a reply to an empty truncated response, which makes real clients retry over TCP
+func rateLimitSlipReply(m *dnssrv.Msg) {
+	m.Truncated = true
+	m.Answer = nil
+	m.Ns = nil
+	extra := m.Extra[:0]
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype == dnssrv.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+
+	m.Extra = extra
+}
@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	SetRateLimit(RateLimit{ResponsesPerSecond: 2, NXDomainsPerSecond: 1, ErrorsPerSecond: 1, Window: 15, Slip: 2, IPv4PrefixLength: 24, IPv6PrefixLength: 56, Exempt: []string{"10.0.0.0/8"}})
+	defer SetRateLimit(RateLimit{})
+
+	query := func(name string, rcode int) (*dnssrv.Msg, *dnssrv.Msg) {
+		r := new(dnssrv.Msg)
+		r.SetQuestion(name, dnssrv.TypeA)
+		m := new(dnssrv.Msg)
+		m.SetRcode(r, rcode)
+		return r, m
+	}
+
+	// identical responses are limited per client network, every second limited response slips
+	client := net.ParseIP("192.0.2.1")
+	r, m := query("www.example.com.", dnssrv.RcodeSuccess)
+	assert.Equal(t, rateLimitSend, rateLimit(client, r, m))
+	assert.Equal(t, rateLimitSend, rateLimit(net.ParseIP("192.0.2.2"), r, m))
+	assert.Equal(t, rateLimitDrop, rateLimit(client, r, m))
+	assert.Equal(t, rateLimitSlip, rateLimit(client, r, m))
+	assert.Equal(t, rateLimitDrop, rateLimit(client, r, m))
+
+	// other responses and networks have their own limit
+	r2, m2 := query("mail.example.com.", dnssrv.RcodeSuccess)
+	assert.Equal(t, rateLimitSend, rateLimit(client, r2, m2))
+	assert.Equal(t, rateLimitSend, rateLimit(net.ParseIP("192.0.3.1"), r, m))
+
+	// NXDOMAIN responses of a zone share a lower limit
+	soa, _ := dnssrv.NewRR("example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 30")
+	r3, m3 := query("a.example.com.", dnssrv.RcodeNameError)
+	m3.Ns = []dnssrv.RR{soa}
+	assert.Equal(t, rateLimitSend, rateLimit(client, r3, m3))
+	r3, m3 = query("b.example.com.", dnssrv.RcodeNameError)
+	m3.Ns = []dnssrv.RR{soa}
+	assert.Equal(t, rateLimitDrop, rateLimit(client, r3, m3))
+
+	// errors have their own limit
+	r4, m4 := query("c.example.com.", dnssrv.RcodeServerFailure)
+	assert.Equal(t, rateLimitSend, rateLimit(client, r4, m4))
+	assert.Equal(t, rateLimitDrop, rateLimit(client, r4, m4))
+
+	// exempt networks are never limited
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, rateLimitSend, rateLimit(net.ParseIP("10.1.2.3"), r, m))
+	}
+
+	counters := RateLimitCounters()
+	assert.True(t, counters.Enabled)
+	assert.Equal(t, int64(11), counters.Responses)
+	assert.Equal(t, int64(4), counters.Dropped)
+	assert.Equal(t, int64(1), counters.Slipped)
+
+	// slipped replies are empty and truncated
+	rr, _ := dnssrv.NewRR("www.example.com. 60 IN A 127.0.0.1")
+	m.Answer = []dnssrv.RR{rr}
+	m.SetEdns0(dnssrv.DefaultMsgSize, false)
+	rateLimitSlipReply(m)
+	assert.True(t, m.Truncated)
+	assert.Empty(t, m.Answer)
+	assert.NotNil(t, m.IsEdns0())
+}
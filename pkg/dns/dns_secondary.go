@@ -0,0 +1,346 @@
+package dns
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/balancer"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// Secondary contains the settings of a domain that is transferred from a primary nameserver
+type Secondary struct {
+	Primaries []string `toml:"primaries" json:"primaries"` // primary nameservers (ip:port) to transfer the domain from, in order of preference
+	TSIGKey   string   `toml:"tsig_key" json:"tsig_key"`   // name of the tsig key to sign the transfer requests with
+}
+
+// secondaryTimeout is the timeout of requests to a primary
+const secondaryTimeout = 10 * time.Second
+
+// minimumRefresh is the shortest time between SOA checks, regardless of the SOA refresh timer
+const minimumRefresh = 10 * time.Second
+
+// secondaryZone keeps a domain in sync with its primary
+type secondaryZone struct {
+	domain  string
+	config  Secondary
+	serial  uint32
+	loaded  bool
+	refresh chan bool
+	quit    chan bool
+}
+
+// secondaries holds the running secondary zones
+var secondaries = struct {
+	sync.Mutex
+	zones map[string]*secondaryZone
+}{zones: make(map[string]*secondaryZone)}
+
+// UpdateSecondaries starts transferring new secondary domains, and stops the ones no longer configured
+func UpdateSecondaries(domains map[string]Domain) {
+	log := logging.For("dns/secondary/update")
+	secondaries.Lock()
+	defer secondaries.Unlock()
+
+	for domainName, zone := range secondaries.zones {
+		if domain, ok := domains[domainName]; !ok || !reflect.DeepEqual(domain.Secondary, zone.config) {
+			log.WithField("domain", domainName).Info("Stopping secondary domain")
+			close(zone.quit)
+			delete(secondaries.zones, domainName)
+			if !ok || len(domain.Secondary.Primaries) == 0 {
+				removeLocalDomain(domainName)
+			}
+		}
+	}
+
+	for domainName, domain := range domains {
+		if len(domain.Secondary.Primaries) == 0 {
+			continue
+		}
+
+		if _, ok := secondaries.zones[domainName]; ok {
+			continue
+		}
+
+		zone := &secondaryZone{
+			domain:  strings.ToLower(domainName),
+			config:  domain.Secondary,
+			refresh: make(chan bool, 1),
+			quit:    make(chan bool),
+		}
+
+		log.WithField("domain", domainName).WithField("primaries", domain.Secondary.Primaries).Info("Starting secondary domain")
+		secondaries.zones[domainName] = zone
+		go zone.refreshHandler()
+	}
+}
+
+// getSecondary returns the secondary zone of a domain, or nil if the domain is not a secondary
+func getSecondary(domainName string) *secondaryZone {
+	secondaries.Lock()
+	defer secondaries.Unlock()
+	for name, zone := range secondaries.zones {
+		if strings.EqualFold(name, strings.TrimSuffix(domainName, ".")) {
+			return zone
+		}
+	}
+
+	return nil
+}
+
+// refreshHandler transfers the zone when the SOA refresh timer expires or a NOTIFY is received
+func (z *secondaryZone) refreshHandler() {
+	log := logging.For("dns/secondary/refresh").WithField("domain", z.domain)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-z.quit:
+			return
+		case <-z.refresh:
+			log.Debug("Refresh requested by NOTIFY")
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timer.C:
+		}
+
+		next, err := z.update()
+		if err != nil {
+			// keep serving the last good copy, and retry
+			log.WithField("error", err).WithField("retry", next).WithField("loaded", z.loaded).Warn("Failed to transfer secondary domain")
+		}
+
+		timer.Reset(next)
+	}
+}
+
+// update transfers the zone if the serial of the primary changed, and returns the time until the next refresh
+func (z *secondaryZone) update() (time.Duration, error) {
+	log := logging.For("dns/secondary/update").WithField("domain", z.domain)
+	retry := 60 * time.Second
+	var lastErr error
+
+	for _, primary := range z.config.Primaries {
+		if _, _, err := net.SplitHostPort(primary); err != nil {
+			primary = net.JoinHostPort(primary, "53")
+		}
+
+		soa, err := z.querySOA(primary)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		refresh := time.Duration(soa.Refresh) * time.Second
+		if refresh < minimumRefresh {
+			refresh = minimumRefresh
+		}
+
+		if soa.Retry > 0 {
+			retry = time.Duration(soa.Retry) * time.Second
+		}
+
+		if z.loaded && !serialNewer(soa.Serial, z.serial) {
+			log.WithField("primary", primary).WithField("serial", soa.Serial).Debug("Secondary domain is up to date")
+			return refresh, nil
+		}
+
+		records, serial, err := z.transfer(primary)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if !z.replace(records) {
+			log.WithField("primary", primary).WithField("serial", serial).Debug("Secondary domain was stopped, discarding transfer")
+			return refresh, nil
+		}
+
+		z.serial = serial
+		z.loaded = true
+		log.WithField("primary", primary).WithField("serial", serial).WithField("records", len(records)).Info("Transferred secondary domain")
+		return refresh, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("No primaries configured")
+	}
+
+	return retry, lastErr
+}
+
+// replace serves the transferred records of the domain, unless the zone was stopped during the transfer
+// this is done while holding the secondaries lock, so a removed domain is not added again
+func (z *secondaryZone) replace(records []Record) bool {
+	secondaries.Lock()
+	defer secondaries.Unlock()
+	select {
+	case <-z.quit:
+		return false
+	default:
+	}
+
+	replaceLocalDomain(z.domain, records)
+	return true
+}
+
+// client returns a dns client for requests to the primary, and signs the request if a tsig key is set
+func (z *secondaryZone) client(m *dnssrv.Msg) *dnssrv.Client {
+	c := &dnssrv.Client{Net: "tcp", Timeout: secondaryTimeout}
+	if z.config.TSIGKey != "" {
+		c.TsigSecret = tsigSecrets()
+		m.SetTsig(dnssrv.Fqdn(strings.ToLower(z.config.TSIGKey)), tsigAlgorithm(z.config.TSIGKey), 300, time.Now().Unix())
+	}
+
+	return c
+}
+
+// querySOA requests the SOA record of the zone from a primary
+func (z *secondaryZone) querySOA(primary string) (*dnssrv.SOA, error) {
+	m := new(dnssrv.Msg)
+	m.SetQuestion(dnssrv.Fqdn(z.domain), dnssrv.TypeSOA)
+	reply, _, err := z.client(m).Exchange(m, primary)
+	if err != nil {
+		return nil, fmt.Errorf("SOA request to %s failed: %s", primary, err)
+	}
+
+	if reply.Rcode != dnssrv.RcodeSuccess {
+		return nil, fmt.Errorf("SOA request to %s failed: %s", primary, dnssrv.RcodeToString[reply.Rcode])
+	}
+
+	for _, rr := range reply.Answer {
+		if soa, ok := rr.(*dnssrv.SOA); ok {
+			return soa, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No SOA record received from %s", primary)
+}
+
+// transfer requests the full zone from a primary
+func (z *secondaryZone) transfer(primary string) ([]Record, uint32, error) {
+	m := new(dnssrv.Msg)
+	m.SetAxfr(dnssrv.Fqdn(z.domain))
+	c := z.client(m)
+
+	tr := &dnssrv.Transfer{TsigSecret: c.TsigSecret, DialTimeout: secondaryTimeout, ReadTimeout: secondaryTimeout}
+	env, err := tr.In(m, primary)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Zone transfer from %s failed: %s", primary, err)
+	}
+
+	var rrs []dnssrv.RR
+	for e := range env {
+		if e.Error != nil {
+			return nil, 0, fmt.Errorf("Zone transfer from %s failed: %s", primary, e.Error)
+		}
+
+		rrs = append(rrs, e.RR...)
+	}
+
+	if len(rrs) < 2 || rrs[0].Header().Rrtype != dnssrv.TypeSOA || rrs[len(rrs)-1].Header().Rrtype != dnssrv.TypeSOA {
+		return nil, 0, fmt.Errorf("Incomplete zone transfer from %s", primary)
+	}
+
+	serial := rrs[0].(*dnssrv.SOA).Serial
+	var records []Record
+	for _, rr := range rrs[:len(rrs)-1] {
+		if record, ok := rrToRecord(z.domain, rr); ok {
+			records = append(records, record)
+		}
+	}
+
+	return records, serial, nil
+}
+
+// rrToRecord converts a resource record of a zone to a local record
+func rrToRecord(domainName string, rr dnssrv.RR) (Record, bool) {
+	h := rr.Header()
+	zone := dnssrv.Fqdn(strings.ToLower(domainName))
+	owner := strings.ToLower(h.Name)
+	if !dnssrv.IsSubDomain(zone, owner) || dnssrv.TypeToString[h.Rrtype] == "" {
+		return Record{}, false
+	}
+
+	name := strings.TrimSuffix(strings.TrimSuffix(owner, zone), ".")
+	target := strings.TrimSpace(strings.TrimPrefix(rr.String(), h.String()))
+	hash := sha256.New()
+	hash.Write([]byte(fmt.Sprintf("%s-%s-%x-%s", domainName, name, dnssrv.TypeToString[h.Rrtype], target)))
+	uuid := fmt.Sprintf("%x", hash.Sum(nil))
+
+	return Record{
+		Name:       name,
+		Type:       dnssrv.TypeToString[h.Rrtype],
+		Target:     target,
+		TTL:        int(h.Ttl),
+		Status:     Online,
+		Local:      true,
+		UUID:       uuid,
+		Statistics: balancer.NewStatistics(uuid, 0),
+	}, true
+}
+
+// handleNotify processes a NOTIFY (RFC 1996) from a primary of a secondary domain
+func handleNotify(w dnssrv.ResponseWriter, r *dnssrv.Msg, m *dnssrv.Msg) {
+	clientIP := remoteIP(w.RemoteAddr())
+	log := logging.For("dns/secondary/notify").WithField("client", clientIP)
+	if len(r.Question) != 1 || r.Question[0].Qtype != dnssrv.TypeSOA {
+		m.SetRcode(r, dnssrv.RcodeFormatError)
+		return
+	}
+
+	zone := getSecondary(r.Question[0].Name)
+	if zone == nil {
+		log.WithField("domain", r.Question[0].Name).Warn("NOTIFY received for unknown secondary domain")
+		m.SetRcode(r, dnssrv.RcodeNotAuth)
+		return
+	}
+
+	log = log.WithField("domain", zone.domain)
+	if zone.config.TSIGKey != "" {
+		if !tsigValid(w, r, zone.config.TSIGKey) {
+			log.Warn("NOTIFY received without valid TSIG signature")
+			m.SetRcode(r, dnssrv.RcodeNotAuth)
+			return
+		}
+	} else if !zone.isPrimary(clientIP) {
+		log.Warn("NOTIFY received from unknown primary")
+		m.SetRcode(r, dnssrv.RcodeRefused)
+		return
+	}
+
+	log.Info("NOTIFY received for secondary domain")
+	select {
+	case zone.refresh <- true:
+	default:
+	}
+
+	m.Authoritative = true
+}
+
+// isPrimary returns true if the ip is one of the primaries of the zone
+func (z *secondaryZone) isPrimary(ip net.IP) bool {
+	for _, primary := range z.config.Primaries {
+		host, _, err := net.SplitHostPort(primary)
+		if err != nil {
+			host = primary
+		}
+
+		if p := net.ParseIP(host); p != nil && p.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPrimary serves a zone with a serial that can be changed by the test
+type testPrimary struct {
+	serial uint32
+}
+
+func (p *testPrimary) zone() []dnssrv.RR {
+	serial := atomic.LoadUint32(&p.serial)
+	soa, _ := dnssrv.NewRR(fmt.Sprintf("sec.example. 300 SOA ns1.sec.example. hostmaster.sec.example. %d 3600 600 86400 300", serial))
+	a, _ := dnssrv.NewRR(fmt.Sprintf("www.sec.example. 60 A 127.0.3.%d", serial))
+	mx, _ := dnssrv.NewRR("sec.example. 300 MX 10 mail.sec.example.")
+	return []dnssrv.RR{soa, a, mx, soa}
+}
+
+func (p *testPrimary) ServeDNS(w dnssrv.ResponseWriter, r *dnssrv.Msg) {
+	m := new(dnssrv.Msg)
+	m.SetReply(r)
+	if r.IsTsig() == nil || w.TsigStatus() != nil {
+		m.SetRcode(r, dnssrv.RcodeNotAuth)
+		w.WriteMsg(m)
+		return
+	}
+
+	tsig := r.IsTsig()
+	switch r.Question[0].Qtype {
+	case dnssrv.TypeAXFR:
+		ch := make(chan *dnssrv.Envelope)
+		tr := new(dnssrv.Transfer)
+		go func() {
+			ch <- &dnssrv.Envelope{RR: p.zone()}
+			close(ch)
+		}()
+		tr.Out(w, r, ch)
+		return
+	case dnssrv.TypeSOA:
+		m.Answer = p.zone()[:1]
+	}
+
+	m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	w.WriteMsg(m)
+}
+
+func TestSecondary(t *testing.T) {
+	logging.Configure("stdout", "error")
+	secret := "c2Vjb25kYXJ5LXNlY3JldA=="
+	SetTSIGKeys(map[string]TSIGKey{"sec-key": {Secret: secret}})
+	defer SetTSIGKeys(map[string]TSIGKey{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	primary := &testPrimary{serial: 1}
+	server := &dnssrv.Server{Listener: listener, Net: "tcp", TsigSecret: map[string]string{"sec-key.": secret}, Handler: primary}
+	go server.ActivateAndServe()
+
+	domains := map[string]Domain{"sec.example": {Secondary: Secondary{Primaries: []string{listener.Addr().String()}, TSIGKey: "sec-key"}}}
+	UpdateSecondaries(domains)
+	defer UpdateSecondaries(map[string]Domain{})
+
+	waitForTarget := func(target string) bool {
+		for i := 0; i < 50; i++ {
+			m := new(dnssrv.Msg)
+			m.SetQuestion("www.sec.example.", dnssrv.TypeA)
+			parseQuery(m, "127.0.0.1:12345")
+			if answerTarget(m, target) {
+				return true
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return false
+	}
+
+	// initial transfer
+	assert.True(t, waitForTarget("127.0.3.1"))
+	assert.True(t, localZone("", "sec.example"))
+
+	// NOTIFY from the primary triggers a refresh
+	addr, stop := startTestServer(t, map[string]string{"sec-key.": secret})
+	defer stop()
+
+	atomic.StoreUint32(&primary.serial, 2)
+	notify := new(dnssrv.Msg)
+	notify.SetNotify("sec.example.")
+	c := &dnssrv.Client{Net: "tcp", TsigSecret: map[string]string{"sec-key.": secret}}
+	reply, _, err := c.Exchange(notify, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, dnssrv.RcodeNotAuth, reply.Rcode)
+
+	notify.SetTsig("sec-key.", dnssrv.HmacSHA256, 300, time.Now().Unix())
+	reply, _, err = c.Exchange(notify, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, dnssrv.RcodeSuccess, reply.Rcode)
+	assert.True(t, waitForTarget("127.0.3.2"))
+
+	// the last good copy is served when the primary is unreachable
+	server.Shutdown()
+	getSecondary("sec.example").refresh <- true
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, waitForTarget("127.0.3.2"))
+
+	// a transfer that finishes after the domain was removed does not add it again
+	zone := getSecondary("sec.example")
+	UpdateSecondaries(map[string]Domain{})
+	assert.False(t, localZone("", "sec.example"))
+	assert.False(t, zone.replace([]Record{{Name: "www", Type: "A", Target: "127.0.3.3", TTL: 60, Status: Online, Local: true}}))
+	assert.False(t, localZone("", "sec.example"))
+}
+
+func TestRRToRecord(t *testing.T) {
+	rr, _ := dnssrv.NewRR("www.Example.com. 60 IN A 127.0.0.1")
+	record, ok := rrToRecord("example.com", rr)
+	assert.True(t, ok)
+	assert.Equal(t, "www", record.Name)
+	assert.Equal(t, "A", record.Type)
+	assert.Equal(t, "127.0.0.1", record.Target)
+	assert.Equal(t, 60, record.TTL)
+
+	rr, _ = dnssrv.NewRR("example.com. 60 IN MX 10 mail.example.com.")
+	record, ok = rrToRecord("example.com", rr)
+	assert.True(t, ok)
+	assert.Equal(t, "", record.Name)
+	assert.Equal(t, "10 mail.example.com.", record.Target)
+
+	rr, _ = dnssrv.NewRR("www.example.org. 60 IN A 127.0.0.1")
+	_, ok = rrToRecord("example.com", rr)
+	assert.False(t, ok)
+}
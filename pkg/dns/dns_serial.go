@@ -0,0 +1,200 @@
+package dns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// ZoneSerial is the serial of a zone, and the hash of the records it was given to
+type ZoneSerial struct {
+	Domain string `json:"domain"`
+	Serial uint32 `json:"serial"`
+	Hash   string `json:"hash"`
+}
+
+// serialInterval is how often zones are checked for changes of their records
+const serialInterval = 5 * time.Second
+
+// serials holds the newest serial known of each zone, of this node or of the cluster
+var serials = struct {
+	sync.Mutex
+	once    sync.Once
+	known   map[string]ZoneSerial
+	store   string
+	changes chan ZoneSerial
+}{
+	known:   make(map[string]ZoneSerial),
+	changes: make(chan ZoneSerial, 100),
+}
+
+// SerialChanges returns the channel on which new serials of this node are send, to be shared with the cluster
+func SerialChanges() <-chan ZoneSerial {
+	return serials.changes
+}
+
+// SetSerialStore sets the file zone serials are persisted in, loads the serials stored in it, and starts tracking zone changes
+func SetSerialStore(file string) {
+	serials.once.Do(func() {
+		go serialHandler()
+	})
+
+	log := logging.For("dns/serial/store").WithField("file", file)
+	serials.Lock()
+	defer serials.Unlock()
+	if serials.store == file {
+		return
+	}
+
+	serials.store = file
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return
+	}
+
+	if err != nil {
+		log.WithField("error", err).Warn("Unable to read zone serials")
+		return
+	}
+
+	var zones []ZoneSerial
+	if err := json.Unmarshal(data, &zones); err != nil {
+		log.WithField("error", err).Warn("Unable to parse zone serials")
+		return
+	}
+
+	for _, zone := range zones {
+		if existing, ok := serials.known[zone.Domain]; !ok || serialNewer(zone.Serial, existing.Serial) {
+			serials.known[zone.Domain] = zone
+		}
+	}
+
+	log.WithField("domains", len(zones)).Info("Loaded zone serials")
+}
+
+// ZoneSerials returns the newest serial known of all zones
+func ZoneSerials() []ZoneSerial {
+	serials.Lock()
+	defer serials.Unlock()
+	var zones []ZoneSerial
+	for _, zone := range serials.known {
+		zones = append(zones, zone)
+	}
+
+	return zones
+}
+
+// ApplyZoneSerial applies a serial received from the cluster if it is newer then the one we know
+// nodes serving the same records take over the serial, so all nodes agree on it
+func ApplyZoneSerial(zone ZoneSerial) bool {
+	zone.Domain = strings.ToLower(zone.Domain)
+	serials.Lock()
+	if existing, ok := serials.known[zone.Domain]; ok && !serialNewer(zone.Serial, existing.Serial) {
+		serials.Unlock()
+		return false
+	}
+
+	logging.For("dns/serial/apply").WithField("domain", zone.Domain).WithField("serial", zone.Serial).Debug("Applying zone serial from cluster")
+	serials.known[zone.Domain] = zone
+	persistSerials()
+	serials.Unlock()
+
+	zoneVersions.Lock()
+	defer zoneVersions.Unlock()
+	versions := zoneVersions.zones[zone.Domain]
+	if len(versions) > 0 && versions[len(versions)-1].hash == zone.Hash {
+		current := versions[len(versions)-1]
+		current.serial = zone.Serial
+		addZoneVersion(zone.Domain, current)
+	}
+
+	return true
+}
+
+// nextSerial returns the serial for new records of a zone: the serial the cluster gave the same records, or one higher then the newest serial known
+func nextSerial(domainName, hash string) uint32 {
+	log := logging.For("dns/serial/next").WithField("domain", domainName)
+	serials.Lock()
+	defer serials.Unlock()
+	known, ok := serials.known[domainName]
+	if ok && known.Hash == hash {
+		return known.Serial
+	}
+
+	// zones without history start at the current time, like serials were before they were tracked
+	serial := uint32(time.Now().Unix())
+	if ok {
+		serial = known.Serial + 1
+	}
+
+	zone := ZoneSerial{Domain: domainName, Serial: serial, Hash: hash}
+	serials.known[domainName] = zone
+	persistSerials()
+	log.WithField("serial", serial).Info("Zone records changed, increasing serial")
+
+	select {
+	case serials.changes <- zone:
+	default:
+		log.Warn("Zone serial queue is full, serial is not shared with the cluster")
+	}
+
+	return serial
+}
+
+// serialHandler checks all zones for changes of their records, so their serial increases without waiting for a query
+func serialHandler() {
+	ticker := time.NewTicker(serialInterval)
+	defer ticker.Stop()
+	for {
+		for domainName, d := range currentIndex().domains {
+			if len(d.records[indexKey{name: "", rtype: "SOA"}]) > 0 {
+				zoneSerial(domainName)
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+// persistSerials writes the serials to the store, the caller must hold the serials lock
+func persistSerials() {
+	if serials.store == "" {
+		return
+	}
+
+	log := logging.For("dns/serial/persist").WithField("file", serials.store)
+	var zones []ZoneSerial
+	for _, zone := range serials.known {
+		zones = append(zones, zone)
+	}
+
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Domain < zones[j].Domain })
+	data, err := json.MarshalIndent(zones, "", "  ")
+	if err != nil {
+		log.WithField("error", err).Warn("Unable to encode zone serials")
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(serials.store), 0750); err != nil {
+		log.WithField("error", err).Warn("Unable to create directory for zone serials")
+		return
+	}
+
+	// write to a temporary file first, so a crash never leaves a partial store behind
+	tmp := serials.store + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		log.WithField("error", err).Warn("Unable to write zone serials")
+		return
+	}
+
+	if err := os.Rename(tmp, serials.store); err != nil {
+		log.WithField("error", err).Warn("Unable to write zone serials")
+	}
+}
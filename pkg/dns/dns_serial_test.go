@@ -0,0 +1,77 @@
+package dns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+const serialDomain = "serial.example"
+
+var testRecordsSerial = []Record{
+	{UUID: "s-soa", Name: "", Type: "SOA", Target: "ns1.serial.example. hostmaster.serial.example. ###SERIAL### 3600 600 86400 300", TTL: 3600, Status: Online, Local: true},
+	{UUID: "s-www", Name: "www", Type: "A", Target: "127.0.10.1", TTL: 60, Status: Online, Local: true},
+}
+
+func TestZoneSerial(t *testing.T) {
+	logging.Configure("stdout", "error")
+	dir, err := ioutil.TempDir("", "serial")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	SetSerialStore(filepath.Join(dir, "serials.json"))
+	defer SetSerialStore("")
+	loadRecords("localdns", serialDomain, testRecordsSerial)
+	loadRecords("serialnode", serialDomain, []Record{{UUID: "s-glb", Name: "glb", Type: "A", Target: "127.0.10.2", TTL: 30, Status: Online}})
+	loadRecords("serialnode2", serialDomain, []Record{{UUID: "s-glb2", Name: "glb", Type: "A", Target: "127.0.10.3", TTL: 30, Status: Online}})
+	defer Discard("serialnode")
+
+	// only serials of this test are expected on the changes
+	for len(serials.changes) > 0 {
+		<-serials.changes
+	}
+
+	// the serial only increases when the records change, status changes of GLB records included
+	serial := zoneSerial(serialDomain)
+	assert.Equal(t, serial, zoneSerial(serialDomain))
+	assert.Equal(t, serial, (<-SerialChanges()).Serial)
+	MarkOffline("serialnode")
+	// queries serve the last known serial without recalculating it, the serial handler picks up the change
+	assert.Equal(t, serial, currentZoneSerial(serialDomain))
+	flipped := zoneSerial(serialDomain)
+	assert.Equal(t, serial+1, flipped)
+	assert.Equal(t, flipped, currentZoneSerial(serialDomain))
+	change := <-SerialChanges()
+	assert.Equal(t, flipped, change.Serial)
+
+	// the serial is kept after a restart
+	resetSerials := func() {
+		serials.Lock()
+		serials.known = make(map[string]ZoneSerial)
+		serials.store = ""
+		serials.Unlock()
+		zoneVersions.Lock()
+		delete(zoneVersions.zones, serialDomain)
+		zoneVersions.Unlock()
+	}
+
+	resetSerials()
+	SetSerialStore(filepath.Join(dir, "serials.json"))
+	assert.Equal(t, flipped, zoneSerial(serialDomain))
+
+	// a newer serial of the cluster for the same records is taken over, older serials are ignored
+	hash := change.Hash
+	assert.False(t, ApplyZoneSerial(ZoneSerial{Domain: serialDomain, Serial: flipped - 1, Hash: hash}))
+	assert.True(t, ApplyZoneSerial(ZoneSerial{Domain: serialDomain, Serial: flipped + 5, Hash: hash}))
+	assert.Equal(t, flipped+5, zoneSerial(serialDomain))
+
+	// records changing after the cluster moved on get a serial above the one of the cluster
+	assert.True(t, ApplyZoneSerial(ZoneSerial{Domain: serialDomain, Serial: flipped + 10, Hash: "other"}))
+	assert.Equal(t, flipped+5, zoneSerial(serialDomain))
+	Discard("serialnode2")
+	assert.Equal(t, flipped+11, zoneSerial(serialDomain))
+}
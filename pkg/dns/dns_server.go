@@ -1,12 +1,13 @@
 package dns
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"reflect"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -15,6 +16,7 @@ import (
 	"github.com/rdoorn/tinyresolver"
 	"github.com/schubergphilis/mercury/pkg/balancer"
 	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/sirupsen/logrus"
 
 	dnssrv "github.com/miekg/dns"
 )
@@ -26,8 +28,14 @@ type Domains struct {
 
 // Domain is a dns domain
 type Domain struct {
-	Records []Record `toml:"records" json:"records"`
-	TTL     int      `json:"ttl"`
+	Records   []Record      `toml:"records" json:"records"`
+	ZoneFile  string        `toml:"zone_file" json:"zone_file"`
+	TTL       int           `json:"ttl"`
+	DNSSEC    DNSSEC        `toml:"dnssec" json:"dnssec"`
+	Transfer  Transfer      `toml:"transfer" json:"transfer"`
+	Secondary Secondary     `toml:"secondary" json:"secondary"`
+	Update    DynamicUpdate `toml:"update" json:"update"`
+	Push      Push          `toml:"push" json:"push"`
 }
 
 // Record of any type
@@ -44,15 +52,32 @@ type Record struct {
 	Status              Status               `toml:"status" json:"status"`                               // is record online (do we serve it)
 	Local               bool                 `toml:"local" json:"local"`                                 // true if record is of the local dns server
 	UUID                string               `toml:"uuid" json:"uuid"`                                   // links record to check that added it,usefull for removing dead checks
+	DegradedTTL         int                  `toml:"degraded_ttl" json:"degraded_ttl"`                   // time to live while the record set is degraded
+	DegradedWindow      int                  `toml:"degraded_window" json:"degraded_window"`             // seconds after a status change that the record set is degraded
+	MaxAnswers          int                  `toml:"max_answers" json:"max_answers"`                     // maximum amount of A/AAAA answers after balancing (0 is all)
+	OfflineFallback     string               `toml:"offline_fallback" json:"offline_fallback"`           // reply if all records are offline: offline, nxdomain, servfail or static
+	FallbackTarget      string               `toml:"fallback_target" json:"fallback_target"`             // target of the static offline fallback
+	StatusChanged       time.Time            `toml:"-" json:"status_changed"`                            // time of the last status change
+	ActiveTTL           int                  `toml:"-" json:"active_ttl,omitempty"`                      // time to live currently served, only set by GetCacheWithTTL
 }
 
 // Config has the dns config
 type Config struct {
-	Domains         map[string]Domain `toml:"domains" json:"domains"`
-	Binding         string            `toml:"binding" json:"binding"`
-	AllowForwarding []string          `toml:"allow_forwarding" json:"allow_forwarding"`
-	Port            int               `toml:"port" json:"port"`
-	AllowedRequests []string          `toml:"allowed_requests" json:"allowed_requests"`
+	Domains         map[string]Domain      `toml:"domains" json:"domains"`
+	Binding         string                 `toml:"binding" json:"binding"`
+	AllowForwarding []string               `toml:"allow_forwarding" json:"allow_forwarding"`
+	Port            int                    `toml:"port" json:"port"`
+	AllowedRequests []string               `toml:"allowed_requests" json:"allowed_requests"`
+	ECSTrusted      []string               `toml:"ecs_trusted_resolvers" json:"ecs_trusted_resolvers"`
+	TSIGKeys        map[string]TSIGKey     `toml:"tsig_keys" json:"tsig_keys"`
+	DynamicStore    string                 `toml:"dynamic_store" json:"dynamic_store"`
+	SerialStore     string                 `toml:"serial_store" json:"serial_store"`
+	ForwardCache    ForwardCache           `toml:"forward_cache" json:"forward_cache"`
+	DoT             DoT                    `toml:"dot" json:"dot"`
+	DoH             DoH                    `toml:"doh" json:"doh"`
+	RateLimit       RateLimit              `toml:"rate_limit" json:"rate_limit"`
+	ForwardZones    map[string]ForwardZone `toml:"forward_zones" json:"forward_zones"`
+	Views           map[string]View        `toml:"views" json:"views"`
 }
 
 // reverse an array of strings
@@ -67,37 +92,41 @@ func reverse(s []string) []string {
 var dnsmanager = struct {
 	sync.RWMutex
 	node            map[string]Domains
-	stop            chan bool
+	stop            chan chan bool
 	AllowedRequests []string
 	proxyStats      bool
 	TCPServer       *dnssrv.Server
 	UDPServer       *dnssrv.Server
 	Resolver        *tinyresolver.Resolver
 	AllowForwarding []*net.IPNet
-}{node: make(map[string]Domains), stop: make(chan bool, 1), AllowedRequests: []string{}, proxyStats: false, TCPServer: &dnssrv.Server{}, UDPServer: &dnssrv.Server{}, Resolver: tinyresolver.New()}
+	ECSTrusted      []*net.IPNet
+	signers         map[string]*zoneSigner
+	tsigKeys        map[string]TSIGKey
+	transfers       map[string]Transfer
+}{node: make(map[string]Domains), stop: make(chan chan bool), AllowedRequests: []string{}, proxyStats: false, TCPServer: &dnssrv.Server{}, UDPServer: &dnssrv.Server{}, Resolver: tinyresolver.New(), signers: make(map[string]*zoneSigner)}
 
 // Updates the counter of an dns record which was requested
 func updateCounter(domain string, record Record) {
-	dnsmanager.Lock()
-	defer dnsmanager.Unlock()
-
-	for nodename := range dnsmanager.node {
-		if _, ok := dnsmanager.node[nodename].Domains[domain]; ok {
-			// if records exist
-			for id, rec := range dnsmanager.node[nodename].Domains[domain].Records {
-				if rec.UUID == record.UUID {
-					//dnsmanager.node[nodename].Domains[domain].Records[id].Statistics.ClientsConnected++
-					dnsmanager.node[nodename].Domains[domain].Records[id].Statistics.ClientsConnectedAdd(1)
-				}
-			}
-		}
+	d := currentIndex().domain(domain)
+	if d == nil {
+		return
+	}
 
+	for _, statistics := range d.statistics[record.UUID] {
+		statistics.ClientsConnectedAdd(1)
 	}
 }
 
 // resetCounters resets the query counters of a specific fqdn
 func resetCounters(hostname, domain, request string) {
+	dnsmanager.RLock()
+	nodenames := make([]string, 0, len(dnsmanager.node))
 	for nodename := range dnsmanager.node {
+		nodenames = append(nodenames, nodename)
+	}
+	dnsmanager.RUnlock()
+
+	for _, nodename := range nodenames {
 		resetCounter(nodename, hostname, domain, request)
 	}
 }
@@ -165,7 +194,7 @@ func GetRecordsByStats(rec []Record, stat []balancer.Statistics) ([]Record, erro
 func getDNSStats(n []Record) []balancer.Statistics {
 	var s []balancer.Statistics
 	for _, record := range n {
-		s = append(s, *record.Statistics)
+		s = append(s, record.Statistics.Copy())
 	}
 
 	return s
@@ -178,7 +207,8 @@ func getAllowedRequests() []string {
 	return dnsmanager.AllowedRequests
 }
 
-func getRecordsByType(hostName string, domainName string, queryType uint16) (records []Record) {
+// getRecordsByType returns the records of a name and type served in a view
+func getRecordsByType(view, hostName string, domainName string, queryType uint16) (records []Record) {
 	// Get all related records
 	isAllowed := false
 	allowedRequests := getAllowedRequests()
@@ -194,13 +224,20 @@ func getRecordsByType(hostName string, domainName string, queryType uint16) (rec
 		return []Record{}
 	}
 
+	// names without records of their own are answered by the closest wildcard
+	searchName := hostName
+	wildcard, isWildcard := wildcardName(view, hostName, domainName)
+	if isWildcard {
+		searchName = wildcard
+	}
+
 	switch queryType {
 	case dnssrv.TypeANY:
 		// loop through available record types
 		// 258 is last record used https://github.com/miekg/dns/blob/767422ac12884e2baed0afd7303cf06cff90fef6/types.go#L94
 		for i := uint16(0); i <= 258; i++ {
 			if dnssrv.TypeToString[i] != "" {
-				anyR := getAllRecords(hostName, domainName, dnssrv.TypeToString[i])
+				anyR := getAllRecords(view, searchName, domainName, dnssrv.TypeToString[i])
 				for _, r := range anyR {
 					records = append(records, r)
 				}
@@ -209,8 +246,16 @@ func getRecordsByType(hostName string, domainName string, queryType uint16) (rec
 
 	default:
 		// get specified record
-		records = getAllRecords(hostName, domainName, dnssrv.TypeToString[queryType])
+		records = getAllRecords(view, searchName, domainName, dnssrv.TypeToString[queryType])
+	}
+
+	// wildcard records are served with the requested name as owner
+	if isWildcard {
+		for i := range records {
+			records[i].Name = hostName
+		}
 	}
+
 	return records
 }
 
@@ -229,6 +274,19 @@ func parseQuery(m *dnssrv.Msg, client string) (int, error) {
 	clientIP := net.ParseIP(clientdata)
 	log.WithField("client", clientIP).WithField("orgclient", client).WithField("clientdata", clientdata).Debug("Client")
 
+	// balance on the client subnet if a trusted resolver send one
+	balanceIP := clientIP
+	ecs := replyClientSubnet(m)
+	if ip := clientSubnetIP(ecs); ip != nil {
+		balanceIP = ip
+	}
+
+	// the records served depend on the view of the client network
+	view := selectView(balanceIP)
+	if clientSubnetIP(ecs) != nil && viewsEnabled() {
+		ecs.SourceScope = ecs.SourceNetmask
+	}
+
 	exitcode := dnssrv.RcodeServerFailure
 
 	for _, q := range m.Question {
@@ -248,32 +306,62 @@ func parseQuery(m *dnssrv.Msg, client string) (int, error) {
 			q.Qtype = dnssrv.TypePTR
 		}
 
-		if !localZone(domainName) { // if request is not our domain then its a fqdn
+		if !localZone(view, domainName) { // if request is not our domain then its a fqdn
 			log.WithField("fqdn", strings.ToLower(q.Name)).WithField("domain", strings.ToLower(domainName)).WithField("querytype", dnssrv.TypeToString[q.Qtype]).Debug("Non local zone request")
-			s := dnssrv.SplitDomainName(q.Name)
-			hostName = strings.Join(s[:1], ".")
-			domainName = strings.Join(s[1:len(s)], ".")
+			hostName, domainName = splitZone(view, q.Name)
 		}
 
 		clog := log.WithField("domain", strings.ToLower(domainName)).WithField("hostname", strings.ToLower(hostName)).WithField("querytype", dnssrv.TypeToString[q.Qtype]).WithField("client", clientIP.String()).WithField("0x20", q.Name != strings.ToLower(q.Name))
+		if view != "" {
+			clog = clog.WithField("view", view)
+		}
+
+		if ecs != nil {
+			clog = clog.WithField("clientsubnet", fmt.Sprintf("%s/%d", ecs.Address, ecs.SourceNetmask))
+		}
+
 		clog.Info("DNS request from client")
 
+		// DNSSEC keys are served from the zone apex of signed zones
+		signer := getSigner(domainName)
+		if !localZone(view, domainName) {
+			signer = nil
+		}
+
+		dnssecOK := signer != nil && dnssecRequested(m)
+		if signer != nil && hostName == "" && (q.Qtype == dnssrv.TypeDNSKEY || (q.Qtype == dnssrv.TypeNSEC3PARAM && signer.config.NSEC3)) {
+			m.Authoritative = true
+			if q.Qtype == dnssrv.TypeDNSKEY {
+				m.Answer = append(m.Answer, signer.dnskeys(q.Name)...)
+			} else {
+				m.Answer = append(m.Answer, signer.nsec3param(q.Name))
+			}
+
+			if dnssecOK {
+				signer.signResponse(m, view, q.Name, hostName, domainName)
+			}
+
+			clog.WithField("dnssec", dnssecOK).Info("DNSSEC reply to client")
+			exitcode = dnssrv.RcodeSuccess
+			continue
+		}
+
 		var records []Record
-		records = getRecordsByType(hostName, domainName, q.Qtype)
+		records = getRecordsByType(view, hostName, domainName, q.Qtype)
 		for id, r := range records {
 			clog.WithField("prio", id).WithField("preference", r.Statistics.Preference).WithField("target", r.Target).WithField("recordtype", r.Type).Debug("DNS Records found")
 		}
 
 		// If we have no A records, check for CNAME
 		if len(records) == 0 && q.Qtype == dnssrv.TypeA {
-			records = getRecordsByType(hostName, domainName, dnssrv.TypeCNAME)
+			records = getRecordsByType(view, hostName, domainName, dnssrv.TypeCNAME)
 			for id, r := range records {
 				clog.WithField("prio", id).WithField("preference", r.Statistics.Preference).WithField("target", r.Target).WithField("recordtype", r.Type).Debug("DNS Records found")
 			}
 		}
 
-		if len(records) == 0 && !localZone(domainName) {
-			if allowedToForward(clientIP) {
+		if len(records) == 0 && !localZone(view, domainName) {
+			if allowedToForward(view, clientIP) {
 				clog.Debug("Relaying request for client")
 				dnsForwarder(m, q)
 				return -1, nil // copy error result
@@ -285,22 +373,34 @@ func parseQuery(m *dnssrv.Msg, client string) (int, error) {
 		// If we have more then 1 record, see how we should balance these records
 		if len(records) > 1 {
 			log.WithField("mode", records[0].BalanceMode).WithField("records", len(records)).Debug("Applying balancing")
-			orderedrec, err := getRecordsBalanced(records, clientIP.String(), records[0].BalanceMode)
+			orderedrec, err := getRecordsBalanced(records, balanceIP.String(), records[0].BalanceMode)
 			if err != nil {
 				clog.WithField("error", err).Warn("Unable to process the dns balancer, sending original records")
 				orderedrec = records
 			}
 			records = orderedrec
+			if q.Qtype == dnssrv.TypeA || q.Qtype == dnssrv.TypeAAAA {
+				records = limitAnswers(records)
+			}
+
+			// the answer is only valid for the client subnet if it depends on the client
+			if clientSubnetIP(ecs) != nil && clientAwareBalanceMode(records[0].BalanceMode) {
+				ecs.SourceScope = ecs.SourceNetmask
+			}
 		}
 
 		var additionalrecords []Record
 		switch q.Qtype {
 		case dnssrv.TypeAAAA:
 			if len(records) == 0 {
-				aRecords := getRecordsByType(hostName, domainName, dnssrv.TypeA)
+				aRecords := getRecordsByType(view, hostName, domainName, dnssrv.TypeA)
 				if len(aRecords) > 0 {
 					// we have AAAA record request, which doesn't exist, but we have A records that do exist.
 					// so don't give an error that the domain doesn't exist, just nod and smile
+					if dnssecOK {
+						signer.signResponse(m, view, q.Name, hostName, domainName)
+					}
+
 					return dnssrv.RcodeSuccess, nil
 				}
 			}
@@ -308,19 +408,27 @@ func parseQuery(m *dnssrv.Msg, client string) (int, error) {
 		case dnssrv.TypeSOA:
 			fallthrough
 
-		case dnssrv.TypeNS, dnssrv.TypeMX:
+		case dnssrv.TypeNS, dnssrv.TypeMX, dnssrv.TypeSRV:
 			for _, currec := range records {
 				first := strings.Split(currec.Target, " ")
 				var s []string
 				if q.Qtype == dnssrv.TypeMX {
 					s = dnssrv.SplitDomainName(first[1])
+				} else if q.Qtype == dnssrv.TypeSRV && len(first) == 4 {
+					s = dnssrv.SplitDomainName(first[3])
 				} else {
 					s = dnssrv.SplitDomainName(first[0])
 				}
+
+				if len(s) == 0 {
+					// the root, e.g. a SRV record of a service that is not available
+					continue
+				}
+
 				hostName := strings.ToLower(strings.Join(s[:1], "."))
 				domainNameNS := strings.ToLower(strings.Join(s[1:len(s)], "."))
 
-				nsrecords := getRecordsByType(hostName, domainNameNS, dnssrv.TypeA)
+				nsrecords := getRecordsByType(view, hostName, domainNameNS, dnssrv.TypeA)
 				log.Debugf("Got NS records: %+v", nsrecords)
 				for _, ns := range nsrecords {
 					additionalrecords = append(additionalrecords, ns)
@@ -346,9 +454,9 @@ func parseQuery(m *dnssrv.Msg, client string) (int, error) {
 			}
 
 			if record.Name == "" {
-				newRecord = fmt.Sprintf("%s %d %s %s", q.Name, record.TTL, record.Type, record.Target)
+				newRecord = fmt.Sprintf("%s %d %s %s", q.Name, record.TTL, record.Type, recordData(record.Type, record.Target))
 			} else {
-				newRecord = fmt.Sprintf("%s.%s %d %s %s", record.Name, domainName, record.TTL, record.Type, record.Target)
+				newRecord = fmt.Sprintf("%s.%s %d %s %s", record.Name, domainName, record.TTL, record.Type, recordData(record.Type, record.Target))
 			}
 
 			rr, err := dnssrv.NewRR(newRecord)
@@ -363,7 +471,7 @@ func parseQuery(m *dnssrv.Msg, client string) (int, error) {
 		// Authoritive records
 		if m.Authoritative == true {
 			log.Debug("Authoritive answer, finding NS")
-			authrecords := getRecordsByType("", domainName, dnssrv.TypeNS)
+			authrecords := getRecordsByType(view, "", domainName, dnssrv.TypeNS)
 			for _, record := range authrecords {
 				if record.TTL == 0 {
 					record.TTL = 10
@@ -385,9 +493,9 @@ func parseQuery(m *dnssrv.Msg, client string) (int, error) {
 			}
 
 			if record.Name == "" {
-				newRecord = fmt.Sprintf("%s %d %s %s", q.Name, record.TTL, record.Type, record.Target)
+				newRecord = fmt.Sprintf("%s %d %s %s", q.Name, record.TTL, record.Type, recordData(record.Type, record.Target))
 			} else {
-				newRecord = fmt.Sprintf("%s.%s %d %s %s", record.Name, domainName, record.TTL, record.Type, record.Target)
+				newRecord = fmt.Sprintf("%s.%s %d %s %s", record.Name, domainName, record.TTL, record.Type, recordData(record.Type, record.Target))
 			}
 
 			rr, err := dnssrv.NewRR(newRecord)
@@ -402,7 +510,19 @@ func parseQuery(m *dnssrv.Msg, client string) (int, error) {
 
 		switch q.Qtype {
 		case dnssrv.TypeAAAA, dnssrv.TypeA:
-			if len(m.Answer) == 0 {
+			if len(m.Answer) == 0 && emptyNonTerminal(view, hostName, domainName) {
+				// the name exists since there are names below it, it just has no records
+				exitcode = dnssrv.RcodeSuccess
+			} else if len(m.Answer) == 0 && offlineFallback(view, hostName, domainName, q.Qtype) == FallbackNXDomain {
+				// all records are offline, and the record set is configured to act as if the name does not exist
+				// the SOA allows resolvers to cache the negative answer (RFC 2308)
+				m.Authoritative = true
+				if soa := zoneSOA(domainName); soa != nil {
+					m.Ns = append(m.Ns, soa)
+				}
+
+				exitcode = dnssrv.RcodeNameError
+			} else if len(m.Answer) == 0 {
 				// Only for loadbalanced records (A/AAAA) do we show failure if there are no Records
 				// This so that the 2nd loadbalancer will be queried
 				exitcode = dnssrv.RcodeServerFailure
@@ -415,6 +535,12 @@ func parseQuery(m *dnssrv.Msg, client string) (int, error) {
 			exitcode = dnssrv.RcodeSuccess
 		}
 
+		// add signatures and negative proofs if the client supports DNSSEC
+		if dnssecOK && exitcode == dnssrv.RcodeSuccess {
+			signer.signResponse(m, view, q.Name, hostName, domainName)
+		} else if dnssecOK && exitcode == dnssrv.RcodeNameError {
+			signer.signNameError(m, view, q.Name, hostName, domainName)
+		}
 	}
 
 	log.WithField("exitcode", exitcode).Debugf("Request Finished")
@@ -429,18 +555,42 @@ func dnsForwarder(m *dnssrv.Msg, q dnssrv.Question) {
 	log := logging.For("dns/server/forward")
 
 	// Local resolving failed, if we have forwarding enabled, pass the request on
-	log.WithField("name", q.Name).WithField("type", dnssrv.TypeToString[q.Qtype]).Infof("DNS Forwarding")
-	rrs, err := dnsmanager.Resolver.Resolve(q.Name, dnssrv.TypeToString[q.Qtype])
-	log.Debugf("Got forwarded DNS reply: %+v", rrs)
-	if err != nil {
-		log.WithField("name", q.Name).WithField("type", dnssrv.TypeToString[q.Qtype]).Warn("Failed to resolve forwarded dns")
-		return
+	rrs, cached := cacheGet(q)
+	if cached {
+		log.WithField("name", q.Name).WithField("type", dnssrv.TypeToString[q.Qtype]).Debug("DNS Forwarding answered from cache")
+	} else {
+		log.WithField("name", q.Name).WithField("type", dnssrv.TypeToString[q.Qtype]).Infof("DNS Forwarding")
+		var err error
+		rrs, err = forwardResolve(q)
+		log.Debugf("Got forwarded DNS reply: %+v", rrs)
+		if err != nil {
+			log.WithField("name", q.Name).WithField("type", dnssrv.TypeToString[q.Qtype]).Warn("Failed to resolve forwarded dns")
+			return
+		}
+
+		cacheSet(q, rrs)
 	}
+
 	m.RecursionAvailable = true
+	m.Rcode = rrs.Rcode
 	m.Answer = rrs.Answer
-	m.Extra = rrs.Extra
 	m.Ns = rrs.Ns
 
+	// keep the OPT record set for the client, the one of the upstream is not passed on
+	var extra []dnssrv.RR
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype == dnssrv.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+
+	for _, rr := range rrs.Extra {
+		if rr.Header().Rrtype != dnssrv.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+
+	m.Extra = extra
 }
 
 // handleDNSRequest receives queries and sends replies
@@ -449,9 +599,34 @@ func handleDNSRequest(w dnssrv.ResponseWriter, r *dnssrv.Msg) {
 	m.SetReply(r)
 	m.Compress = false
 
+	// echo EDNS0 to the client, this also tells parseQuery the client requested DNSSEC and its client subnet
+	opt := r.IsEdns0()
+	ecs := requestClientSubnet(opt, remoteIP(w.RemoteAddr()))
+	if opt != nil {
+		setEdns0(m, opt.Do(), ecs)
+	}
+
+	// count the query and its reply, by the client subnet of trusted resolvers
+	if r.Opcode == dnssrv.OpcodeQuery && len(r.Question) > 0 {
+		client := remoteIP(w.RemoteAddr())
+		if ip := clientSubnetIP(ecs); ip != nil {
+			client = ip
+		}
+
+		defer recordQuery(r.Question[0], m, requestTransport(w), client, time.Now())
+	}
+
 	// go through the message requests
 	switch r.Opcode {
 	case dnssrv.OpcodeQuery:
+		if len(r.Question) == 1 && (r.Question[0].Qtype == dnssrv.TypeAXFR || r.Question[0].Qtype == dnssrv.TypeIXFR) {
+			if handleTransfer(w, r, m) {
+				return
+			}
+
+			break
+		}
+
 		rcode, err := parseQuery(m, w.RemoteAddr().String())
 		if err != nil {
 			// No record found or other error, give server failure so resolv will move to next server for query
@@ -461,13 +636,71 @@ func handleDNSRequest(w dnssrv.ResponseWriter, r *dnssrv.Msg) {
 		if rcode >= 0 {
 			m.SetRcode(r, rcode)
 		}
+	case dnssrv.OpcodeNotify:
+		handleNotify(w, r, m)
+	case dnssrv.OpcodeUpdate:
+		handleUpdate(w, r, m)
 	default:
 		m.SetRcode(r, dnssrv.RcodeRefused)
 	}
 
+	if opt != nil {
+		// forwarded replies replace the additional section, make sure we send our own OPT record
+		setEdns0(m, opt.Do(), ecs)
+		if opt.Do() && w.RemoteAddr().Network() == "udp" {
+			// signed replies can exceed the clients buffer, set the TC bit so it retries over TCP
+			m.Truncate(int(opt.UDPSize()))
+		}
+	}
+
+	// limit identical udp responses, their source address can be spoofed to reflect them to a victim
+	if w.RemoteAddr().Network() == "udp" && r.Opcode == dnssrv.OpcodeQuery {
+		switch rateLimit(remoteIP(w.RemoteAddr()), r, m) {
+		case rateLimitDrop:
+			return
+		case rateLimitSlip:
+			rateLimitSlipReply(m)
+		}
+	}
+
+	// sign the reply if the request was signed with a known key
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+
 	w.WriteMsg(m)
 }
 
+// setEdns0 replaces the OPT record of a reply
+func setEdns0(m *dnssrv.Msg, do bool, ecs *dnssrv.EDNS0_SUBNET) {
+	extra := m.Extra[:0]
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dnssrv.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+
+	m.Extra = extra
+	m.SetEdns0(dnssrv.DefaultMsgSize, do)
+	if ecs != nil {
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, ecs)
+	}
+}
+
+// remoteIP returns the ip of a client address
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+
+	host, _, _ := net.SplitHostPort(addr.String())
+	return net.ParseIP(host)
+}
+
 // GetCache Returns a copy current cached entries
 func GetCache() map[string]Domains {
 	dnsmanager.RLock()
@@ -490,69 +723,135 @@ func Listen(server *dnssrv.Server) error {
 	return nil
 }
 
+// listenerShutdownTimeout is how long stopping the DNS listeners waits for running requests
+const listenerShutdownTimeout = 5 * time.Second
+
+// listeners makes sure the DNS listeners are (re)started by one caller at a time
+var listeners sync.Mutex
+
 // Server Process DNS Requests
 func Server(host string, port int, allowedRequests []string) {
 	log := logging.For("dns/server")
+	listeners.Lock()
+	defer listeners.Unlock()
+
 	dnsmanager.Lock()
-	defer dnsmanager.Unlock()
 	dnsmanager.AllowedRequests = allowedRequests
 	dnssrv.HandleFunc(".", handleDNSRequest)
 
 	clog := log.WithField("ip", host).WithField("port", port)
 	clog.Debug("Serving DNS Requests")
 
+	// TSIG keys can only be set when starting a listener, so a change of keys restarts the listeners
 	addr := fmt.Sprintf("%s:%d", host, port)
-	var serverTCP *dnssrv.Server
-	var serverUDP *dnssrv.Server
+	secrets := tsigSecretsLocked()
+	oldAddr := dnsmanager.TCPServer.Addr
+	if oldAddr == addr && reflect.DeepEqual(dnsmanager.TCPServer.TsigSecret, secrets) {
+		dnsmanager.Unlock()
+		return
+	}
 
-	// TCP Server address changed
-	if dnsmanager.TCPServer.Addr != addr {
-		clog.WithField("old", dnsmanager.TCPServer.Addr).Debug("New address for DNS TCP Listener")
-		if dnsmanager.TCPServer.Addr != "" {
-			clog.Debug("Stopping old listener")
-			dnsmanager.stop <- true
-		}
+	dnsmanager.Unlock()
 
-		tcpListener, err := net.Listen("tcp", addr)
-		if err != nil {
-			clog.WithField("error", err).Error("Failed to start DNS TCP listener")
-			return
-		}
+	// the lock is not held while stopping, since stopping waits for running requests which need it
+	if oldAddr != "" {
+		clog.WithField("old", oldAddr).Info("Stopping old DNS listeners")
+		stopped := make(chan bool)
+		dnsmanager.stop <- stopped
+		<-stopped
+	}
 
-		serverTCP = &dnssrv.Server{Addr: host + ":" + strconv.Itoa(port), Net: "TCP", Listener: tcpListener}
-		go serverTCP.ActivateAndServe()
-		dnsmanager.TCPServer = serverTCP
+	serverTCP, serverUDP, err := listenDNS(addr, secrets)
+	dnsmanager.Lock()
+	defer dnsmanager.Unlock()
+	if err != nil {
+		clog.WithField("error", err).Error("Failed to start DNS listeners")
+		dnsmanager.TCPServer = &dnssrv.Server{}
+		dnsmanager.UDPServer = &dnssrv.Server{}
+		return
 	}
 
-	if dnsmanager.UDPServer.Addr != addr {
-		clog.WithField("old", dnsmanager.UDPServer.Addr).Debug("New address for DNS UDP Listener")
-		udpListener, err := net.ListenPacket("udp", addr)
-		if err != nil {
-			clog.WithField("error", err).Error("Failed to start DNS UDP listener")
-			return
-		}
+	dnsmanager.TCPServer = serverTCP
+	dnsmanager.UDPServer = serverUDP
+	go serveDNS(serverTCP, serverUDP)
+}
+
+// listenDNS starts the TCP and UDP listeners, both are started or neither
+func listenDNS(addr string, secrets map[string]string) (*dnssrv.Server, *dnssrv.Server, error) {
+	tcpListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to start DNS TCP listener: %s", err)
+	}
 
-		serverUDP = &dnssrv.Server{Addr: host + ":" + strconv.Itoa(port), Net: "UDP", PacketConn: udpListener}
-		go serverUDP.ActivateAndServe()
-		dnsmanager.UDPServer = serverUDP
+	serverTCP := &dnssrv.Server{Addr: addr, Net: "TCP", Listener: tcpListener, TsigSecret: secrets, MsgAcceptFunc: acceptMsg}
+	if err := activate(serverTCP); err != nil {
+		tcpListener.Close()
+		return nil, nil, fmt.Errorf("Failed to start DNS TCP listener: %s", err)
 	}
 
+	udpListener, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		shutdownDNS(serverTCP)
+		return nil, nil, fmt.Errorf("Failed to start DNS UDP listener: %s", err)
+	}
+
+	serverUDP := &dnssrv.Server{Addr: addr, Net: "UDP", PacketConn: udpListener, TsigSecret: secrets, MsgAcceptFunc: acceptMsg}
+	if err := activate(serverUDP); err != nil {
+		udpListener.Close()
+		shutdownDNS(serverTCP)
+		return nil, nil, fmt.Errorf("Failed to start DNS UDP listener: %s", err)
+	}
+
+	return serverTCP, serverUDP, nil
+}
+
+// activate serves requests on the listener of a server, and returns once it is serving
+// so a shutdown directly after does not find the server unstarted
+func activate(server *dnssrv.Server) error {
+	started := make(chan bool)
+	failed := make(chan error, 1)
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() {
+		failed <- server.ActivateAndServe()
+	}()
+
+	select {
+	case <-started:
+		return nil
+	case err := <-failed:
+		return err
+	}
+}
+
+// serveDNS owns the running listeners until it is asked to stop them
+func serveDNS(serverTCP, serverUDP *dnssrv.Server) {
+	log := logging.For("dns/server")
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGUSR1)
-	go func() {
-		for {
-			select {
-			case _ = <-dnsmanager.stop:
-				serverTCP.Shutdown()
-				serverUDP.Shutdown()
-				return
-			case signal := <-signalChan:
-				log.WithField("signal", signal).Debug("Signal detected")
-				Debug()
-			}
+	defer signal.Stop(signalChan)
+	for {
+		select {
+		case stopped := <-dnsmanager.stop:
+			shutdownDNS(serverTCP, serverUDP)
+			close(stopped)
+			return
+		case signal := <-signalChan:
+			log.WithField("signal", signal).Debug("Signal detected")
+			Debug()
 		}
-	}()
+	}
+}
 
+// shutdownDNS stops DNS listeners, giving running requests listenerShutdownTimeout to finish
+func shutdownDNS(servers ...*dnssrv.Server) {
+	log := logging.For("dns/server")
+	ctx, cancel := context.WithTimeout(context.Background(), listenerShutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.ShutdownContext(ctx); err != nil {
+			log.WithField("addr", server.Addr).WithField("net", server.Net).WithField("error", err).Warn("Failed to stop DNS listener cleanly")
+		}
+	}
 }
 
 // Debug Shows current state
@@ -570,63 +869,87 @@ func Debug() {
 }
 
 // Get all records which match the fqdn and request
-func getAllRecords(hostName, domainName, request string) (r []Record) {
+func getAllRecords(view, hostName, domainName, request string) (r []Record) {
 	var offlineRecords []Record
 	total := 0
 	//fqdn := fmt.Sprintf("%s.%s", hostName, domainName)
 	log := logging.For("dns/server/getallrecords").WithField("domain", strings.ToLower(domainName)).WithField("name", strings.ToLower(hostName)).WithField("type", request)
 	searchDomain := strings.ToLower(domainName)
 	searchHost := strings.ToLower(hostName)
-	dnsmanager.RLock()
-	defer dnsmanager.RUnlock()
+	// building log fields per record is expensive, only do it when they are logged
+	debug := log.Logger.IsLevelEnabled(logrus.DebugLevel)
+
+	// Search domains for records such as NS, CAA, SOA
+	// Search domains for records such as A, AAAA
+	entries := currentIndex().view(view).lookup(searchDomain, searchHost, request)
+	degraded := degradedTTL(entries)
+	for _, entry := range entries {
+		record := entry.record
+		if degraded > 0 {
+			// a shorter ttl while the record set is degraded, so clients stop using failed targets sooner
+			record.TTL = activeTTL(record, degraded)
+		}
 
-	for nodeName := range dnsmanager.node {
-		// Search domains for records such as NS, CAA, SOA
-		// Search domains for records such as A, AAAA
-		if _, ok := dnsmanager.node[nodeName].Domains[searchDomain]; ok {
-			for _, record := range dnsmanager.node[nodeName].Domains[searchDomain].Records {
-				if record.Name == searchHost && record.Type == request {
-					log.WithField("cluster", nodeName).WithField("target", record.Target).WithField("mode", record.BalanceMode).WithField("uuid", record.UUID).Debug("Found record")
-
-					if record.Type == "SOA" {
-						reg, _ := regexp.Compile("###([A-Z_a-z]+)###")
-						fn := func(m string) string {
-							p := reg.FindStringSubmatch(m)
-							switch p[1] {
-							case "SERIAL":
-								return fmt.Sprintf("%d", time.Now().Unix()-(time.Now().Unix()%10))
-							}
-							return m
-						}
-						record.Target = reg.ReplaceAllStringFunc(record.Target, fn)
-					}
+		if debug {
+			log.WithField("cluster", entry.node).WithField("target", record.Target).WithField("mode", record.BalanceMode).WithField("uuid", record.UUID).Debug("Found record")
+		}
 
-					if record.Status == Online {
-						record.Name = hostName // set the original hostname requested for 0x20 bit
-						r = append(r, record)
-					} else {
-						offlineRecords = append(offlineRecords, record)
-					}
+		if record.Status == Online {
+			record.Name = hostName // set the original hostname requested for 0x20 bit
+			r = append(r, record)
+		} else {
+			offlineRecords = append(offlineRecords, record)
+		}
 
-					total++
-				}
+		total++
+	}
+
+	// the serial is based on the zone content
+	if request == "SOA" {
+		reg, _ := regexp.Compile("###([A-Z_a-z]+)###")
+		fn := func(m string) string {
+			p := reg.FindStringSubmatch(m)
+			switch p[1] {
+			case "SERIAL":
+				return fmt.Sprintf("%d", currentZoneSerial(searchDomain))
 			}
+			return m
+		}
+
+		for i := range r {
+			r[i].Target = reg.ReplaceAllStringFunc(r[i].Target, fn)
+		}
+
+		for i := range offlineRecords {
+			offlineRecords[i].Target = reg.ReplaceAllStringFunc(offlineRecords[i].Target, fn)
 		}
 	}
-	log.WithField("records", total).WithField("online", len(r)).Debug("Matching dns records")
+
+	if debug {
+		log.WithField("records", total).WithField("online", len(r)).Debug("Matching dns records")
+	}
+
 	if len(r) == 0 && len(offlineRecords) > 0 {
-		log.WithField("records", total).WithField("online", len(r)).WithField("offline", len(offlineRecords)).Warn("No online dns records, using fallback")
-		return offlineRecords
+		fallback := offlineRecords[0].OfflineFallback
+		log.WithField("records", total).WithField("online", len(r)).WithField("offline", len(offlineRecords)).WithField("fallback", fallback).Warn("No online dns records, using fallback")
+		return offlineFallbackRecords(offlineRecords)
 	}
 
 	return
 }
 
-// allowedToForward returns true or fals if client ip matches networks allowed to do DNS forwarding
-func allowedToForward(clientIP net.IP) bool {
+// allowedToForward returns true or fals if client ip matches networks allowed to do DNS forwarding, clients of a view use the networks of their view
+func allowedToForward(view string, clientIP net.IP) bool {
 	//log := logging.For("dns/server/allowedtoforward").WithField("clientip", clientIP)
 	//log.Debug("Checking if client is allowed to forward")
-	for _, cidr := range dnsmanager.AllowForwarding {
+	networks := dnsmanager.AllowForwarding
+	if view != "" {
+		views.RLock()
+		networks = views.views[view].allowForwarding
+		views.RUnlock()
+	}
+
+	for _, cidr := range networks {
 		//log.WithField("cidr", cidr.String()).Debug("Checking if client matches cidr")
 		if cidr.Contains(clientIP) {
 			return true
@@ -636,15 +959,7 @@ func allowedToForward(clientIP net.IP) bool {
 	return false
 }
 
-func localZone(domainName string) bool {
-	dnsmanager.RLock()
-	defer dnsmanager.RUnlock()
-	searchDomain := strings.ToLower(domainName)
-	for nodeName := range dnsmanager.node {
-		if _, ok := dnsmanager.node[nodeName].Domains[searchDomain]; ok {
-			return true
-		}
-	}
-
-	return false
+// localZone returns true if a domain is served in a view
+func localZone(view, domainName string) bool {
+	return currentIndex().view(view).domain(domainName) != nil
 }
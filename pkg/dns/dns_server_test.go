@@ -0,0 +1,103 @@
+package dns
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRecordsRestart = []Record{
+	{UUID: "r-a", Name: "www", Type: "A", Target: "127.0.7.1", TTL: 120, Status: Online, Local: true},
+}
+
+// freePort returns a port that is not in use on localhost
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestServerRestart(t *testing.T) {
+	logging.Configure("stdout", "error")
+	loadRecords("localdns", "restart.example", testRecordsRestart)
+	SetTSIGKeys(map[string]TSIGKey{})
+	defer SetTSIGKeys(map[string]TSIGKey{})
+
+	port := freePort(t)
+	Server("127.0.0.1", port, []string{})
+	defer func() {
+		stopped := make(chan bool)
+		dnsmanager.stop <- stopped
+		<-stopped
+		dnsmanager.Lock()
+		dnsmanager.TCPServer = &dnssrv.Server{}
+		dnsmanager.UDPServer = &dnssrv.Server{}
+		dnsmanager.Unlock()
+	}()
+
+	query := func(addr string) error {
+		m := new(dnssrv.Msg)
+		m.SetQuestion("www.restart.example.", dnssrv.TypeA)
+		c := &dnssrv.Client{Net: "tcp", Timeout: time.Second}
+		reply, _, err := c.Exchange(m, addr)
+		if err == nil {
+			assert.True(t, answerTarget(reply, "127.0.7.1"))
+		}
+
+		return err
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	assert.Nil(t, query(addr))
+
+	// changed TSIG keys restart the listeners while requests are being answered
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					query(addr)
+				}
+			}
+		}()
+	}
+
+	SetTSIGKeys(map[string]TSIGKey{"restart-key": {Secret: "c2VjcmV0", Algorithm: "hmac-sha256"}})
+	restarted := make(chan bool)
+	go func() {
+		Server("127.0.0.1", port, []string{})
+		close(restarted)
+	}()
+
+	select {
+	case <-restarted:
+	case <-time.After(3 * listenerShutdownTimeout):
+		t.Fatal("restarting the DNS listeners did not finish")
+	}
+
+	close(done)
+	wg.Wait()
+	dnsmanager.RLock()
+	assert.Equal(t, map[string]string{"restart-key.": "c2VjcmV0"}, dnsmanager.TCPServer.TsigSecret)
+	dnsmanager.RUnlock()
+	assert.Nil(t, query(addr))
+
+	// a new address stops the listeners of the old address
+	newPort := freePort(t)
+	Server("127.0.0.1", newPort, []string{})
+	assert.Nil(t, query(net.JoinHostPort("127.0.0.1", strconv.Itoa(newPort))))
+	assert.NotNil(t, query(addr))
+}
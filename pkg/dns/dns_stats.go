@@ -0,0 +1,329 @@
+package dns
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+)
+
+// QueryStats contains the statistics of the queries of a time window
+type QueryStats struct {
+	Window     string         `json:"window"`
+	Queries    int64          `json:"queries"`
+	QPS        float64        `json:"qps"`
+	Latency    LatencyStats   `json:"latency"`
+	Names      []QueryCounter `json:"names"`   // top requested names
+	Clients    []QueryCounter `json:"clients"` // top client networks
+	Types      []QueryCounter `json:"types"`
+	Rcodes     []QueryCounter `json:"rcodes"`
+	Transports []QueryCounter `json:"transports"`
+}
+
+// QueryCounter is the amount of queries of a name, type, rcode, transport or client network
+type QueryCounter struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// LatencyStats contains the time taken to reply to queries in milliseconds, percentiles are the upper bound of their histogram bucket
+type LatencyStats struct {
+	Average float64 `json:"average"`
+	P50     float64 `json:"p50"`
+	P95     float64 `json:"p95"`
+	P99     float64 `json:"p99"`
+}
+
+// QueryStatsWindows are the time windows query statistics are kept for
+var QueryStatsWindows = []string{"1m", "5m", "15m", "1h"}
+
+// statsMinutes is the amount of minutes query statistics are kept
+const statsMinutes = 60
+
+// statsMaxKeys is the maximum amount of names or client networks counted per minute, others are counted as statsOther
+// this keeps memory use bounded when clients request random names
+const statsMaxKeys = 10000
+
+// statsOther is the key of queries not counted by themselves
+const statsOther = "(other)"
+
+// latencyBuckets are the upper bounds of the latency histogram in seconds
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// latencyHistogram counts durations per latency bucket, the last count is of durations above all buckets
+type latencyHistogram struct {
+	counts []int64
+	sum    float64 // in seconds
+	count  int64
+}
+
+// newLatencyHistogram returns an empty histogram
+func newLatencyHistogram() latencyHistogram {
+	return latencyHistogram{counts: make([]int64, len(latencyBuckets)+1)}
+}
+
+// observe adds a duration to the histogram
+func (h *latencyHistogram) observe(seconds float64) {
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// add adds the counts of another histogram
+func (h *latencyHistogram) add(o latencyHistogram) {
+	for i := range o.counts {
+		h.counts[i] += o.counts[i]
+	}
+
+	h.sum += o.sum
+	h.count += o.count
+}
+
+// percentile returns the upper bound in milliseconds of the bucket that holds the percentile
+func (h *latencyHistogram) percentile(p float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	var cumulative int64
+	for i, count := range h.counts[:len(latencyBuckets)] {
+		cumulative += count
+		if float64(cumulative) >= p*float64(h.count) {
+			return latencyBuckets[i] * 1000
+		}
+	}
+
+	return latencyBuckets[len(latencyBuckets)-1] * 1000
+}
+
+// statsBucket holds the statistics of the queries of one minute
+type statsBucket struct {
+	minute     int64
+	queries    int64
+	names      map[string]int64
+	clients    map[string]int64
+	types      map[string]int64
+	rcodes     map[string]int64
+	transports map[string]int64
+	latency    latencyHistogram
+}
+
+// newStatsBucket returns an empty bucket of a minute
+func newStatsBucket(minute int64) *statsBucket {
+	return &statsBucket{
+		minute:     minute,
+		names:      make(map[string]int64),
+		clients:    make(map[string]int64),
+		types:      make(map[string]int64),
+		rcodes:     make(map[string]int64),
+		transports: make(map[string]int64),
+		latency:    newLatencyHistogram(),
+	}
+}
+
+// totalsKey identifies the counter of queries since start for the metrics
+type totalsKey struct {
+	qtype     string
+	rcode     string
+	transport string
+}
+
+// queryStats holds the statistics of the queries of the last hour per minute, and the totals since start
+var queryStats = struct {
+	sync.Mutex
+	buckets [statsMinutes]*statsBucket
+	totals  map[totalsKey]int64
+	latency latencyHistogram
+}{totals: make(map[totalsKey]int64), latency: newLatencyHistogram()}
+
+// countKey counts a key in a map, keys above the maximum are counted as other
+func countKey(counts map[string]int64, key string) {
+	if _, ok := counts[key]; !ok && len(counts) >= statsMaxKeys {
+		key = statsOther
+	}
+
+	counts[key]++
+}
+
+// clientNetwork returns the /24 network of an ipv4 client, or the /48 network of an ipv6 client
+func clientNetwork(ip net.IP) string {
+	if ip == nil {
+		return "unknown"
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// requestTransport returns the transport a request was received on: udp, tcp, tls or https
+func requestTransport(w dnssrv.ResponseWriter) string {
+	switch w.(type) {
+	case *dohResponseWriter:
+		return "https"
+	case dotResponseWriter:
+		return "tls"
+	}
+
+	return w.RemoteAddr().Network()
+}
+
+// recordQuery adds a query and its reply to the statistics
+func recordQuery(q dnssrv.Question, m *dnssrv.Msg, transport string, client net.IP, start time.Time) {
+	now := time.Now()
+	latency := now.Sub(start).Seconds()
+	qtype := dnssrv.Type(q.Qtype).String()
+	rcode := dnssrv.RcodeToString[m.Rcode]
+	if rcode == "" {
+		rcode = fmt.Sprintf("RCODE%d", m.Rcode)
+	}
+
+	minute := now.Unix() / 60
+	queryStats.Lock()
+	defer queryStats.Unlock()
+	bucket := queryStats.buckets[minute%statsMinutes]
+	if bucket == nil || bucket.minute != minute {
+		bucket = newStatsBucket(minute)
+		queryStats.buckets[minute%statsMinutes] = bucket
+	}
+
+	bucket.queries++
+	countKey(bucket.names, strings.ToLower(q.Name))
+	countKey(bucket.clients, clientNetwork(client))
+	countKey(bucket.types, qtype)
+	countKey(bucket.rcodes, rcode)
+	countKey(bucket.transports, transport)
+	bucket.latency.observe(latency)
+
+	queryStats.totals[totalsKey{qtype: qtype, rcode: rcode, transport: transport}]++
+	queryStats.latency.observe(latency)
+}
+
+// GetQueryStats returns the statistics of the queries in a time window (of 1 to 60 minutes), with the top amount of names and client networks
+func GetQueryStats(window time.Duration, top int) QueryStats {
+	minutes := int64(window / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+
+	if minutes > statsMinutes {
+		minutes = statsMinutes
+	}
+
+	stats := QueryStats{Window: (time.Duration(minutes) * time.Minute).String()}
+	names := make(map[string]int64)
+	clients := make(map[string]int64)
+	types := make(map[string]int64)
+	rcodes := make(map[string]int64)
+	transports := make(map[string]int64)
+	latency := newLatencyHistogram()
+
+	current := time.Now().Unix() / 60
+	queryStats.Lock()
+	for _, bucket := range queryStats.buckets {
+		if bucket == nil || bucket.minute <= current-minutes {
+			continue
+		}
+
+		stats.Queries += bucket.queries
+		addCounts(names, bucket.names)
+		addCounts(clients, bucket.clients)
+		addCounts(types, bucket.types)
+		addCounts(rcodes, bucket.rcodes)
+		addCounts(transports, bucket.transports)
+		latency.add(bucket.latency)
+	}
+	queryStats.Unlock()
+
+	stats.QPS = float64(stats.Queries) / (float64(minutes) * 60)
+	stats.Names = topCounters(names, top)
+	stats.Clients = topCounters(clients, top)
+	stats.Types = topCounters(types, 0)
+	stats.Rcodes = topCounters(rcodes, 0)
+	stats.Transports = topCounters(transports, 0)
+	if latency.count > 0 {
+		stats.Latency = LatencyStats{
+			Average: latency.sum / float64(latency.count) * 1000,
+			P50:     latency.percentile(0.50),
+			P95:     latency.percentile(0.95),
+			P99:     latency.percentile(0.99),
+		}
+	}
+
+	return stats
+}
+
+// addCounts adds the counts of a map to another
+func addCounts(counts, add map[string]int64) {
+	for key, count := range add {
+		counts[key] += count
+	}
+}
+
+// topCounters returns the counters sorted by count, limited to the top amount if top is above 0
+func topCounters(counts map[string]int64, top int) []QueryCounter {
+	counters := make([]QueryCounter, 0, len(counts))
+	for key, count := range counts {
+		counters = append(counters, QueryCounter{Key: key, Count: count})
+	}
+
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Count == counters[j].Count {
+			return counters[i].Key < counters[j].Key
+		}
+
+		return counters[i].Count > counters[j].Count
+	})
+
+	if top > 0 && len(counters) > top {
+		counters = counters[:top]
+	}
+
+	return counters
+}
+
+// WriteQueryMetrics writes the query statistics in the text format of Prometheus, as totals since start
+// the names requested and the networks of clients are left out, since metrics are public
+func WriteQueryMetrics(w io.Writer) {
+	queryStats.Lock()
+	var keys []totalsKey
+	totals := make(map[totalsKey]int64, len(queryStats.totals))
+	for key, count := range queryStats.totals {
+		keys = append(keys, key)
+		totals[key] = count
+	}
+
+	latency := newLatencyHistogram()
+	latency.add(queryStats.latency)
+	queryStats.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprintf("%s %s %s", keys[i].qtype, keys[i].rcode, keys[i].transport) < fmt.Sprintf("%s %s %s", keys[j].qtype, keys[j].rcode, keys[j].transport)
+	})
+
+	fmt.Fprintln(w, "# HELP mercury_dns_queries_total DNS queries by type, rcode and transport.")
+	fmt.Fprintln(w, "# TYPE mercury_dns_queries_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "mercury_dns_queries_total{type=%q,rcode=%q,transport=%q} %d\n", key.qtype, key.rcode, key.transport, totals[key])
+	}
+
+	fmt.Fprintln(w, "# HELP mercury_dns_query_duration_seconds Time taken to reply to DNS queries.")
+	fmt.Fprintln(w, "# TYPE mercury_dns_query_duration_seconds histogram")
+	var cumulative int64
+	for i, bound := range latencyBuckets {
+		cumulative += latency.counts[i]
+		fmt.Fprintf(w, "mercury_dns_query_duration_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+
+	fmt.Fprintf(w, "mercury_dns_query_duration_seconds_bucket{le=\"+Inf\"} %d\n", latency.count)
+	fmt.Fprintf(w, "mercury_dns_query_duration_seconds_sum %g\n", latency.sum)
+	fmt.Fprintf(w, "mercury_dns_query_duration_seconds_count %d\n", latency.count)
+}
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryStats(t *testing.T) {
+	assert.Equal(t, "192.0.2.0/24", clientNetwork(net.ParseIP("192.0.2.77")))
+	assert.Equal(t, "2001:db8:1::/48", clientNetwork(net.ParseIP("2001:db8:1:2::1")))
+	assert.Equal(t, "unknown", clientNetwork(nil))
+
+	before := GetQueryStats(time.Hour, 0)
+	reply := func(rcode int) *dnssrv.Msg {
+		m := new(dnssrv.Msg)
+		m.Rcode = rcode
+		return m
+	}
+
+	start := time.Now().Add(-2 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		recordQuery(dnssrv.Question{Name: "Stats1.Example.", Qtype: dnssrv.TypeA}, reply(dnssrv.RcodeSuccess), "udp", net.ParseIP("192.0.2.10"), start)
+	}
+
+	recordQuery(dnssrv.Question{Name: "stats2.example.", Qtype: dnssrv.TypeAAAA}, reply(dnssrv.RcodeNameError), "tls", net.ParseIP("192.0.2.20"), start)
+	recordQuery(dnssrv.Question{Name: "stats3.example.", Qtype: dnssrv.TypeA}, reply(dnssrv.RcodeSuccess), "https", net.ParseIP("2001:db8::1"), start)
+
+	assert.Equal(t, "1m0s", GetQueryStats(time.Minute, 0).Window)
+	stats := GetQueryStats(time.Hour, 0)
+	assert.Equal(t, before.Queries+5, stats.Queries)
+	assert.Equal(t, counterValue(before.Names, "stats1.example.")+3, counterValue(stats.Names, "stats1.example."))
+	assert.Equal(t, counterValue(before.Clients, "192.0.2.0/24")+4, counterValue(stats.Clients, "192.0.2.0/24"))
+	assert.Equal(t, counterValue(before.Rcodes, "NXDOMAIN")+1, counterValue(stats.Rcodes, "NXDOMAIN"))
+	assert.Equal(t, counterValue(before.Transports, "tls")+1, counterValue(stats.Transports, "tls"))
+	assert.True(t, stats.Latency.P99 >= 2)
+
+	// only the top names are returned, ordered by count
+	top := GetQueryStats(time.Hour, 1)
+	assert.Equal(t, "1h0m0s", top.Window)
+	assert.Len(t, top.Names, 1)
+
+	var metrics bytes.Buffer
+	WriteQueryMetrics(&metrics)
+	assert.Contains(t, metrics.String(), `mercury_dns_queries_total{type="AAAA",rcode="NXDOMAIN",transport="tls"}`)
+	assert.NotContains(t, metrics.String(), "stats1.example.")
+	assert.NotContains(t, metrics.String(), "192.0.2.0/24")
+	assert.Contains(t, metrics.String(), `mercury_dns_query_duration_seconds_bucket{le="+Inf"}`)
+}
+
+func TestQueryStatsMaxKeys(t *testing.T) {
+	counts := make(map[string]int64)
+	for i := 0; i < statsMaxKeys; i++ {
+		countKey(counts, string(rune(i)))
+	}
+
+	countKey(counts, "new.example.")
+	countKey(counts, string(rune(0)))
+	assert.Equal(t, int64(1), counts[statsOther])
+	assert.Equal(t, int64(2), counts[string(rune(0))])
+}
+
+// counterValue returns the count of a key in the counters
+func counterValue(counters []QueryCounter, key string) int64 {
+	for _, counter := range counters {
+		if counter.Key == key {
+			return counter.Count
+		}
+	}
+
+	return 0
+}
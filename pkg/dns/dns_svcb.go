@@ -0,0 +1,184 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	dnssrv "github.com/miekg/dns"
+)
+
+// Service binding record types (RFC 9460), which the dns library does not know
+const (
+	TypeSVCB  uint16 = 64
+	TypeHTTPS uint16 = 65
+)
+
+func init() {
+	// register the names, so the records can be configured and requested by name
+	// their data is send in the generic format of RFC 3597
+	for rtype, name := range map[uint16]string{TypeSVCB: "SVCB", TypeHTTPS: "HTTPS"} {
+		if _, ok := dnssrv.TypeToString[rtype]; !ok {
+			dnssrv.TypeToString[rtype] = name
+			dnssrv.StringToType[name] = rtype
+		}
+	}
+}
+
+// svcParamKeys are the supported parameters of service bindings
+var svcParamKeys = map[string]uint16{
+	"mandatory":       0,
+	"alpn":            1,
+	"no-default-alpn": 2,
+	"port":            3,
+	"ipv4hint":        4,
+	"ipv6hint":        6,
+}
+
+// serviceBindingRdata converts the presentation format of a SVCB or HTTPS record (e.g. `1 . alpn=h2 port=443`) to its wire format
+func serviceBindingRdata(target string) ([]byte, error) {
+	fields := strings.Fields(target)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("Service binding requires a priority and target name: %s", target)
+	}
+
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid service binding priority %s: %s", fields[0], err)
+	}
+
+	rdata := make([]byte, 2+256)
+	binary.BigEndian.PutUint16(rdata, uint16(priority))
+	off, err := dnssrv.PackDomainName(dnssrv.Fqdn(fields[1]), rdata, 2, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid service binding target name %s: %s", fields[1], err)
+	}
+
+	rdata = rdata[:off]
+	params := make(map[uint16][]byte)
+	for _, field := range fields[2:] {
+		parts := strings.SplitN(field, "=", 2)
+		key := strings.ToLower(parts[0])
+		value := ""
+		if len(parts) == 2 {
+			value = strings.Trim(parts[1], "\"")
+		}
+
+		code, ok := svcParamKeys[key]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported service binding parameter %s", key)
+		}
+
+		if _, ok := params[code]; ok {
+			return nil, fmt.Errorf("Duplicate service binding parameter %s", key)
+		}
+
+		params[code], err = serviceBindingParam(key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// parameters are ordered by key
+	var codes []int
+	for code := range params {
+		codes = append(codes, int(code))
+	}
+
+	sort.Ints(codes)
+	for _, code := range codes {
+		value := params[uint16(code)]
+		rdata = append(rdata, byte(code>>8), byte(code), byte(len(value)>>8), byte(len(value)))
+		rdata = append(rdata, value...)
+	}
+
+	return rdata, nil
+}
+
+// serviceBindingParam returns the wire format of the value of a service binding parameter
+func serviceBindingParam(key, value string) (v []byte, err error) {
+	switch key {
+	case "no-default-alpn":
+		if value != "" {
+			return nil, fmt.Errorf("Service binding parameter %s has no value", key)
+		}
+
+		return []byte{}, nil
+
+	case "port":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid service binding port %s: %s", value, err)
+		}
+
+		return []byte{byte(port >> 8), byte(port)}, nil
+	}
+
+	if value == "" {
+		return nil, fmt.Errorf("Service binding parameter %s requires a value", key)
+	}
+
+	items := strings.Split(value, ",")
+	if key == "mandatory" {
+		// mandatory keys are ordered like the parameters
+		sort.Slice(items, func(i, j int) bool { return svcParamKeys[items[i]] < svcParamKeys[items[j]] })
+	}
+
+	for _, item := range items {
+		switch key {
+		case "mandatory":
+			code, ok := svcParamKeys[item]
+			if !ok {
+				return nil, fmt.Errorf("Unsupported mandatory service binding parameter %s", item)
+			}
+
+			v = append(v, byte(code>>8), byte(code))
+
+		case "alpn":
+			if item == "" || len(item) > 255 {
+				return nil, fmt.Errorf("Invalid service binding alpn %s", item)
+			}
+
+			v = append(v, byte(len(item)))
+			v = append(v, item...)
+
+		case "ipv4hint":
+			ip := net.ParseIP(item).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid service binding ipv4hint %s", item)
+			}
+
+			v = append(v, ip...)
+
+		case "ipv6hint":
+			ip := net.ParseIP(item)
+			if ip == nil || ip.To4() != nil {
+				return nil, fmt.Errorf("Invalid service binding ipv6hint %s", item)
+			}
+
+			v = append(v, ip.To16()...)
+		}
+	}
+
+	return v, nil
+}
+
+// recordData returns the data of a record for the text format of a resource record
+// the data of service bindings is converted to the generic format of RFC 3597, since the dns library can not parse them
+func recordData(rtype, target string) string {
+	switch strings.ToUpper(rtype) {
+	case "SVCB", "HTTPS":
+		rdata, err := serviceBindingRdata(target)
+		if err != nil {
+			// parsing the record fails, which is logged by the caller
+			return target
+		}
+
+		return fmt.Sprintf("\\# %d %x", len(rdata), rdata)
+	}
+
+	return target
+}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"encoding/hex"
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceRecords(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	// wire format of the examples of RFC 9460 appendix D
+	rdata, err := serviceBindingRdata("1 foo.example.com. port=53")
+	assert.Nil(t, err)
+	assert.Equal(t, "000103666f6f076578616d706c6503636f6d00000300020035", hex.EncodeToString(rdata))
+	rdata, err = serviceBindingRdata("16 foo.example.org. alpn=h2,h3-19 mandatory=ipv4hint,alpn ipv4hint=192.0.2.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "001003666f6f076578616d706c65036f7267000000000400010004000100090268320568332d313900040004c0000201", hex.EncodeToString(rdata))
+
+	_, err = serviceBindingRdata("1")
+	assert.NotNil(t, err)
+	_, err = serviceBindingRdata("1 . port=http")
+	assert.NotNil(t, err)
+	_, err = serviceBindingRdata("1 . ech=abc")
+	assert.NotNil(t, err)
+	_, err = serviceBindingRdata("1 . ipv6hint=192.0.2.1")
+	assert.NotNil(t, err)
+
+	loadRecords("localdns", "svc.example", []Record{{UUID: "svc-soa", Name: "", Type: "SOA", Target: "ns1.svc.example. hostmaster.svc.example. ###SERIAL### 3600 600 86400 300", TTL: 3600, Status: Online, Local: true}})
+	loadRecords("svcnode1", "svc.example", []Record{
+		{UUID: "svc-1", Name: "www", Type: "A", Target: "127.0.14.1", TTL: 60, Status: Online},
+		{UUID: "svc-1", Name: "www", Type: "HTTPS", Target: "1 . alpn=h2,http/1.1 port=8443 ipv4hint=127.0.14.1", TTL: 60, Status: Online},
+		{UUID: "svc-1", Name: "_https._tcp.www", Type: "SRV", Target: "0 100 8443 www.svc.example.", TTL: 60, Status: Online},
+	})
+	loadRecords("svcnode2", "svc.example", []Record{
+		{UUID: "svc-2", Name: "www", Type: "A", Target: "127.0.14.2", TTL: 60, Status: Offline},
+		{UUID: "svc-2", Name: "www", Type: "HTTPS", Target: "1 . alpn=h2,http/1.1 port=8443 ipv4hint=127.0.14.2", TTL: 60, Status: Offline},
+	})
+
+	query := func(name string, qtype uint16) *dnssrv.Msg {
+		m := new(dnssrv.Msg)
+		m.SetQuestion(name, qtype)
+		parseQuery(m, "127.0.0.1:12345")
+		return m
+	}
+
+	// service bindings are send in the generic format, only the online ones like A records
+	m := query("www.svc.example.", TypeHTTPS)
+	if assert.True(t, answerCount(m, 1)) {
+		assert.Equal(t, TypeHTTPS, m.Answer[0].Header().Rrtype)
+		expected, _ := serviceBindingRdata("1 . alpn=h2,http/1.1 port=8443 ipv4hint=127.0.14.1")
+		assert.Equal(t, hex.EncodeToString(expected), m.Answer[0].(*dnssrv.RFC3597).Rdata)
+	}
+
+	// the records survive a round trip over the wire
+	packed, err := m.Pack()
+	assert.Nil(t, err)
+	assert.Nil(t, new(dnssrv.Msg).Unpack(packed))
+
+	// SRV records have the address of their target as additional record
+	m = query("_https._tcp.www.svc.example.", dnssrv.TypeSRV)
+	assert.True(t, answerTarget(m, "8443 www.svc.example."))
+	if assert.Len(t, m.Extra, 1) {
+		assert.Contains(t, m.Extra[0].String(), "127.0.14.1")
+	}
+}
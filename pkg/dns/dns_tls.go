@@ -0,0 +1,303 @@
+package dns
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/schubergphilis/mercury/pkg/tlsconfig"
+)
+
+// DoT contains the settings of the DNS-over-TLS listener (RFC 7858)
+type DoT struct {
+	Port      int                 `toml:"port" json:"port"` // port to listen on, the listener is only started if a certificate is configured
+	TLSConfig tlsconfig.TLSConfig `toml:"tls" json:"tls"`
+}
+
+// DoH contains the settings of the DNS-over-HTTPS listener (RFC 8484)
+type DoH struct {
+	Port      int                 `toml:"port" json:"port"` // port to listen on, the listener is only started if a certificate is configured
+	Path      string              `toml:"path" json:"path"` // path the dns requests are send to
+	TLSConfig tlsconfig.TLSConfig `toml:"tls" json:"tls"`
+}
+
+// dohContentType is the media type of dns messages over https
+const dohContentType = "application/dns-message"
+
+// encrypted holds the running DNS-over-TLS and DNS-over-HTTPS listeners
+var encrypted = struct {
+	sync.Mutex
+	dotAddr    string
+	dotConfig  DoT
+	dotSecrets map[string]string
+	dot        *dnssrv.Server
+	dohAddr    string
+	dohConfig  DoH
+	doh        *http.Server
+	dohListen  net.Listener
+}{}
+
+// EncryptedServer starts the DNS-over-TLS and DNS-over-HTTPS listeners, and restarts them if their settings changed
+func EncryptedServer(host string, dot DoT, doh DoH) {
+	log := logging.For("dns/server/encrypted")
+	encrypted.Lock()
+	defer encrypted.Unlock()
+
+	// TSIG keys can only be set when starting a listener
+	secrets := tsigSecrets()
+	dotAddr := net.JoinHostPort(host, strconv.Itoa(dot.Port))
+	if encrypted.dot != nil && (encrypted.dotAddr != dotAddr || !reflect.DeepEqual(encrypted.dotConfig, dot) || !reflect.DeepEqual(encrypted.dotSecrets, secrets)) {
+		log.WithField("addr", encrypted.dotAddr).Info("Stopping DNS-over-TLS listener")
+		encrypted.dot.Shutdown()
+		encrypted.dot = nil
+	}
+
+	if encrypted.dot == nil && dot.TLSConfig.CertificateProvided() {
+		server, err := dotListen(dotAddr, dot, secrets)
+		if err != nil {
+			log.WithField("addr", dotAddr).WithField("error", err).Error("Failed to start DNS-over-TLS listener")
+		} else {
+			log.WithField("addr", dotAddr).Info("Serving DNS-over-TLS requests")
+			encrypted.dot = server
+			encrypted.dotAddr = dotAddr
+			encrypted.dotConfig = dot
+			encrypted.dotSecrets = secrets
+		}
+	}
+
+	dohAddr := net.JoinHostPort(host, strconv.Itoa(doh.Port))
+	if encrypted.doh != nil && (encrypted.dohAddr != dohAddr || !reflect.DeepEqual(encrypted.dohConfig, doh)) {
+		log.WithField("addr", encrypted.dohAddr).Info("Stopping DNS-over-HTTPS listener")
+		encrypted.doh.Close()
+		encrypted.doh = nil
+	}
+
+	if encrypted.doh == nil && doh.TLSConfig.CertificateProvided() {
+		server, listener, err := dohListen(dohAddr, doh)
+		if err != nil {
+			log.WithField("addr", dohAddr).WithField("error", err).Error("Failed to start DNS-over-HTTPS listener")
+		} else {
+			log.WithField("addr", dohAddr).WithField("path", doh.Path).Info("Serving DNS-over-HTTPS requests")
+			encrypted.doh = server
+			encrypted.dohListen = listener
+			encrypted.dohAddr = dohAddr
+			encrypted.dohConfig = doh
+		}
+	}
+}
+
+// dotListen starts a DNS-over-TLS listener
+func dotListen(addr string, dot DoT, secrets map[string]string) (*dnssrv.Server, error) {
+	tlsConfig, err := tlsconfig.LoadCertificate(dot.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.NextProtos = []string{"dot"}
+	listener, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := func(w dnssrv.ResponseWriter, r *dnssrv.Msg) {
+		handleDNSRequest(dotResponseWriter{w}, r)
+	}
+
+	server := &dnssrv.Server{Addr: addr, Net: "tcp-tls", Listener: listener, TsigSecret: secrets, MsgAcceptFunc: acceptMsg, Handler: dnssrv.HandlerFunc(handler)}
+	go server.ActivateAndServe()
+	return server, nil
+}
+
+// dotResponseWriter marks the replies of DNS-over-TLS requests, for the query statistics
+type dotResponseWriter struct {
+	dnssrv.ResponseWriter
+}
+
+// dohListen starts a DNS-over-HTTPS listener
+func dohListen(addr string, doh DoH) (*http.Server, net.Listener, error) {
+	tlsConfig, err := tlsconfig.LoadCertificate(doh.TLSConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(doh.Path, dohHandler)
+	server := &http.Server{Handler: mux, TLSConfig: tlsConfig, ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second}
+	go server.ServeTLS(listener, "", "")
+	return server, listener, nil
+}
+
+// dohHandler answers dns requests send with GET or POST over https (RFC 8484 section 4.1)
+func dohHandler(w http.ResponseWriter, r *http.Request) {
+	log := logging.For("dns/server/doh").WithField("client", r.RemoteAddr)
+	var data []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		data, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		data, err = ioutil.ReadAll(io.LimitReader(r.Body, dnssrv.MaxMsgSize))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := new(dnssrv.Msg)
+	if err == nil {
+		err = req.Unpack(data)
+	}
+
+	if err != nil {
+		log.WithField("error", err).Debug("Invalid DNS-over-HTTPS request")
+		http.Error(w, "Invalid dns request", http.StatusBadRequest)
+		return
+	}
+
+	writer := &dohResponseWriter{remote: httpAddr(r.RemoteAddr), local: httpAddr(r.Host)}
+	if tsig := req.IsTsig(); tsig != nil {
+		writer.requestMAC = tsig.MAC
+		secret, ok := tsigSecrets()[tsig.Hdr.Name]
+		if !ok {
+			writer.tsigStatus = dnssrv.ErrSecret
+		} else {
+			writer.secret = secret
+			writer.tsigStatus = dnssrv.TsigVerify(data, secret, "", false)
+		}
+	}
+
+	// zone transfers consist of several messages, which do not fit in a single https reply
+	if len(req.Question) == 1 && (req.Question[0].Qtype == dnssrv.TypeAXFR || req.Question[0].Qtype == dnssrv.TypeIXFR) {
+		m := new(dnssrv.Msg)
+		m.SetRcode(req, dnssrv.RcodeRefused)
+		writer.WriteMsg(m)
+	} else {
+		handleDNSRequest(writer, req)
+	}
+
+	if writer.reply == nil {
+		http.Error(w, "Failed to answer dns request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohContentType)
+	if writer.msg != nil {
+		if ttl, ok := dohMaxAge(writer.msg); ok {
+			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+		}
+	}
+
+	w.Write(writer.reply)
+}
+
+// dohMaxAge returns the lowest ttl of a reply, which https caches may keep it for (RFC 8484 section 5.1)
+func dohMaxAge(m *dnssrv.Msg) (uint32, bool) {
+	found := false
+	var ttl uint32
+	for _, section := range [][]dnssrv.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dnssrv.TypeOPT || rr.Header().Rrtype == dnssrv.TypeTSIG {
+				continue
+			}
+
+			if !found || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				found = true
+			}
+		}
+	}
+
+	return ttl, found
+}
+
+// httpAddr returns the tcp address of a host:port string from a http request
+func httpAddr(hostport string) net.Addr {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+
+	p, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(strings.Trim(host, "[]")), Port: p}
+}
+
+// dohResponseWriter collects the reply of a DNS-over-HTTPS request
+type dohResponseWriter struct {
+	remote     net.Addr
+	local      net.Addr
+	secret     string
+	requestMAC string
+	tsigStatus error
+	msg        *dnssrv.Msg
+	reply      []byte
+}
+
+// LocalAddr returns the address the request was send to
+func (w *dohResponseWriter) LocalAddr() net.Addr {
+	return w.local
+}
+
+// RemoteAddr returns the address of the client
+func (w *dohResponseWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+// WriteMsg packs the reply, and signs it if it has a TSIG record
+func (w *dohResponseWriter) WriteMsg(m *dnssrv.Msg) (err error) {
+	var data []byte
+	if m.IsTsig() != nil && w.secret != "" {
+		data, _, err = dnssrv.TsigGenerate(m, w.secret, w.requestMAC, false)
+	} else {
+		data, err = m.Pack()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	w.msg = m
+	_, err = w.Write(data)
+	return err
+}
+
+// Write stores a packed reply
+func (w *dohResponseWriter) Write(data []byte) (int, error) {
+	w.reply = data
+	return len(data), nil
+}
+
+// Close does nothing, the https connection is managed by the http server
+func (w *dohResponseWriter) Close() error {
+	return nil
+}
+
+// TsigStatus returns the result of the TSIG verification of the request
+func (w *dohResponseWriter) TsigStatus() error {
+	return w.tsigStatus
+}
+
+// TsigTimersOnly is not supported for https requests
+func (w *dohResponseWriter) TsigTimersOnly(bool) {}
+
+// Hijack is not supported for https requests
+func (w *dohResponseWriter) Hijack() {}
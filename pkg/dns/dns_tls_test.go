@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/schubergphilis/mercury/pkg/tlsconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRecordsTLS = []Record{
+	{UUID: "t-a", Name: "www", Type: "A", Target: "127.0.6.1", TTL: 120, Status: Online, Local: true},
+}
+
+func TestEncryptedServer(t *testing.T) {
+	logging.Configure("stdout", "error")
+	loadRecords("localdns", "tls.example", testRecordsTLS)
+
+	certificate := tlsconfig.TLSConfig{CertificateFile: "../../test/ssl/self_signed_certificate.crt", CertificateKey: "../../test/ssl/self_signed_certificate.key"}
+	EncryptedServer("127.0.0.1", DoT{TLSConfig: certificate}, DoH{Path: "/dns-query", TLSConfig: certificate})
+	defer EncryptedServer("127.0.0.1", DoT{}, DoH{})
+
+	encrypted.Lock()
+	dotAddr := encrypted.dot.Listener.Addr().String()
+	dohAddr := encrypted.dohListen.Addr().String()
+	encrypted.Unlock()
+
+	query := new(dnssrv.Msg)
+	query.SetQuestion("www.tls.example.", dnssrv.TypeA)
+
+	// DNS-over-TLS
+	c := &dnssrv.Client{Net: "tcp-tls", TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	reply, _, err := c.Exchange(query, dotAddr)
+	if assert.Nil(t, err) {
+		assert.True(t, answerTarget(reply, "127.0.6.1"))
+	}
+
+	// DNS-over-HTTPS with POST and GET
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	data, err := query.Pack()
+	assert.Nil(t, err)
+	doh := func(resp *http.Response, err error) *dnssrv.Msg {
+		if !assert.Nil(t, err) {
+			return nil
+		}
+
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, dohContentType, resp.Header.Get("Content-Type"))
+		assert.Equal(t, "max-age=120", resp.Header.Get("Cache-Control"))
+		body, _ := ioutil.ReadAll(resp.Body)
+		m := new(dnssrv.Msg)
+		assert.Nil(t, m.Unpack(body))
+		return m
+	}
+
+	m := doh(client.Post("https://"+dohAddr+"/dns-query", dohContentType, bytes.NewReader(data)))
+	assert.True(t, m != nil && answerTarget(m, "127.0.6.1"))
+	m = doh(client.Get("https://" + dohAddr + "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(data)))
+	assert.True(t, m != nil && answerTarget(m, "127.0.6.1"))
+
+	// invalid requests are rejected
+	resp, err := client.Get("https://" + dohAddr + "/dns-query?dns=invalid!")
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	resp, err = client.Post("https://"+dohAddr+"/dns-query", "text/plain", bytes.NewReader(data))
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,278 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// Transfer contains the zone transfer settings of a domain
+type Transfer struct {
+	Allow   []string `toml:"allow" json:"allow"`       // cidrs of secondaries allowed to transfer the zone
+	TSIGKey string   `toml:"tsig_key" json:"tsig_key"` // name of the tsig key secondaries must sign their request with
+	Notify  []string `toml:"notify" json:"notify"`     // secondaries (ip:port) to send a NOTIFY when the zone changes
+}
+
+// transferRecordsPerMessage is the amount of records send in a single zone transfer message
+const transferRecordsPerMessage = 100
+
+// notifyInterval is how often zones are checked for changes to notify
+const notifyInterval = 5 * time.Second
+
+// notifier tracks the serials send to the secondaries
+var notifier = struct {
+	sync.Mutex
+	once   sync.Once
+	serial map[string]uint32
+}{serial: make(map[string]uint32)}
+
+// UpdateTransfers sets the zone transfer settings of all domains, and starts notifying secondaries of changes
+func UpdateTransfers(domains map[string]Domain) {
+	transfers := make(map[string]Transfer)
+	for domainName, domain := range domains {
+		if len(domain.Transfer.Allow) > 0 || domain.Transfer.TSIGKey != "" || len(domain.Transfer.Notify) > 0 {
+			transfers[strings.ToLower(domainName)] = domain.Transfer
+		}
+	}
+
+	dnsmanager.Lock()
+	dnsmanager.transfers = transfers
+	dnsmanager.Unlock()
+
+	notifier.once.Do(func() {
+		go notifyHandler()
+	})
+}
+
+// getTransfer returns the zone transfer settings of a domain
+func getTransfer(domainName string) (Transfer, bool) {
+	dnsmanager.RLock()
+	defer dnsmanager.RUnlock()
+	transfer, ok := dnsmanager.transfers[strings.ToLower(strings.TrimSuffix(domainName, "."))]
+	return transfer, ok
+}
+
+// clientAllowed returns the rcode for a transfer or update request, which is success if the client matches the allowed cidrs and tsig key
+func clientAllowed(w dnssrv.ResponseWriter, r *dnssrv.Msg, allow []string, tsigKey string, clientIP net.IP) int {
+	if len(allow) == 0 && tsigKey == "" {
+		return dnssrv.RcodeRefused
+	}
+
+	if len(allow) > 0 {
+		allowed := false
+		for _, c := range allow {
+			_, cidr, err := net.ParseCIDR(c)
+			if err == nil && cidr.Contains(clientIP) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return dnssrv.RcodeRefused
+		}
+	}
+
+	if tsigKey != "" && !tsigValid(w, r, tsigKey) {
+		return dnssrv.RcodeNotAuth
+	}
+
+	return dnssrv.RcodeSuccess
+}
+
+// handleTransfer answers AXFR and IXFR requests, it returns false if the reply in m still needs to be send
+func handleTransfer(w dnssrv.ResponseWriter, r *dnssrv.Msg, m *dnssrv.Msg) bool {
+	q := r.Question[0]
+	domainName := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	clientIP := remoteIP(w.RemoteAddr())
+	log := logging.For("dns/server/transfer").WithField("domain", domainName).WithField("client", clientIP).WithField("type", dnssrv.TypeToString[q.Qtype])
+
+	transfer, ok := getTransfer(domainName)
+	if !ok || !localZone("", domainName) || (w.RemoteAddr().Network() != "tcp" && q.Qtype == dnssrv.TypeAXFR) {
+		log.Warn("Zone transfer refused")
+		m.SetRcode(r, dnssrv.RcodeRefused)
+		return false
+	}
+
+	if rcode := clientAllowed(w, r, transfer.Allow, transfer.TSIGKey, clientIP); rcode != dnssrv.RcodeSuccess {
+		log.WithField("rcode", dnssrv.RcodeToString[rcode]).Warn("Zone transfer refused")
+		m.SetRcode(r, rcode)
+		return false
+	}
+
+	// transfers use the serial of the records transferred, not the last known one
+	zoneSerial(domainName)
+	soa := zoneSOA(domainName)
+	if soa == nil {
+		log.Warn("Zone transfer refused, no SOA record")
+		m.SetRcode(r, dnssrv.RcodeServerFailure)
+		return false
+	}
+
+	soa.Hdr.Name = q.Name
+	records := zoneRRs(domainName)
+
+	var answer []dnssrv.RR
+	if q.Qtype == dnssrv.TypeIXFR {
+		answer = incrementalTransfer(r, soa, domainName)
+	}
+
+	// IXFR over UDP that can't be answered with a single SOA is retried by the client over TCP
+	if answer == nil && w.RemoteAddr().Network() != "tcp" {
+		m.Authoritative = true
+		m.Answer = []dnssrv.RR{soa}
+		return false
+	}
+
+	if answer == nil {
+		answer = append(append([]dnssrv.RR{soa}, records...), soa)
+		log.WithField("records", len(records)).WithField("serial", soa.Serial).Info("Sending full zone transfer")
+	} else {
+		log.WithField("records", len(answer)).WithField("serial", soa.Serial).Info("Sending incremental zone transfer")
+	}
+
+	if len(answer) == 1 || w.RemoteAddr().Network() != "tcp" {
+		m.Authoritative = true
+		m.Answer = answer
+		return false
+	}
+
+	ch := make(chan *dnssrv.Envelope)
+	tr := new(dnssrv.Transfer)
+	done := make(chan error)
+	go func() {
+		done <- tr.Out(w, r, ch)
+	}()
+
+	for len(answer) > 0 {
+		n := transferRecordsPerMessage
+		if n > len(answer) {
+			n = len(answer)
+		}
+
+		ch <- &dnssrv.Envelope{RR: answer[:n]}
+		answer = answer[n:]
+	}
+
+	close(ch)
+	if err := <-done; err != nil {
+		log.WithField("error", err).Warn("Zone transfer failed")
+	}
+
+	return true
+}
+
+// incrementalTransfer returns the IXFR answer (RFC 1995), or nil if a full transfer is required
+func incrementalTransfer(r *dnssrv.Msg, soa *dnssrv.SOA, domainName string) []dnssrv.RR {
+	var clientSOA *dnssrv.SOA
+	for _, rr := range r.Ns {
+		if s, ok := rr.(*dnssrv.SOA); ok {
+			clientSOA = s
+		}
+	}
+
+	if clientSOA == nil {
+		return nil
+	}
+
+	// client is up to date
+	if clientSOA.Serial == soa.Serial || serialNewer(clientSOA.Serial, soa.Serial) {
+		return []dnssrv.RR{soa}
+	}
+
+	removed, added, ok := zoneDiff(domainName, clientSOA.Serial)
+	if !ok {
+		return nil
+	}
+
+	oldSOA := *soa
+	oldSOA.Serial = clientSOA.Serial
+
+	answer := []dnssrv.RR{soa, &oldSOA}
+	answer = append(answer, removed...)
+	answer = append(answer, soa)
+	answer = append(answer, added...)
+	return append(answer, soa)
+}
+
+// serialNewer returns true if serial a is newer then b using serial number arithmetic (RFC 1982)
+func serialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// notifyHandler sends a NOTIFY to the secondaries of a zone when its serial changes
+func notifyHandler() {
+	ticker := time.NewTicker(notifyInterval)
+	defer ticker.Stop()
+	for {
+		dnsmanager.RLock()
+		transfers := make(map[string]Transfer)
+		for domainName, transfer := range dnsmanager.transfers {
+			if len(transfer.Notify) > 0 {
+				transfers[domainName] = transfer
+			}
+		}
+		dnsmanager.RUnlock()
+
+		for domainName, transfer := range transfers {
+			if !localZone("", domainName) {
+				continue
+			}
+
+			serial := zoneSerial(domainName)
+			notifier.Lock()
+			changed := notifier.serial[domainName] != serial
+			notifier.serial[domainName] = serial
+			notifier.Unlock()
+
+			if changed {
+				for _, target := range transfer.Notify {
+					go sendNotify(domainName, target, transfer.TSIGKey, serial)
+				}
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+// sendNotify sends a NOTIFY message (RFC 1996) for a zone to a secondary
+func sendNotify(domainName, target, tsigKey string, serial uint32) {
+	log := logging.For("dns/server/notify").WithField("domain", domainName).WithField("target", target).WithField("serial", serial)
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "53")
+	}
+
+	m := new(dnssrv.Msg)
+	m.SetNotify(dnssrv.Fqdn(domainName))
+	if soa := zoneSOA(domainName); soa != nil {
+		m.Answer = []dnssrv.RR{soa}
+	}
+
+	c := &dnssrv.Client{Net: "udp", Timeout: 5 * time.Second}
+	if tsigKey != "" {
+		c.TsigSecret = tsigSecrets()
+		m.SetTsig(dnssrv.Fqdn(strings.ToLower(tsigKey)), tsigAlgorithm(tsigKey), 300, time.Now().Unix())
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		reply, _, err := c.Exchange(m, target)
+		if err == nil && reply.Rcode == dnssrv.RcodeSuccess {
+			log.Info("Secondary notified of zone change")
+			return
+		}
+
+		if err == nil {
+			log = log.WithField("rcode", dnssrv.RcodeToString[reply.Rcode])
+		} else {
+			log = log.WithField("error", err)
+		}
+
+		log.WithField("attempt", attempt).Warn("Failed to notify secondary of zone change")
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
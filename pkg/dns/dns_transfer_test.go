@@ -0,0 +1,113 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+const transferDomain = "xfr.example"
+
+var testRecordsTransfer = []Record{
+	{UUID: "x-soa", Name: "", Type: "SOA", Target: "ns1.xfr.example. hostmaster.xfr.example. ###SERIAL### 3600 600 86400 300", TTL: 3600, Status: Online, Local: true},
+	{UUID: "x-ns", Name: "", Type: "NS", Target: "ns1.xfr.example.", TTL: 3600, Status: Online, Local: true},
+	{UUID: "x-a", Name: "www", Type: "A", Target: "127.0.2.1", TTL: 60, Status: Online, Local: true},
+}
+
+func startTestServer(t *testing.T, secrets map[string]string) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := &dnssrv.Server{Listener: listener, Net: "tcp", TsigSecret: secrets, MsgAcceptFunc: acceptMsg, Handler: dnssrv.HandlerFunc(handleDNSRequest)}
+	go server.ActivateAndServe()
+	return listener.Addr().String(), func() { server.Shutdown() }
+}
+
+func transferIn(t *testing.T, addr string, m *dnssrv.Msg, secrets map[string]string) ([]dnssrv.RR, error) {
+	tr := &dnssrv.Transfer{TsigSecret: secrets, DialTimeout: time.Second, ReadTimeout: time.Second}
+	env, err := tr.In(m, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var rrs []dnssrv.RR
+	for e := range env {
+		if e.Error != nil {
+			return rrs, e.Error
+		}
+
+		rrs = append(rrs, e.RR...)
+	}
+
+	return rrs, nil
+}
+
+func TestZoneTransfer(t *testing.T) {
+	logging.Configure("stdout", "error")
+	loadRecords("localdns", transferDomain, testRecordsTransfer)
+
+	secret := "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+	SetTSIGKeys(map[string]TSIGKey{"xfr-key": {Secret: secret, Algorithm: "hmac-sha256"}})
+	defer SetTSIGKeys(map[string]TSIGKey{})
+	UpdateTransfers(map[string]Domain{transferDomain: {Transfer: Transfer{Allow: []string{"127.0.0.0/8"}, TSIGKey: "xfr-key"}}})
+	defer UpdateTransfers(map[string]Domain{})
+
+	secrets := map[string]string{"xfr-key.": secret}
+	addr, stop := startTestServer(t, secrets)
+	defer stop()
+
+	// unsigned requests are not allowed
+	m := new(dnssrv.Msg)
+	m.SetAxfr(transferDomain + ".")
+	_, err := transferIn(t, addr, m, nil)
+	assert.NotNil(t, err)
+
+	// signed request receives the full zone
+	m = new(dnssrv.Msg)
+	m.SetAxfr(transferDomain + ".")
+	m.SetTsig("xfr-key.", dnssrv.HmacSHA256, 300, time.Now().Unix())
+	rrs, err := transferIn(t, addr, m, secrets)
+	assert.Nil(t, err)
+	assert.Len(t, rrs, 4)
+	assert.Equal(t, dnssrv.TypeSOA, rrs[0].Header().Rrtype)
+	assert.Equal(t, dnssrv.TypeSOA, rrs[len(rrs)-1].Header().Rrtype)
+	oldSerial := rrs[0].(*dnssrv.SOA).Serial
+
+	// serial only changes if the records change
+	assert.Equal(t, oldSerial, zoneSerial(transferDomain))
+	loadRecords("localdns", transferDomain, []Record{{UUID: "x-b", Name: "api", Type: "A", Target: "127.0.2.2", TTL: 60, Status: Online, Local: true}})
+	newSerial := zoneSerial(transferDomain)
+	assert.True(t, serialNewer(newSerial, oldSerial))
+
+	// incremental transfer only contains the new record
+	m = new(dnssrv.Msg)
+	m.SetIxfr(transferDomain+".", oldSerial, "ns1.xfr.example.", "hostmaster.xfr.example.")
+	m.SetTsig("xfr-key.", dnssrv.HmacSHA256, 300, time.Now().Unix())
+	rrs, err = transferIn(t, addr, m, secrets)
+	assert.Nil(t, err)
+	assert.Len(t, rrs, 5)
+	assert.Equal(t, newSerial, rrs[0].(*dnssrv.SOA).Serial)
+	assert.Equal(t, oldSerial, rrs[1].(*dnssrv.SOA).Serial)
+	assert.Equal(t, newSerial, rrs[2].(*dnssrv.SOA).Serial)
+	assert.Contains(t, rrs[3].String(), "127.0.2.2")
+
+	// up to date client receives the SOA only
+	m = new(dnssrv.Msg)
+	m.SetIxfr(transferDomain+".", newSerial, "ns1.xfr.example.", "hostmaster.xfr.example.")
+	m.SetTsig("xfr-key.", dnssrv.HmacSHA256, 300, time.Now().Unix())
+	c := &dnssrv.Client{Net: "tcp", TsigSecret: secrets}
+	reply, _, err := c.Exchange(m, addr)
+	assert.Nil(t, err)
+	assert.Len(t, reply.Answer, 1)
+
+	// transfers of zones without transfer settings are refused
+	m = new(dnssrv.Msg)
+	m.SetAxfr(domain + ".")
+	m.SetTsig("xfr-key.", dnssrv.HmacSHA256, 300, time.Now().Unix())
+	_, err = transferIn(t, addr, m, secrets)
+	assert.NotNil(t, err)
+}
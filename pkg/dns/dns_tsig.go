@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	dnssrv "github.com/miekg/dns"
+)
+
+// TSIGKey is a shared secret used to authenticate dns messages (RFC 8945)
+type TSIGKey struct {
+	Secret    string `toml:"secret" json:"-"`            // base64 encoded secret
+	Algorithm string `toml:"algorithm" json:"algorithm"` // hmac-sha1, hmac-sha256 or hmac-sha512
+}
+
+// tsigAlgorithms maps the configurable algorithm names to their dns names
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dnssrv.HmacSHA1,
+	"hmac-sha256": dnssrv.HmacSHA256,
+	"hmac-sha512": dnssrv.HmacSHA512,
+}
+
+// CheckTSIGKey returns an error if a tsig key can not be used
+func CheckTSIGKey(name string, key TSIGKey) error {
+	if _, err := base64.StdEncoding.DecodeString(key.Secret); err != nil || key.Secret == "" {
+		return fmt.Errorf("Invalid base64 secret for TSIG key %s", name)
+	}
+
+	if _, ok := tsigAlgorithms[strings.ToLower(key.Algorithm)]; !ok && key.Algorithm != "" {
+		return fmt.Errorf("Unknown algorithm %s for TSIG key %s", key.Algorithm, name)
+	}
+
+	return nil
+}
+
+// SetTSIGKeys sets the tsig keys known to the dns server, listeners are restarted on the next call to Server if the keys changed
+func SetTSIGKeys(keys map[string]TSIGKey) {
+	dnsmanager.Lock()
+	defer dnsmanager.Unlock()
+	dnsmanager.tsigKeys = keys
+}
+
+// tsigSecrets returns the secrets of all tsig keys by their fqdn
+func tsigSecrets() map[string]string {
+	dnsmanager.RLock()
+	defer dnsmanager.RUnlock()
+	return tsigSecretsLocked()
+}
+
+// tsigSecretsLocked returns the secrets of all tsig keys, the caller must hold the dnsmanager lock
+func tsigSecretsLocked() map[string]string {
+	secrets := make(map[string]string)
+	for name, key := range dnsmanager.tsigKeys {
+		secrets[dnssrv.Fqdn(strings.ToLower(name))] = key.Secret
+	}
+
+	return secrets
+}
+
+// tsigAlgorithm returns the dns name of the algorithm of a tsig key
+func tsigAlgorithm(name string) string {
+	dnsmanager.RLock()
+	defer dnsmanager.RUnlock()
+	if algorithm, ok := tsigAlgorithms[strings.ToLower(dnsmanager.tsigKeys[name].Algorithm)]; ok {
+		return algorithm
+	}
+
+	return dnssrv.HmacSHA256
+}
+
+// tsigValid returns true if the request was signed with a valid signature of the tsig key
+func tsigValid(w dnssrv.ResponseWriter, r *dnssrv.Msg, keyName string) bool {
+	tsig := r.IsTsig()
+	if tsig == nil {
+		return false
+	}
+
+	return w.TsigStatus() == nil && strings.EqualFold(tsig.Hdr.Name, dnssrv.Fqdn(keyName))
+}
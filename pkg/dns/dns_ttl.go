@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"time"
+)
+
+// defaultTTL is the ttl served for records without a ttl
+const defaultTTL = 10
+
+// degradedTTL returns the lowest degraded ttl of a record set if the set is degraded, or 0 if the normal ttl applies
+// a record set is degraded if some of its records are not online, or if a record changed status within its degraded window
+func degradedTTL(entries []indexedRecord) int {
+	ttl := 0
+	for _, entry := range entries {
+		if entry.record.DegradedTTL > 0 && (ttl == 0 || entry.record.DegradedTTL < ttl) {
+			ttl = entry.record.DegradedTTL
+		}
+	}
+
+	if ttl == 0 {
+		return 0
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.record.Status != Online {
+			return ttl
+		}
+
+		window := time.Duration(entry.record.DegradedWindow) * time.Second
+		if !entry.record.StatusChanged.IsZero() && now.Sub(entry.record.StatusChanged) < window {
+			return ttl
+		}
+	}
+
+	return 0
+}
+
+// activeTTL returns the ttl served for a record, given the degraded ttl of its record set
+func activeTTL(record Record, degraded int) int {
+	ttl := record.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+
+	if degraded > 0 && degraded < ttl {
+		return degraded
+	}
+
+	return ttl
+}
+
+// GetCacheWithTTL returns a copy of all records, with the ttl currently served for each record set in ActiveTTL
+func GetCacheWithTTL() map[string]Domains {
+	index := currentIndex()
+	dnsmanager.RLock()
+	defer dnsmanager.RUnlock()
+	d := make(map[string]Domains, len(dnsmanager.node))
+	for nodeName, node := range dnsmanager.node {
+		domains := Domains{Domains: make(map[string]Domain, len(node.Domains))}
+		for domainName, domain := range node.Domains {
+			records := make([]Record, len(domain.Records))
+			for id, record := range domain.Records {
+				record.ActiveTTL = activeTTL(record, degradedTTL(index.lookup(domainName, record.Name, record.Type)))
+				records[id] = record
+			}
+
+			domain.Records = records
+			domains.Domains[domainName] = domain
+		}
+
+		d[nodeName] = domains
+	}
+
+	return d
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDegradedTTL(t *testing.T) {
+	logging.Configure("stdout", "error")
+	loadRecords("localdns", "ttl.example", []Record{{UUID: "ttl-soa", Name: "", Type: "SOA", Target: "ns1.ttl.example. hostmaster.ttl.example. ###SERIAL### 3600 600 86400 300", TTL: 3600, Status: Online, Local: true}})
+	glb := func(node, target string, status Status) {
+		loadRecords(node, "ttl.example", []Record{{UUID: "ttl-" + node, Name: "www", Type: "A", Target: target, TTL: 60, DegradedTTL: 5, DegradedWindow: 60, Status: status}})
+	}
+
+	ttl := func() uint32 {
+		m := new(dnssrv.Msg)
+		m.SetQuestion("www.ttl.example.", dnssrv.TypeA)
+		parseQuery(m, "127.0.0.1:12345")
+		if assert.NotEmpty(t, m.Answer) {
+			return m.Answer[0].Header().Ttl
+		}
+
+		return 0
+	}
+
+	activeTTL := func(node string) int {
+		return GetCacheWithTTL()[node].Domains["ttl.example"].Records[0].ActiveTTL
+	}
+
+	// a healthy record set has the normal ttl
+	glb("ttlnode1", "127.0.12.1", Online)
+	glb("ttlnode2", "127.0.12.2", Online)
+	assert.Equal(t, uint32(60), ttl())
+	assert.Equal(t, 60, activeTTL("ttlnode1"))
+
+	// the degraded ttl is used while some targets are offline
+	glb("ttlnode2", "127.0.12.2", Offline)
+	assert.Equal(t, uint32(5), ttl())
+	assert.Equal(t, 5, activeTTL("ttlnode1"))
+
+	// and shortly after a status change
+	glb("ttlnode2", "127.0.12.2", Online)
+	assert.Equal(t, uint32(5), ttl())
+
+	// the normal ttl returns once the record set is stable
+	dnsmanager.Lock()
+	dnsmanager.node["ttlnode2"].Domains["ttl.example"].Records[0].StatusChanged = time.Now().Add(-2 * time.Minute)
+	dnsmanager.Unlock()
+	reindex("ttl.example")
+	assert.Equal(t, uint32(60), ttl())
+	assert.Equal(t, 60, activeTTL("ttlnode2"))
+
+	// marking a cluster node offline is a status change
+	MarkOffline("ttlnode2")
+	assert.Equal(t, uint32(5), ttl())
+}
@@ -2,6 +2,7 @@ package dns
 
 import (
 	"net"
+	"time"
 
 	"github.com/schubergphilis/mercury/pkg/balancer"
 	"github.com/schubergphilis/mercury/pkg/logging"
@@ -9,6 +10,7 @@ import (
 
 // addRecord adds a dns record
 func addRecord(node string, domain string, record Record) {
+	defer reindex(domain)
 	dnsmanager.Lock()
 	defer dnsmanager.Unlock()
 	// Add new record
@@ -31,14 +33,6 @@ func addRecord(node string, domain string, record Record) {
 	dnsmanager.node[node].Domains[domain] = y
 }
 
-// updateRecord updates a dns record
-func updateRecord(node string, domain string, recordid int, record Record) {
-	dnsmanager.Lock()
-	defer dnsmanager.Unlock()
-	// Update new record
-	dnsmanager.node[node].Domains[domain].Records[recordid] = record
-}
-
 // removeRecordByUUID removed a dns record
 func removeRecordByUUID(node string, uuid string) {
 	entries := GetCache()
@@ -53,6 +47,7 @@ func removeRecordByUUID(node string, uuid string) {
 
 // removeRecord removed a dns record
 func removeRecord(node string, domain string, recordid int) {
+	defer reindex(domain)
 	dnsmanager.Lock()
 	defer dnsmanager.Unlock()
 	// Remove new record
@@ -66,36 +61,11 @@ func removeRecordArray(s []Record, i int) []Record {
 	return s[:len(s)-1]
 }
 
-func createNode(name string) {
-	dnsmanager.Lock()
-	defer dnsmanager.Unlock()
-	dnsmanager.node[name] = Domains{
-		Domains: make(map[string]Domain),
-	}
-}
-
-func createDomain(node, domain string) {
-	dnsmanager.Lock()
-	defer dnsmanager.Unlock()
-	dnsmanager.node[node].Domains[domain] = Domain{
-		Records: make([]Record, 0),
-	}
-}
-
 // Update Updates a dns entry in a node
 func Update(node string, domain string, record Record) {
 	log := logging.For("dns/update").WithField("domain", domain).WithField("cluster", node).WithField("status", record.Status).WithField("name", record.Name).WithField("target", record.Target).WithField("mode", record.BalanceMode).WithField("uuid", record.UUID)
 	log.Debug("Received DNS update")
-
-	// Create cluster node entry if not there yet
-	if _, ok := dnsmanager.node[node]; !ok {
-		createNode(node)
-	}
-
-	// Create dns domains entry if not there yet
-	if _, ok := dnsmanager.node[node].Domains[domain]; !ok {
-		createDomain(node, domain)
-	}
+	defer pushChanged(domain)
 
 	// This happens if there is no config anymore when removing a update, we remove by uuid
 	if domain == "" && record.UUID != "" {
@@ -104,44 +74,99 @@ func Update(node string, domain string, record Record) {
 		return
 	}
 
+	existing, oldTarget := setRecord(node, domain, record)
+	reindex(domain)
+	if existing {
+		// We have an existing record, we updated it
+		log.WithField("oldtarget", oldTarget).Info("Updating existing DNS record")
+		if !proxyStatsEnabled() {
+			// When updating an existing record, reset the counter inorder to keep loadbalancing mechanism working (e..g round robin counters etc)
+			resetCounters(record.Name, domain, record.Type)
+		}
+
+		return
+	}
+
+	// We have a non-existing record, which is online or offline, we added it.
+	log.Warn("Adding new DNS record")
+	// When joining an existing record, reset the counter inorder to keep loadbalancing mechanism working (e..g round robin counters etc)
+	// this only matters when we have a new online records, not for offlines
+	if !proxyStatsEnabled() && record.Status == Online {
+		resetCounters(record.Name, domain, record.Type)
+	}
+}
+
+// setRecord updates the record with the same name and type in a node, or adds it if there is none
+// the lookup and the change are done under one lock, since records are also changed by dns requests such as dynamic updates
+func setRecord(node string, domain string, record Record) (existing bool, oldTarget string) {
+	dnsmanager.Lock()
+	defer dnsmanager.Unlock()
+	// Create cluster node entry if not there yet
+	if _, ok := dnsmanager.node[node]; !ok {
+		dnsmanager.node[node] = Domains{
+			Domains: make(map[string]Domain),
+		}
+	}
+
+	// Create dns domains entry if not there yet
+	y, ok := dnsmanager.node[node].Domains[domain]
+	if !ok {
+		y = Domain{
+			Records: make([]Record, 0),
+		}
+	}
+
 	existingid := -1
-	for id, rec := range dnsmanager.node[node].Domains[domain].Records {
+	for id, rec := range y.Records {
 		// match based on record name and type, anything else can be updated
 		if rec.Name == record.Name && rec.Type == record.Type {
 			existingid = id
 		}
 	}
-	if existingid >= 0 {
-		// We have an existing record, we need to update it
-		log.WithField("oldtarget", dnsmanager.node[node].Domains[domain].Records[existingid].Target).Info("Updating existing DNS record")
-		updateRecord(node, domain, existingid, record)
 
-		if dnsmanager.proxyStats == false {
-			// When updating an existing record, reset the counter inorder to keep loadbalancing mechanism working (e..g round robin counters etc)
-			resetCounters(record.Name, domain, record.Type)
+	if existingid >= 0 {
+		// keep track of the last status change, the record set is degraded shortly after
+		old := y.Records[existingid]
+		record.StatusChanged = old.StatusChanged
+		if old.Status != record.Status {
+			record.StatusChanged = time.Now()
 		}
 
-	} else {
-		// } else if record.Online {
-		// We have a non-existing record, which is online or offline, add it.
-		log.Warn("Adding new DNS record")
-		addRecord(node, domain, record)
-		// When joining an existing record, reset the counter inorder to keep loadbalancing mechanism working (e..g round robin counters etc)
-		// this only matters when we have a new online records, not for offlines
-		if dnsmanager.proxyStats == false && record.Status == Online {
-			resetCounters(record.Name, domain, record.Type)
-		}
+		y.Records[existingid] = record
+		dnsmanager.node[node].Domains[domain] = y
+		return true, old.Target
 	}
+
+	if record.Status != Online {
+		record.StatusChanged = time.Now()
+	}
+
+	y.Records = append(y.Records, record)
+	dnsmanager.node[node].Domains[domain] = y
+	return false, ""
+}
+
+// proxyStatsEnabled returns true if the proxy stats are used instead of the internal stats of dns manager
+func proxyStatsEnabled() bool {
+	dnsmanager.RLock()
+	defer dnsmanager.RUnlock()
+	return dnsmanager.proxyStats
 }
 
 // MarkOffline marks all dns entries of a node as offline
 func MarkOffline(node string) {
 	log := logging.For("dns/update/markoffline")
 	log.WithField("cluster", node).Warn("Marking all DNS records from cluster as Offline")
+	defer pushChanged("")
+	defer reindex()
 	dnsmanager.Lock()
 	defer dnsmanager.Unlock()
 	for domainName, domain := range dnsmanager.node[node].Domains {
-		for id := range domain.Records {
+		for id, record := range domain.Records {
+			if record.Status != Offline {
+				dnsmanager.node[node].Domains[domainName].Records[id].StatusChanged = time.Now()
+			}
+
 			dnsmanager.node[node].Domains[domainName].Records[id].Status = Offline
 		}
 	}
@@ -151,6 +176,8 @@ func MarkOffline(node string) {
 func Remove(node, domainName, hostName string) {
 	log := logging.For("dns/update/remove")
 	log.WithField("cluster", node).WithField("domainName", domainName).WithField("hostName", hostName).Warn("Removing DNS record")
+	defer pushChanged(domainName)
+	defer reindex(domainName)
 	dnsmanager.Lock()
 	defer dnsmanager.Unlock()
 	if _, ok := dnsmanager.node[node]; !ok {
@@ -173,6 +200,8 @@ func Remove(node, domainName, hostName string) {
 func Discard(node string) {
 	log := logging.For("dns/update/discard")
 	log.WithField("cluster", node).Warn("Discarding DNS records from cluster")
+	defer pushChanged("")
+	defer reindex()
 	dnsmanager.Lock()
 	defer dnsmanager.Unlock()
 	delete(dnsmanager.node, node)
@@ -192,15 +221,50 @@ func RemoveLocalRecord(domain string, recordid int) {
 
 // RemoveLocalRecordByContent remove content by record data
 func RemoveLocalRecordByContent(domainName string, hostName string, TTL int, Target string, Type string) {
-	records := dnsmanager.node["localdns"].Domains[domainName].Records
-	for i := len(records) - 1; i > 0; i-- {
-		if records[i].Name == hostName &&
-			records[i].TTL == TTL &&
-			records[i].Target == Target &&
-			records[i].Type == Type {
-			RemoveLocalRecord(domainName, i)
+	defer reindex(domainName)
+	dnsmanager.Lock()
+	defer dnsmanager.Unlock()
+	y, ok := dnsmanager.node["localdns"].Domains[domainName]
+	if !ok {
+		return
+	}
+
+	for i := len(y.Records) - 1; i > 0; i-- {
+		if y.Records[i].Name == hostName &&
+			y.Records[i].TTL == TTL &&
+			y.Records[i].Target == Target &&
+			y.Records[i].Type == Type {
+			y.Records = removeRecordArray(y.Records, i)
+		}
+	}
+
+	dnsmanager.node["localdns"].Domains[domainName] = y
+}
+
+// replaceLocalDomain replaces all local records of a domain
+func replaceLocalDomain(domain string, records []Record) {
+	defer reindex(domain)
+	dnsmanager.Lock()
+	defer dnsmanager.Unlock()
+	if _, ok := dnsmanager.node["localdns"]; !ok {
+		dnsmanager.node["localdns"] = Domains{
+			Domains: make(map[string]Domain),
 		}
 	}
+
+	dnsmanager.node["localdns"].Domains[domain] = Domain{
+		Records: records,
+	}
+}
+
+// removeLocalDomain removes a domain and all its local records
+func removeLocalDomain(domain string) {
+	defer reindex(domain)
+	dnsmanager.Lock()
+	defer dnsmanager.Unlock()
+	if _, ok := dnsmanager.node["localdns"]; ok {
+		delete(dnsmanager.node["localdns"].Domains, domain)
+	}
 }
 
 // GetAllLocalDomainRecords get all local records from domain
@@ -217,6 +281,7 @@ func GetAllLocalDomainRecords(domain string) []Record {
 
 // UpdateStatistics for node
 func UpdateStatistics(clusterNode string, domain string, s *balancer.Statistics) {
+	defer reindex(domain)
 	dnsmanager.Lock()
 	defer dnsmanager.Unlock()
 	if _, ok := dnsmanager.node[clusterNode].Domains[domain]; ok {
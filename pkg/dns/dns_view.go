@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// View is a set of records served to specific client networks only (split-horizon)
+type View struct {
+	Clients         []string          `toml:"clients" json:"clients"`                   // cidrs of clients that are served this view
+	AllowForwarding []string          `toml:"allow_forwarding" json:"allow_forwarding"` // cidrs of clients in this view that may forward requests
+	Domains         map[string]Domain `toml:"domains" json:"domains"`                   // records of the view, replacing the records of the same names
+}
+
+// clientView is a view with its networks parsed
+type clientView struct {
+	clients         []*net.IPNet
+	allowForwarding []*net.IPNet
+	domains         map[string][]Record
+}
+
+// viewNodePrefix is prefixed to the view name to get the node name its records are indexed under
+const viewNodePrefix = "view:"
+
+// views holds the split-horizon views
+var views = struct {
+	sync.RWMutex
+	views map[string]clientView
+}{views: make(map[string]clientView)}
+
+// CheckView validates the settings of a view
+func CheckView(name string, view View) error {
+	if len(view.Clients) == 0 {
+		return fmt.Errorf("View %s has no client networks", name)
+	}
+
+	for _, cidr := range append(append([]string{}, view.Clients...), view.AllowForwarding...) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid network for view:%s cidr:%s error:%s", name, cidr, err)
+		}
+	}
+
+	return nil
+}
+
+// UpdateViews sets the split-horizon views, and indexes their records
+func UpdateViews(settings map[string]View) {
+	log := logging.For("dns/view/update")
+	parsed := make(map[string]clientView)
+	for name, view := range settings {
+		v := clientView{domains: make(map[string][]Record)}
+		v.clients = parseNetworks(view.Clients)
+		v.allowForwarding = parseNetworks(view.AllowForwarding)
+		for domainName, domain := range view.Domains {
+			v.domains[strings.ToLower(domainName)] = append([]Record{}, domain.Records...)
+		}
+
+		parsed[name] = v
+		log.WithField("view", name).WithField("clients", view.Clients).WithField("domains", len(v.domains)).Debug("Loaded dns view")
+	}
+
+	views.Lock()
+	views.views = parsed
+	views.Unlock()
+	reindex()
+}
+
+// parseNetworks returns the valid networks of a list of cidrs
+func parseNetworks(cidrs []string) (networks []*net.IPNet) {
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return networks
+}
+
+// selectView returns the view of a client, the view with the most specific network containing the client wins
+func selectView(clientIP net.IP) string {
+	if clientIP == nil {
+		return ""
+	}
+
+	views.RLock()
+	defer views.RUnlock()
+	selected := ""
+	longest := -1
+	for name, view := range views.views {
+		for _, network := range view.clients {
+			ones, _ := network.Mask.Size()
+			if network.Contains(clientIP) && (ones > longest || (ones == longest && name < selected)) {
+				selected = name
+				longest = ones
+			}
+		}
+	}
+
+	return selected
+}
+
+// viewsEnabled returns true if any view is configured
+func viewsEnabled() bool {
+	views.RLock()
+	defer views.RUnlock()
+	return len(views.views) > 0
+}
+
+// viewRecords returns a copy of the records of all views per domain
+func viewRecords() map[string]map[string][]Record {
+	views.RLock()
+	defer views.RUnlock()
+	records := make(map[string]map[string][]Record, len(views.views))
+	for name, view := range views.views {
+		records[name] = make(map[string][]Record, len(view.domains))
+		for domainName, domainRecords := range view.domains {
+			records[name][domainName] = domainRecords
+		}
+	}
+
+	return records
+}
+
+// viewNodes returns the records of a domain served in a view: the records of the view, and the records of all cluster nodes for names the view has no records for
+func viewNodes(viewName string, nodes map[string][]Record, records []Record) map[string][]Record {
+	names := make(map[string]bool)
+	for _, record := range records {
+		names[strings.ToLower(record.Name)] = true
+	}
+
+	result := map[string][]Record{viewNodePrefix + viewName: records}
+	for nodeName, nodeRecords := range nodes {
+		for _, record := range nodeRecords {
+			if !names[strings.ToLower(record.Name)] {
+				result[nodeName] = append(result[nodeName], record)
+			}
+		}
+	}
+
+	return result
+}
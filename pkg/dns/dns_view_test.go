@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/balancer"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRecordsView = []Record{
+	{UUID: "v-soa", Name: "", Type: "SOA", Target: "ns1.view.example. hostmaster.view.example. ###SERIAL### 3600 600 86400 300", TTL: 3600, Status: Online, Local: true},
+	{UUID: "v-www", Name: "www", Type: "A", Target: "127.0.11.1", TTL: 60, Status: Online, Local: true},
+}
+
+// viewRecord returns a static record of a view
+func viewRecord(uuid, name, rtype, target string) Record {
+	return Record{UUID: uuid, Name: name, Type: rtype, Target: target, TTL: 60, Status: Online, Local: true, Statistics: balancer.NewStatistics(uuid, 0)}
+}
+
+func TestViews(t *testing.T) {
+	logging.Configure("stdout", "error")
+	loadRecords("localdns", "view.example", testRecordsView)
+	loadRecords("viewnode", "view.example", []Record{{UUID: "v-glb", Name: "app", Type: "A", Target: "127.0.11.2", TTL: 30, Status: Online}})
+
+	UpdateViews(map[string]View{
+		"internal": {
+			Clients:         []string{"10.0.0.0/8"},
+			AllowForwarding: []string{"10.0.0.0/8"},
+			Domains: map[string]Domain{
+				"view.example":     {Records: []Record{viewRecord("vi-www", "www", "A", "10.1.1.1")}},
+				"internal.example": {Records: []Record{viewRecord("vi-soa", "", "SOA", "ns1.internal.example. hostmaster.internal.example. 1 3600 600 86400 300"), viewRecord("vi-host", "host", "A", "10.2.2.2")}},
+			},
+		},
+		"lab": {Clients: []string{"10.9.0.0/16"}},
+	})
+	defer UpdateViews(map[string]View{})
+
+	query := func(client, name string) (*dnssrv.Msg, int) {
+		m := new(dnssrv.Msg)
+		m.SetQuestion(name, dnssrv.TypeA)
+		rcode, _ := parseQuery(m, net.JoinHostPort(client, "12345"))
+		return m, rcode
+	}
+
+	// the most specific view of the client network is selected
+	assert.Equal(t, "internal", selectView(net.ParseIP("10.1.2.3")))
+	assert.Equal(t, "lab", selectView(net.ParseIP("10.9.1.1")))
+	assert.Equal(t, "", selectView(net.ParseIP("192.0.2.1")))
+
+	// records of the view replace the records of the same name
+	m, _ := query("10.1.2.3", "www.view.example.")
+	assert.True(t, answerCount(m, 1))
+	assert.True(t, answerTarget(m, "10.1.1.1"))
+	m, _ = query("192.0.2.1", "www.view.example.")
+	assert.True(t, answerCount(m, 1))
+	assert.True(t, answerTarget(m, "127.0.11.1"))
+	m, _ = query("10.9.1.1", "www.view.example.")
+	assert.True(t, answerTarget(m, "127.0.11.1"))
+
+	// other names, GLB records included, are served in all views
+	m, _ = query("10.1.2.3", "app.view.example.")
+	assert.True(t, answerTarget(m, "127.0.11.2"))
+
+	// domains of a view are only served to its clients
+	m, rcode := query("10.1.2.3", "host.internal.example.")
+	assert.Equal(t, dnssrv.RcodeSuccess, rcode)
+	assert.True(t, answerTarget(m, "10.2.2.2"))
+	_, rcode = query("192.0.2.1", "host.internal.example.")
+	assert.Equal(t, dnssrv.RcodeRefused, rcode)
+
+	// views have their own forwarding policy
+	assert.True(t, allowedToForward("internal", net.ParseIP("10.1.2.3")))
+	assert.False(t, allowedToForward("lab", net.ParseIP("10.9.1.1")))
+
+	// the client subnet of a trusted resolver selects the view
+	TrustClientSubnet([]string{"192.168.0.0/24"})
+	defer TrustClientSubnet([]string{})
+	r := new(dnssrv.Msg)
+	r.SetQuestion("www.view.example.", dnssrv.TypeA)
+	r.SetEdns0(4096, false)
+	r.IsEdns0().Option = append(r.IsEdns0().Option, &dnssrv.EDNS0_SUBNET{Code: dnssrv.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("10.1.2.0").To4()})
+	w := &testResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 12345}}
+	handleDNSRequest(w, r)
+	assert.True(t, answerTarget(w.msg, "10.1.1.1"))
+	if ecs := replyClientSubnet(w.msg); assert.NotNil(t, ecs) {
+		assert.Equal(t, uint8(24), ecs.SourceScope)
+	}
+
+	assert.NotNil(t, CheckView("empty", View{}))
+	assert.NotNil(t, CheckView("invalid", View{Clients: []string{"10.0.0.0/33"}}))
+	assert.Nil(t, CheckView("internal", View{Clients: []string{"10.0.0.0/8"}}))
+}
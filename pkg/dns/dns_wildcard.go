@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"strings"
+
+	dnssrv "github.com/miekg/dns"
+)
+
+// splitZone splits a fqdn in the host name and the closest zone of a view it is part of, names outside our zones are split at the first label
+func splitZone(view, name string) (hostName string, domainName string) {
+	labels := dnssrv.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		if localZone(view, strings.Join(labels[i:], ".")) {
+			return strings.Join(labels[:i], "."), strings.Join(labels[i:], ".")
+		}
+	}
+
+	return strings.Join(labels[:1], "."), strings.Join(labels[1:], ".")
+}
+
+// nameExists returns true if a name has records, or has names with records below it (an empty non-terminal)
+func nameExists(view, hostName, domainName string) bool {
+	d := currentIndex().view(view).domain(domainName)
+	return d != nil && d.exists[strings.ToLower(hostName)]
+}
+
+// emptyNonTerminal returns true if a name has no records, but there are names with records below it
+func emptyNonTerminal(view, hostName, domainName string) bool {
+	return hostName != "" && !nameInUse(view, strings.ToLower(domainName), strings.ToLower(hostName)) && nameExists(view, hostName, domainName)
+}
+
+// wildcardName returns the wildcard that answers a name which does not exist, the wildcard of its closest encloser (RFC 4592 section 3.3.1)
+func wildcardName(view, hostName, domainName string) (string, bool) {
+	if hostName == "" || nameExists(view, hostName, domainName) {
+		return "", false
+	}
+
+	labels := strings.Split(strings.ToLower(hostName), ".")
+	for i := 1; i <= len(labels); i++ {
+		encloser := strings.Join(labels[i:], ".")
+		if !nameExists(view, encloser, domainName) {
+			continue
+		}
+
+		// only the wildcard directly below the closest encloser can answer, even if it does not exist
+		wildcard := "*"
+		if encloser != "" {
+			wildcard = "*." + encloser
+		}
+
+		if nameInUse(view, strings.ToLower(domainName), wildcard) {
+			return wildcard, true
+		}
+
+		return "", false
+	}
+
+	return "", false
+}
@@ -0,0 +1,81 @@
+package dns
+
+import (
+	"testing"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRecordsWildcard = []Record{
+	{UUID: "w-soa", Name: "", Type: "SOA", Target: "ns1.wild.example. hostmaster.wild.example. ###SERIAL### 3600 600 86400 300", TTL: 3600, Status: Online, Local: true},
+	{UUID: "w-any", Name: "*", Type: "A", Target: "127.0.7.1", TTL: 60, Status: Online, Local: true},
+	{UUID: "w-www", Name: "www", Type: "A", Target: "127.0.7.2", TTL: 60, Status: Online, Local: true},
+	{UUID: "w-sub", Name: "host.sub", Type: "A", Target: "127.0.7.3", TTL: 60, Status: Online, Local: true},
+	{UUID: "w-txt", Name: "txt", Type: "TXT", Target: "\"only\"", TTL: 60, Status: Online, Local: true},
+}
+
+var testRecordsWildcardGLB = []Record{
+	{UUID: "w-glb", Name: "*.tenant", Type: "A", Target: "127.0.7.4", TTL: 30, Status: Online},
+}
+
+func TestWildcard(t *testing.T) {
+	logging.Configure("stdout", "error")
+	loadRecords("localdns", "wild.example", testRecordsWildcard)
+	loadRecords("wildnode", "wild.example", testRecordsWildcardGLB)
+
+	query := func(name string) (*dnssrv.Msg, int) {
+		m := new(dnssrv.Msg)
+		m.SetQuestion(name, dnssrv.TypeA)
+		rcode, _ := parseQuery(m, "127.0.0.1:12345")
+		return m, rcode
+	}
+
+	// names that do not exist are answered by the wildcard, with the requested name as owner
+	m, rcode := query("a.wild.example.")
+	assert.Equal(t, dnssrv.RcodeSuccess, rcode)
+	if assert.True(t, answerCount(m, 1)) {
+		assert.Equal(t, "a.wild.example.", m.Answer[0].Header().Name)
+		assert.True(t, answerTarget(m, "127.0.7.1"))
+	}
+
+	m, _ = query("a.b.wild.example.")
+	assert.True(t, answerTarget(m, "127.0.7.1"))
+
+	// exact names win over the wildcard
+	m, _ = query("www.wild.example.")
+	assert.True(t, answerCount(m, 1))
+	assert.True(t, answerTarget(m, "127.0.7.2"))
+	m, _ = query("host.sub.wild.example.")
+	assert.True(t, answerTarget(m, "127.0.7.3"))
+
+	// names with other record types are not answered by the wildcard
+	m, _ = query("txt.wild.example.")
+	assert.True(t, answerCount(m, 0))
+
+	// an empty non-terminal exists, and is not answered by the wildcard, neither are names below it
+	m, rcode = query("sub.wild.example.")
+	assert.Equal(t, dnssrv.RcodeSuccess, rcode)
+	assert.True(t, answerCount(m, 0))
+	m, _ = query("other.sub.wild.example.")
+	assert.True(t, answerCount(m, 0))
+
+	// wildcard GLB records of the cluster
+	m, _ = query("acme.tenant.wild.example.")
+	if assert.True(t, answerCount(m, 1)) {
+		assert.Equal(t, "acme.tenant.wild.example.", m.Answer[0].Header().Name)
+		assert.True(t, answerTarget(m, "127.0.7.4"))
+	}
+
+	// the wildcard itself is not changed by answering it
+	assert.Equal(t, []string{"*"}, recordNames(getAllRecords("", "*", "wild.example", "A")))
+}
+
+func recordNames(records []Record) (names []string) {
+	for _, record := range records {
+		names = append(names, record.Name)
+	}
+
+	return names
+}
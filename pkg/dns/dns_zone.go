@@ -0,0 +1,176 @@
+package dns
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	dnssrv "github.com/miekg/dns"
+)
+
+// maxZoneVersions is the amount of previous zone versions kept for incremental transfers
+const maxZoneVersions = 10
+
+// zoneVersion is the content of a zone at a specific serial
+type zoneVersion struct {
+	serial  uint32
+	hash    string
+	records []string
+}
+
+// zoneVersions holds the recent versions of each zone
+var zoneVersions = struct {
+	sync.Mutex
+	zones map[string][]zoneVersion
+}{zones: make(map[string][]zoneVersion)}
+
+// recordRR converts a record to a resource record
+func recordRR(domainName string, record Record) (dnssrv.RR, error) {
+	if record.TTL == 0 {
+		record.TTL = 10
+	}
+
+	owner := strings.TrimSuffix(domainName, ".") + "."
+	if record.Name != "" {
+		owner = record.Name + "." + owner
+	}
+
+	return dnssrv.NewRR(fmt.Sprintf("%s %d %s %s", owner, record.TTL, record.Type, recordData(record.Type, record.Target)))
+}
+
+// zoneRRs returns all records of a zone except the SOA, as they would be served to clients
+func zoneRRs(domainName string) []dnssrv.RR {
+	searchDomain := strings.ToLower(domainName)
+	d := currentIndex().domain(searchDomain)
+	if d == nil {
+		return nil
+	}
+
+	var names []string
+	for name := range d.types {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	var rrs []dnssrv.RR
+	added := make(map[string]bool)
+	for _, name := range names {
+		types := append([]string{}, d.types[name]...)
+		sort.Strings(types)
+		for _, rtype := range types {
+			if rtype == "SOA" {
+				continue
+			}
+
+			for _, record := range getAllRecords("", name, searchDomain, rtype) {
+				rr, err := recordRR(searchDomain, record)
+				if err != nil || added[rr.String()] {
+					continue
+				}
+
+				added[rr.String()] = true
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+
+	return rrs
+}
+
+// zoneSerial returns the serial of a zone, which is increased each time the records served for the zone change
+func zoneSerial(domainName string) uint32 {
+	searchDomain := strings.ToLower(domainName)
+	var records []string
+	for _, rr := range zoneRRs(searchDomain) {
+		records = append(records, rr.String())
+	}
+
+	sort.Strings(records)
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(records, "\n"))))
+
+	zoneVersions.Lock()
+	defer zoneVersions.Unlock()
+	versions := zoneVersions.zones[searchDomain]
+	if len(versions) > 0 && versions[len(versions)-1].hash == hash {
+		return versions[len(versions)-1].serial
+	}
+
+	serial := nextSerial(searchDomain, hash)
+	addZoneVersion(searchDomain, zoneVersion{serial: serial, hash: hash, records: records})
+	return serial
+}
+
+// currentZoneSerial returns the last known serial of a zone, which the serial handler keeps up to date
+// only the first request of a zone calculates it, since that requires all records of the zone
+func currentZoneSerial(domainName string) uint32 {
+	searchDomain := strings.ToLower(domainName)
+	zoneVersions.Lock()
+	versions := zoneVersions.zones[searchDomain]
+	zoneVersions.Unlock()
+	if len(versions) > 0 {
+		return versions[len(versions)-1].serial
+	}
+
+	return zoneSerial(searchDomain)
+}
+
+// addZoneVersion adds a version of a zone, and removes the oldest versions, the caller must hold the zoneVersions lock
+func addZoneVersion(domainName string, version zoneVersion) {
+	versions := append(zoneVersions.zones[domainName], version)
+	if len(versions) > maxZoneVersions {
+		versions = versions[len(versions)-maxZoneVersions:]
+	}
+
+	zoneVersions.zones[domainName] = versions
+}
+
+// zoneDiff returns the records removed and added since a previous serial, ok is false if the serial is no longer known
+func zoneDiff(domainName string, serial uint32) (removed []dnssrv.RR, added []dnssrv.RR, ok bool) {
+	searchDomain := strings.ToLower(domainName)
+	zoneVersions.Lock()
+	versions := zoneVersions.zones[searchDomain]
+	zoneVersions.Unlock()
+
+	if len(versions) == 0 {
+		return nil, nil, false
+	}
+
+	var old *zoneVersion
+	for i := range versions {
+		if versions[i].serial == serial {
+			old = &versions[i]
+		}
+	}
+
+	if old == nil {
+		return nil, nil, false
+	}
+
+	current := versions[len(versions)-1]
+	oldRecords := make(map[string]bool)
+	for _, r := range old.records {
+		oldRecords[r] = true
+	}
+
+	newRecords := make(map[string]bool)
+	for _, r := range current.records {
+		newRecords[r] = true
+		if !oldRecords[r] {
+			if rr, err := dnssrv.NewRR(r); err == nil {
+				added = append(added, rr)
+			}
+		}
+	}
+
+	for _, r := range old.records {
+		if !newRecords[r] {
+			if rr, err := dnssrv.NewRR(r); err == nil {
+				removed = append(removed, rr)
+			}
+		}
+	}
+
+	return removed, added, true
+}
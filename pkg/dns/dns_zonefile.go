@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	dnssrv "github.com/miekg/dns"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// generatedTypes are record types that are created by the DNSSEC signer, and are not read from zone files
+var generatedTypes = map[uint16]bool{
+	dnssrv.TypeRRSIG:      true,
+	dnssrv.TypeNSEC:       true,
+	dnssrv.TypeNSEC3:      true,
+	dnssrv.TypeNSEC3PARAM: true,
+}
+
+// ReadZoneFile reads the records of a domain from a RFC 1035 master file, $ORIGIN, $TTL and $INCLUDE are supported
+func ReadZoneFile(domainName, file string) ([]Record, error) {
+	log := logging.For("dns/zonefile").WithField("domain", domainName).WithField("file", file)
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	origin := dnssrv.Fqdn(strings.ToLower(domainName))
+	zp := dnssrv.NewZoneParser(f, origin, file)
+	zp.SetIncludeAllowed(true)
+
+	var records []Record
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		hdr := rr.Header()
+		if generatedTypes[hdr.Rrtype] {
+			log.WithField("name", hdr.Name).WithField("type", dnssrv.TypeToString[hdr.Rrtype]).Debug("Skipping generated DNSSEC record")
+			continue
+		}
+
+		owner := strings.ToLower(hdr.Name)
+		if !dnssrv.IsSubDomain(origin, owner) {
+			return nil, fmt.Errorf("Record %s is not part of domain %s", hdr.Name, domainName)
+		}
+
+		record := Record{
+			Name:   strings.TrimSuffix(hdr.Name[:len(hdr.Name)-len(origin)], "."),
+			Type:   dnssrv.TypeToString[hdr.Rrtype],
+			Target: strings.TrimPrefix(rr.String(), hdr.String()),
+			TTL:    int(hdr.Ttl),
+			Status: Online,
+			Local:  true,
+		}
+
+		// the serial is managed by mercury, so secondaries see changes of GLB records
+		if soa, ok := rr.(*dnssrv.SOA); ok {
+			record.Target = fmt.Sprintf("%s %s ###SERIAL### %d %d %d %d", soa.Ns, soa.Mbox, soa.Refresh, soa.Retry, soa.Expire, soa.Minttl)
+		}
+
+		records = append(records, record)
+	}
+
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	log.WithField("records", len(records)).Debug("Read zone file")
+	return records, nil
+}
+
+// ZoneFile returns the records currently served for a domain, GLB records included, as a RFC 1035 master file
+func ZoneFile(domainName string) (string, error) {
+	searchDomain := strings.ToLower(strings.TrimSuffix(domainName, "."))
+	if !localZone("", searchDomain) {
+		return "", fmt.Errorf("Domain %s is not served by this node", domainName)
+	}
+
+	var zone strings.Builder
+	fmt.Fprintf(&zone, "$ORIGIN %s\n", dnssrv.Fqdn(searchDomain))
+	if soa := zoneSOA(searchDomain); soa != nil {
+		fmt.Fprintln(&zone, soa.String())
+	}
+
+	for _, rr := range zoneRRs(searchDomain) {
+		fmt.Fprintln(&zone, rr.String())
+	}
+
+	return zone.String(), nil
+}
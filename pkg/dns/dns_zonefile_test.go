@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+const testZoneFile = `$ORIGIN zonefile.example.
+$TTL 300
+@	IN	SOA	ns1 hostmaster 2020010101 3600 600 86400 60
+	IN	NS	ns1
+ns1	IN	A	127.0.9.1
+www	120	IN	A	127.0.9.2
+$INCLUDE mail.zone
+`
+
+const testZoneFileInclude = `mail	IN	MX	10 mx.zonefile.example.
+$ORIGIN sub.zonefile.example.
+host	IN	TXT	"included"
+`
+
+func TestZoneFile(t *testing.T) {
+	logging.Configure("stdout", "error")
+	dir, err := ioutil.TempDir("", "zonefile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "zonefile.example.zone")
+	assert.Nil(t, ioutil.WriteFile(file, []byte(testZoneFile), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "mail.zone"), []byte(testZoneFileInclude), 0644))
+
+	records, err := ReadZoneFile("zonefile.example", file)
+	assert.Nil(t, err)
+	if !assert.Len(t, records, 6) {
+		return
+	}
+
+	// names are relative to the domain, the serial is managed by mercury
+	assert.Equal(t, Record{Name: "", Type: "SOA", Target: "ns1.zonefile.example. hostmaster.zonefile.example. ###SERIAL### 3600 600 86400 60", TTL: 300, Status: Online, Local: true}, records[0])
+	assert.Equal(t, "ns1.zonefile.example.", records[1].Target)
+	assert.Equal(t, 120, records[3].TTL)
+	assert.Equal(t, "mail", records[4].Name)
+	assert.Equal(t, "host.sub", records[5].Name)
+	assert.Equal(t, "\"included\"", records[5].Target)
+
+	// records outside the domain are refused
+	_, err = ReadZoneFile("other.example", file)
+	assert.NotNil(t, err)
+
+	// the export includes the GLB records of the cluster
+	loadRecords("localdns", "zonefile.example", records)
+	loadRecords("zonefilenode", "zonefile.example", []Record{{UUID: "zf-glb", Name: "glb", Type: "A", Target: "127.0.9.3", TTL: 30, Status: Online}})
+	zone, err := ZoneFile("zonefile.example.")
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(zone), "\n")
+	assert.Equal(t, "$ORIGIN zonefile.example.", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "zonefile.example.\t300\tIN\tSOA\tns1.zonefile.example. hostmaster.zonefile.example. "))
+	assert.Contains(t, zone, "glb.zonefile.example.\t30\tIN\tA\t127.0.9.3\n")
+	assert.Contains(t, zone, "host.sub.zonefile.example.\t300\tIN\tTXT\t\"included\"\n")
+
+	// exported zones can be read again
+	assert.Nil(t, ioutil.WriteFile(file, []byte(zone), 0644))
+	exported, err := ReadZoneFile("zonefile.example", file)
+	assert.Nil(t, err)
+	assert.Len(t, exported, 7)
+
+	_, err = ZoneFile("unknown.example")
+	assert.NotNil(t, err)
+}
@@ -22,6 +22,7 @@ type Config struct {
 	PoolName     *string
 	DNSName      *string
 	ClusterOnly  *bool
+	DNSSECDS     *bool
 }
 
 var (
@@ -43,6 +44,7 @@ func Init() {
 		PoolName:     flag.String("pool-name", "", "only check selected pool name"),
 		DNSName:      flag.String("dns-name", "", "only check selected dns name"),
 		ClusterOnly:  flag.Bool("cluster-only", false, "only check cluster"),
+		DNSSECDS:     flag.Bool("dnssec-ds", false, "print the DS records of DNSSEC signed domains for the parent zone"),
 	}
 	flag.Parse()
 	config = &c
@@ -25,6 +25,17 @@ type ACL struct {
 	StatusCode     int      `json:"status_code" toml:"status_code"`         // status code
 	URLPath        string   `json:"url_path" toml:"url_path"`               // request path to match this acl if provided
 	CIDRS          []string `json:"cidrs" toml:"cidrs"`                     // network cidr
+
+	ClientCertCN          string   `json:"clientcert_cn" toml:"clientcert_cn"`                   // client certificate subject common name (regex)
+	ClientCertSAN         string   `json:"clientcert_san" toml:"clientcert_san"`                 // client certificate subject alternative name (regex)
+	ClientCertIssuer      string   `json:"clientcert_issuer" toml:"clientcert_issuer"`           // client certificate issuer (regex)
+	ClientCertFingerprint []string `json:"clientcert_fingerprint" toml:"clientcert_fingerprint"` // client certificate sha256 fingerprints
+
+	JWTSecrets      []string          `json:"jwt_secrets" toml:"jwt_secrets"`             // HMAC secrets to verify the token signature with
+	JWTJWKSFile     string            `json:"jwt_jwks_file" toml:"jwt_jwks_file"`         // JWKS file with keys to verify the token signature with
+	JWTIssuer       string            `json:"jwt_issuer" toml:"jwt_issuer"`               // required token issuer
+	JWTAudience     []string          `json:"jwt_audience" toml:"jwt_audience"`           // accepted token audiences
+	JWTClaimHeaders map[string]string `json:"jwt_claim_headers" toml:"jwt_claim_headers"` // claims to copy to request headers (claim = header)
 }
 
 // ACLS contains a list of ACL
@@ -51,6 +62,8 @@ func (d *duration) UnmarshalJSON(text []byte) (err error) {
 const (
 	headerMatch  = "header"
 	cookieMatch  = "cookie"
+	certMatch    = "clientcert"
+	jwtMatch     = "jwt"
 	statusMatch  = "status"
 	rewriteMatch = "rewrite"
 	addMatch     = "add"
@@ -79,7 +92,19 @@ func (acl ACL) ProcessRequest(req *http.Request) (deny bool) {
 	case cookieMatch:
 		return acl.processCookie(&req.Header, nil, "Cookie")
 
+	case certMatch:
+		if !acl.processClientCert(req) {
+			return false
+		}
+
+		fallthrough
+
 	default: // always executed
+		// client certificate fields are a precondition, the other fields are only processed if the certificate matches
+		if acl.hasClientCertCondition() && !acl.processClientCert(req) {
+			return false
+		}
+
 		if acl.URLMatch != "" {
 			return acl.processURI(req)
 		}
@@ -95,8 +120,10 @@ func (acl ACL) ProcessRequest(req *http.Request) (deny bool) {
 		if len(acl.CIDRS) > 0 {
 			return acl.processCIDR(req.RemoteAddr)
 		}
+
+		// an acl with only client certificate fields matches on the certificate
+		return acl.ConditionType == certMatch || acl.hasClientCertCondition()
 	}
-	return false
 }
 
 // ProcessResponse processes ACL's for response
@@ -200,5 +227,11 @@ func (acl ACL) String() string {
 	if len(acl.CIDRS) > 0 {
 		output += fmt.Sprintf(" CIDRS:%v", acl.CIDRS)
 	}
+	if acl.Action == jwtMatch {
+		output += fmt.Sprintf(" Type:JWT Issuer:%s Audience:%v JWKS:%s Secrets:%d ClaimHeaders:%v", acl.JWTIssuer, acl.JWTAudience, acl.JWTJWKSFile, len(acl.JWTSecrets), acl.JWTClaimHeaders)
+	}
+	if acl.hasClientCertCondition() {
+		output += fmt.Sprintf(" Type:ClientCert CN:%s SAN:%s Issuer:%s Fingerprint:%v", acl.ClientCertCN, acl.ClientCertSAN, acl.ClientCertIssuer, acl.ClientCertFingerprint)
+	}
 	return output
 }
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// hasClientCertCondition returns true if the acl matches on client certificate fields
+func (acl ACL) hasClientCertCondition() bool {
+	return acl.ClientCertCN != "" || acl.ClientCertSAN != "" || acl.ClientCertIssuer != "" || len(acl.ClientCertFingerprint) > 0
+}
+
+// processClientCert returns true if the client certificate matches all configured certificate conditions
+func (acl ACL) processClientCert(req *http.Request) (match bool) {
+	log := logging.For("proxy/aclclientcert")
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		log.Debug("No client certificate presented")
+		return false
+	}
+
+	cert := req.TLS.PeerCertificates[0]
+
+	if acl.ClientCertCN != "" && !matchCertRegex(acl.ClientCertCN, []string{cert.Subject.CommonName}) {
+		return false
+	}
+
+	if acl.ClientCertSAN != "" && !matchCertRegex(acl.ClientCertSAN, certificateSANs(cert)) {
+		return false
+	}
+
+	if acl.ClientCertIssuer != "" && !matchCertRegex(acl.ClientCertIssuer, []string{cert.Issuer.CommonName, cert.Issuer.String()}) {
+		return false
+	}
+
+	if len(acl.ClientCertFingerprint) > 0 && !matchFingerprint(acl.ClientCertFingerprint, certificateFingerprint(cert)) {
+		return false
+	}
+
+	log.WithField("cn", cert.Subject.CommonName).WithField("issuer", cert.Issuer.CommonName).Debug("Client certificate matched acl")
+	return true
+}
+
+// matchCertRegex returns true if any of the values matches the case insensitive regex
+func matchCertRegex(match string, values []string) bool {
+	log := logging.For("proxy/matchcertregex")
+	reg, err := regexp.Compile("(?i)" + match)
+	if err != nil {
+		log.WithField("match", match).WithError(err).Warn("Invalid regex while matching client certificate")
+		return false
+	}
+
+	for _, value := range values {
+		if reg.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// certificateSANs returns all subject alternative names of a certificate
+func certificateSANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	return sans
+}
+
+// certificateFingerprint returns the sha256 fingerprint of a certificate in lowercase hex
+func certificateFingerprint(cert *x509.Certificate) string {
+	return fmt.Sprintf("%x", sha256.Sum256(cert.Raw))
+}
+
+// matchFingerprint returns true if the fingerprint is in the list, colons and case are ignored
+func matchFingerprint(fingerprints []string, fingerprint string) bool {
+	for _, f := range fingerprints {
+		if strings.EqualFold(strings.Replace(f, ":", "", -1), fingerprint) {
+			return true
+		}
+	}
+
+	return false
+}
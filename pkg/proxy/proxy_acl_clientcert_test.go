@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func testClientCertificate(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "client1.example.com"},
+		Issuer:       pkix.Name{CommonName: "client1.example.com"},
+		DNSNames:     []string{"client1.example.com", "alt.example.com"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert
+}
+
+func TestClientCertACL(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	cert := testClientCertificate(t)
+	req := httptest.NewRequest("GET", "https://example.com/foo", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	assert.True(t, ACL{ClientCertCN: `^client[0-9]+\.example\.com$`}.ProcessRequest(req))
+	assert.False(t, ACL{ClientCertCN: `^server`}.ProcessRequest(req))
+	assert.True(t, ACL{ClientCertSAN: `^alt\.`}.ProcessRequest(req))
+	assert.True(t, ACL{ClientCertCN: "client1", ClientCertIssuer: "CLIENT1"}.ProcessRequest(req))
+	assert.False(t, ACL{ClientCertCN: "client1", ClientCertIssuer: "other ca"}.ProcessRequest(req))
+
+	fingerprint := certificateFingerprint(cert)
+	assert.True(t, ACL{ClientCertFingerprint: []string{"aa:bb", fingerprint}}.ProcessRequest(req))
+	assert.False(t, ACL{ClientCertFingerprint: []string{"aa:bb"}}.ProcessRequest(req))
+
+	// no client certificate never matches
+	plain := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	assert.False(t, ACL{ClientCertCN: ".*"}.ProcessRequest(plain))
+
+	// other fields are processed if the certificate matches
+	add := ACL{Action: "add", ClientCertCN: "client1", HeaderKey: "X-Client-Verified", HeaderValue: "yes"}
+	add.ProcessRequest(req)
+	assert.Equal(t, "yes", req.Header.Get("X-Client-Verified"))
+	add.ProcessRequest(plain)
+	assert.Equal(t, "", plain.Header.Get("X-Client-Verified"))
+
+	remove := ACL{Action: "remove", ConditionType: "clientcert", ClientCertCN: "client1", HeaderKey: "X-Client-Verified"}
+	remove.ProcessRequest(req)
+	assert.Equal(t, "", req.Header.Get("X-Client-Verified"))
+
+	assert.True(t, ACL{Action: "deny", ClientCertCN: "client1", URLMatch: "^/foo"}.ProcessRequest(req))
+	assert.False(t, ACL{Action: "deny", ClientCertCN: "client1", URLMatch: "^/bar"}.ProcessRequest(req))
+}
@@ -0,0 +1,341 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// jwtKey is a key usable to verify a JWT signature
+type jwtKey struct {
+	kid string
+	key interface{}
+}
+
+// jwk is a single JSON Web Key as found in a JWKS file
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// jwksFile is a cached JWKS file, reloaded when the file changes
+type jwksFile struct {
+	modTime time.Time
+	keys    []jwtKey
+}
+
+var jwksCache = struct {
+	sync.RWMutex
+	files map[string]jwksFile
+}{files: make(map[string]jwksFile)}
+
+// processJWT validates the bearer token of the request, and returns the status code to reply with if its not valid
+func (acl ACL) processJWT(req *http.Request) (statusCode int, err error) {
+	log := logging.For("proxy/acljwt")
+
+	// never trust claim headers supplied by the client, also not on paths that are not validated
+	for _, header := range acl.JWTClaimHeaders {
+		req.Header.Del(header)
+	}
+
+	// If we have a request path, only validate requests matching it
+	if acl.URLPath != "" && req.URL != nil {
+		regex, _ := regexp.Compile(acl.URLPath)
+		if regex.MatchString(req.URL.Path) == false {
+			return 0, nil
+		}
+	}
+
+	auth := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(auth) != 2 || !strings.EqualFold(auth[0], "bearer") || auth[1] == "" {
+		return http.StatusUnauthorized, fmt.Errorf("No bearer token in request")
+	}
+
+	claims, err := acl.parseJWT(strings.TrimSpace(auth[1]))
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	if acl.JWTIssuer != "" && !claims.VerifyIssuer(acl.JWTIssuer, true) {
+		return http.StatusForbidden, fmt.Errorf("Token issuer %v does not match %s", claims["iss"], acl.JWTIssuer)
+	}
+
+	if len(acl.JWTAudience) > 0 && !matchJWTAudience(claims["aud"], acl.JWTAudience) {
+		return http.StatusForbidden, fmt.Errorf("Token audience %v does not match %v", claims["aud"], acl.JWTAudience)
+	}
+
+	for claim, header := range acl.JWTClaimHeaders {
+		if value, ok := claims[claim]; ok {
+			req.Header.Set(header, jwtClaimString(value))
+		}
+	}
+
+	log.WithField("sub", claims["sub"]).WithField("iss", claims["iss"]).Debug("JWT token accepted")
+	return 0, nil
+}
+
+// parseJWT verifies the signature and expiry of a token, and returns its claims
+func (acl ACL) parseJWT(raw string) (jwt.MapClaims, error) {
+	parser := &jwt.Parser{}
+	unverified, _, err := parser.ParseUnverified(raw, jwt.MapClaims{})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse token: %s", err)
+	}
+
+	keys, err := acl.jwtKeys(unverified)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No key available for signing method:%v kid:%v", unverified.Header["alg"], unverified.Header["kid"])
+	}
+
+	for _, key := range keys {
+		token, err := parser.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+			return key.key, nil
+		})
+
+		if err != nil {
+			if verr, ok := err.(*jwt.ValidationError); ok && verr.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+				continue
+			}
+
+			return nil, fmt.Errorf("Invalid token: %s", err)
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok || !token.Valid {
+			return nil, fmt.Errorf("Invalid token claims")
+		}
+
+		if _, ok := claims["exp"]; !ok {
+			return nil, fmt.Errorf("Token has no expiry")
+		}
+
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("Invalid token: %s", jwt.ErrSignatureInvalid)
+}
+
+// jwtKeys returns the configured keys that can verify the signing method (and key id) of the token
+func (acl ACL) jwtKeys(token *jwt.Token) ([]jwtKey, error) {
+	var available []jwtKey
+	for _, secret := range acl.JWTSecrets {
+		available = append(available, jwtKey{key: []byte(secret)})
+	}
+
+	if acl.JWTJWKSFile != "" {
+		keys, err := loadJWKS(acl.JWTJWKSFile)
+		if err != nil {
+			return nil, err
+		}
+
+		available = append(available, keys...)
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	var keys []jwtKey
+	for _, key := range available {
+		if kid != "" && key.kid != "" && key.kid != kid {
+			continue
+		}
+
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if _, ok := key.key.([]byte); ok {
+				keys = append(keys, key)
+			}
+
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			if _, ok := key.key.(*rsa.PublicKey); ok {
+				keys = append(keys, key)
+			}
+
+		case *jwt.SigningMethodECDSA:
+			if _, ok := key.key.(*ecdsa.PublicKey); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// loadJWKS returns the keys of a JWKS file, the file is only read again if it changed
+func loadJWKS(file string) ([]jwtKey, error) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot access JWKS file:%s error:%s", file, err)
+	}
+
+	jwksCache.RLock()
+	cached, ok := jwksCache.files[file]
+	jwksCache.RUnlock()
+	if ok && cached.modTime.Equal(stat.ModTime()) {
+		return cached.keys, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read JWKS file:%s error:%s", file, err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse JWKS file:%s error:%s", file, err)
+	}
+
+	jwksCache.Lock()
+	jwksCache.files[file] = jwksFile{modTime: stat.ModTime(), keys: keys}
+	jwksCache.Unlock()
+
+	logging.For("proxy/acljwt").WithField("file", file).WithField("keys", len(keys)).Debug("Loaded JWKS file")
+	return keys, nil
+}
+
+// parseJWKS converts a JWKS document to verification keys, unsupported keys are skipped
+func parseJWKS(data []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []jwtKey
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeJWKInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid RSA modulus for kid:%s error:%s", k.Kid, err)
+			}
+
+			e, err := decodeJWKInt(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid RSA exponent for kid:%s error:%s", k.Kid, err)
+			}
+
+			keys = append(keys, jwtKey{kid: k.Kid, key: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("Unsupported EC curve:%s for kid:%s", k.Crv, k.Kid)
+			}
+
+			x, err := decodeJWKInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid EC x coordinate for kid:%s error:%s", k.Kid, err)
+			}
+
+			y, err := decodeJWKInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid EC y coordinate for kid:%s error:%s", k.Kid, err)
+			}
+
+			keys = append(keys, jwtKey{kid: k.Kid, key: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}})
+
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.K, "="))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid secret for kid:%s error:%s", k.Kid, err)
+			}
+
+			keys = append(keys, jwtKey{kid: k.Kid, key: secret})
+		}
+	}
+
+	return keys, nil
+}
+
+// decodeJWKInt decodes a base64url encoded big endian integer
+func decodeJWKInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+// matchJWTAudience returns true if the aud claim (string or list) contains any of the allowed audiences
+func matchJWTAudience(aud interface{}, allowed []string) bool {
+	var audiences []string
+	switch v := aud.(type) {
+	case string:
+		audiences = append(audiences, v)
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+
+	for _, a := range audiences {
+		for _, b := range allowed {
+			if a == b {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// jwtClaimString converts a claim value to a header value
+func jwtClaimString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			values = append(values, jwtClaimString(item))
+		}
+		return strings.Join(values, ",")
+	}
+
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func jwtRequest(token string) *http.Request {
+	req := httptest.NewRequest("GET", "https://example.com/api", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestJWTACLHMAC(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	acl := ACL{
+		Action:          "jwt",
+		JWTSecrets:      []string{"old", "secret"},
+		JWTIssuer:       "mercury",
+		JWTAudience:     []string{"api"},
+		JWTClaimHeaders: map[string]string{"sub": "X-User", "roles": "X-Roles"},
+	}
+
+	sign := func(claims jwt.MapClaims, secret string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		assert.Nil(t, err)
+		return token
+	}
+
+	valid := jwt.MapClaims{"iss": "mercury", "aud": []string{"web", "api"}, "sub": "alice", "roles": []string{"admin", "user"}, "exp": time.Now().Add(time.Hour).Unix()}
+
+	req := jwtRequest(sign(valid, "secret"))
+	req.Header.Set("X-User", "spoofed")
+	status, err := acl.processJWT(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "alice", req.Header.Get("X-User"))
+	assert.Equal(t, "admin,user", req.Header.Get("X-Roles"))
+
+	// missing token
+	req = jwtRequest("")
+	req.Header.Set("X-User", "spoofed")
+	status, err = acl.processJWT(req)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, "", req.Header.Get("X-User"))
+
+	// wrong secret
+	status, _ = acl.processJWT(jwtRequest(sign(valid, "wrong")))
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// expired
+	expired := jwt.MapClaims{"iss": "mercury", "aud": "api", "exp": time.Now().Add(-time.Hour).Unix()}
+	status, _ = acl.processJWT(jwtRequest(sign(expired, "secret")))
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// no expiry
+	status, _ = acl.processJWT(jwtRequest(sign(jwt.MapClaims{"iss": "mercury", "aud": "api"}, "secret")))
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// wrong issuer and audience
+	status, _ = acl.processJWT(jwtRequest(sign(jwt.MapClaims{"iss": "other", "aud": "api", "exp": time.Now().Add(time.Hour).Unix()}, "secret")))
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = acl.processJWT(jwtRequest(sign(jwt.MapClaims{"iss": "mercury", "aud": "other", "exp": time.Now().Add(time.Hour).Unix()}, "secret")))
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// path not matching is ignored, but claim headers of the client are still removed
+	acl.URLPath = "^/other"
+	req = jwtRequest("")
+	req.Header.Set("X-User", "spoofed")
+	status, err = acl.processJWT(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "", req.Header.Get("X-User"))
+}
+
+func TestJWTACLJWKS(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"key1","n":"%s","e":"%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()))
+
+	file, err := ioutil.TempFile("", "jwks")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(jwks)
+	file.Close()
+
+	acl := ACL{Action: "jwt", JWTJWKSFile: file.Name(), JWTSecrets: []string{"secret"}}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = "key1"
+	signed, err := token.SignedString(key)
+	assert.Nil(t, err)
+
+	status, err := acl.processJWT(jwtRequest(signed))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status)
+
+	// unknown key id
+	token.Header["kid"] = "key2"
+	signed, err = token.SignedString(key)
+	assert.Nil(t, err)
+	status, _ = acl.processJWT(jwtRequest(signed))
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"fmt"
+	"net/http"
 	"sync"
 	"time"
 
@@ -25,6 +26,10 @@ type Backend struct {
 	Uptime          time.Time
 	ErrorPage       ErrorPage
 	MaintenancePage ErrorPage
+	ForwardAuth     ForwardAuth
+
+	forwardAuthCache  *forwardAuthCache
+	forwardAuthClient *http.Client
 }
 
 // NewBackend creates a new backend
@@ -179,7 +184,7 @@ func (b *Backend) GetBackendNodeBalanced(backendpool, ip, sticky, balancemode st
 func BackendNodeStats(n []*BackendNode) []balancer.Statistics {
 	var s []balancer.Statistics
 	for _, node := range n {
-		s = append(s, *node.Statistics)
+		s = append(s, node.Statistics.Copy())
 	}
 
 	return s
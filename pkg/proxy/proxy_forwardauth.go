@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/schubergphilis/mercury/pkg/tracing"
+)
+
+// ForwardAuth contains the authentication service to verify requests with before they are proxied
+type ForwardAuth struct {
+	URL                string   `json:"url" toml:"url"`                                   // url of the authentication service
+	Timeout            int      `json:"timeout" toml:"timeout"`                           // timeout in seconds for the sub-request
+	ResponseHeaders    []string `json:"response_headers" toml:"response_headers"`         // headers of the authentication reply to copy to the upstream request
+	CacheTTL           int      `json:"cache_ttl" toml:"cache_ttl"`                       // seconds to cache authentication results (0 = disabled)
+	InsecureSkipVerify bool     `json:"insecure_skip_verify" toml:"insecure_skip_verify"` // do not verify the certificate of the authentication service
+}
+
+// forwardAuthResult is the cached reply of the authentication service
+type forwardAuthResult struct {
+	statusCode int
+	headers    http.Header
+	expires    time.Time
+}
+
+// forwardAuthCache keeps the authentication replies for a short time
+type forwardAuthCache struct {
+	sync.RWMutex
+	results map[string]forwardAuthResult
+}
+
+// forwardAuthCacheSize is the maximum number of cached results per backend
+const forwardAuthCacheSize = 10000
+
+// hopHeaders are not forwarded to the authentication service
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length"}
+
+// enabled returns true if a forward authentication service is configured
+func (f ForwardAuth) enabled() bool {
+	return f.URL != ""
+}
+
+// SetForwardAuth sets the authentication service of the backend, and clears its cache if the service changed
+func (b *Backend) SetForwardAuth(f ForwardAuth) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	if b.forwardAuthClient != nil && reflect.DeepEqual(b.ForwardAuth, f) {
+		return
+	}
+
+	if b.forwardAuthClient != nil {
+		// requests in progress keep using the old client, its idle connections are no longer needed
+		b.forwardAuthClient.CloseIdleConnections()
+	}
+
+	b.ForwardAuth = f
+	b.forwardAuthCache = &forwardAuthCache{results: make(map[string]forwardAuthResult)}
+	b.forwardAuthClient = &http.Client{
+		Timeout: time.Duration(f.Timeout) * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: f.InsecureSkipVerify},
+		},
+		// we want to return redirects of the authentication service, not follow them
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// forwardAuth returns the authentication service of the backend with its cache and client, which are replaced on a config change
+func (b *Backend) forwardAuth() (ForwardAuth, *forwardAuthCache, *http.Client) {
+	b.sync.RLock()
+	defer b.sync.RUnlock()
+	return b.ForwardAuth, b.forwardAuthCache, b.forwardAuthClient
+}
+
+// processForwardAuth sends a sub-request to the authentication service, and returns the status code to reply with if the request is not allowed
+func (b *Backend) processForwardAuth(req *http.Request) (statusCode int, err error) {
+	config, cache, client := b.forwardAuth()
+	if !config.enabled() || client == nil {
+		return 0, nil
+	}
+
+	log := logging.For("proxy/forwardauth").WithField("url", config.URL)
+	authReq, err := newForwardAuthRequest(config, req)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	key := forwardAuthKey(req, authReq)
+	cache.RLock()
+	result, ok := cache.results[key]
+	cache.RUnlock()
+	if !ok || result.expires.Before(time.Now()) {
+		result, err = forwardAuthRequest(client, authReq)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		if config.CacheTTL > 0 {
+			result.expires = time.Now().Add(time.Duration(config.CacheTTL) * time.Second)
+			cache.add(key, result)
+		}
+	} else {
+		log.WithField("statuscode", result.statusCode).Debug("Using cached authentication result")
+	}
+
+	switch {
+	case result.statusCode >= 200 && result.statusCode < 300:
+		for _, header := range config.ResponseHeaders {
+			req.Header.Del(header)
+			for _, value := range result.headers[http.CanonicalHeaderKey(header)] {
+				req.Header.Add(header, value)
+			}
+		}
+		return 0, nil
+
+	case result.statusCode == http.StatusUnauthorized || result.statusCode == http.StatusForbidden:
+		return result.statusCode, fmt.Errorf("Authentication service denied request with status %d", result.statusCode)
+	}
+
+	return http.StatusInternalServerError, fmt.Errorf("Authentication service returned unexpected status %d", result.statusCode)
+}
+
+// newForwardAuthRequest creates the sub-request with the original method, uri and headers
+func newForwardAuthRequest(config ForwardAuth, req *http.Request) (*http.Request, error) {
+	authReq, err := http.NewRequest(req.Method, config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range req.Header {
+		for _, value := range values {
+			authReq.Header.Add(key, value)
+		}
+	}
+
+	for _, header := range hopHeaders {
+		authReq.Header.Del(header)
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	authReq.Header.Set("X-Original-URI", req.URL.RequestURI())
+	authReq.Header.Set("X-Original-Method", req.Method)
+	if authReq.Header.Get("X-Forwarded-Host") == "" {
+		authReq.Header.Set("X-Forwarded-Host", req.Host)
+	}
+
+	if authReq.Header.Get("X-Forwarded-Proto") == "" {
+		authReq.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	return authReq, nil
+}
+
+// forwardAuthRequest sends the sub-request to the authentication service
+func forwardAuthRequest(client *http.Client, authReq *http.Request) (forwardAuthResult, error) {
+	res, err := client.Do(authReq)
+	if err != nil {
+		return forwardAuthResult{}, fmt.Errorf("Authentication request failed: %s", err)
+	}
+
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	return forwardAuthResult{statusCode: res.StatusCode, headers: res.Header}, nil
+}
+
+// add stores a result, expired results are removed if the cache is full
+func (c *forwardAuthCache) add(key string, result forwardAuthResult) {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.results) >= forwardAuthCacheSize {
+		now := time.Now()
+		for k, r := range c.results {
+			if r.expires.Before(now) {
+				delete(c.results, k)
+			}
+		}
+
+		if len(c.results) >= forwardAuthCacheSize {
+			return
+		}
+	}
+
+	c.results[key] = result
+}
+
+// forwardAuthKey returns the cache key of a request, based on the client ip, client certificate and all headers sent to the authentication service
+// the request id and trace context differ for every request, and are left out so results can be reused
+func forwardAuthKey(req *http.Request, authReq *http.Request) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n", authReq.Method, req.Host, remoteIP(req.RemoteAddr))
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		hash.Write(req.TLS.PeerCertificates[0].Raw)
+	}
+
+	var names []string
+	for name := range authReq.Header {
+		switch http.CanonicalHeaderKey(name) {
+		case http.CanonicalHeaderKey(requestIDHeader), http.CanonicalHeaderKey(tracing.TraceParentHeader), http.CanonicalHeaderKey(tracing.TraceStateHeader):
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(hash, "\n%s: %q", name, authReq.Header[name])
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardAuth(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	var requests int32
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.Header.Get("Authorization") {
+		case "valid":
+			assert.Equal(t, "/private?x=1", r.Header.Get("X-Original-URI"))
+			assert.Equal(t, "POST", r.Method)
+			w.Header().Set("X-Auth-User", "alice")
+			w.WriteHeader(http.StatusOK)
+		case "forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer auth.Close()
+
+	backend := NewBackend("uuid", "roundrobin", "http", []string{"example.com"}, 10, ErrorPage{}, ErrorPage{})
+	backend.SetForwardAuth(ForwardAuth{URL: auth.URL, Timeout: 5, ResponseHeaders: []string{"X-Auth-User"}, CacheTTL: 60})
+
+	request := func(authorization string) *http.Request {
+		req := httptest.NewRequest("POST", "http://example.com/private?x=1", nil)
+		req.Header.Set("Authorization", authorization)
+		req.Header.Set("X-Auth-User", "spoofed")
+		return req
+	}
+
+	req := request("valid")
+	status, err := backend.processForwardAuth(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "alice", req.Header.Get("X-Auth-User"))
+
+	// cached
+	req = request("valid")
+	status, err = backend.processForwardAuth(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", req.Header.Get("X-Auth-User"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// results are cached per client ip and per header sent to the authentication service
+	req = request("valid")
+	req.RemoteAddr = "[2001:db8::1]:1234"
+	status, _ = backend.processForwardAuth(req)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	req = request("valid")
+	req.Header.Set("X-Api-Key", "other")
+	status, _ = backend.processForwardAuth(req)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	// but not per request id
+	req = request("valid")
+	processRequestID(req)
+	backend.processForwardAuth(req)
+	req = request("valid")
+	processRequestID(req)
+	backend.processForwardAuth(req)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	status, _ = backend.processForwardAuth(request("forbidden"))
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, _ = backend.processForwardAuth(request(""))
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = backend.processForwardAuth(request("broken"))
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	// a reload with the same config keeps the cache, also while requests are processed
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			backend.SetForwardAuth(ForwardAuth{URL: auth.URL, Timeout: 5, ResponseHeaders: []string{"X-Auth-User"}, CacheTTL: 60})
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 20; i++ {
+		status, _ = backend.processForwardAuth(request("valid"))
+		assert.Equal(t, 0, status)
+	}
+
+	<-done
+	assert.Equal(t, int32(6), atomic.LoadInt32(&requests))
+
+	// a changed config clears the cache
+	backend.SetForwardAuth(ForwardAuth{URL: auth.URL, Timeout: 5, ResponseHeaders: []string{"X-Auth-User"}, CacheTTL: 30})
+	status, _ = backend.processForwardAuth(request("valid"))
+	assert.Equal(t, 0, status)
+	assert.Equal(t, int32(7), atomic.LoadInt32(&requests))
+}
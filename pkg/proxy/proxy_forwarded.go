@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// SetTrustedProxies sets the networks of proxies of which we trust the X-Forwarded-For and Forwarded headers
+func (l *Listener) SetTrustedProxies(cidrs []string) {
+	log := logging.For("proxy/trustedproxies").WithField("pool", l.Name)
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.WithField("cidr", cidr).WithError(err).Warn("Ignoring invalid trusted proxy network")
+			continue
+		}
+
+		networks = append(networks, network)
+	}
+
+	l.TrustedProxies = cidrs
+	l.trustedNetworks = networks
+}
+
+// isTrustedProxy returns true if the ip is in one of the trusted proxy networks
+func (l *Listener) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range l.trustedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// processForwarded determines the real client ip of a request and sets the forwarding headers
+// the RemoteAddr of the request is replaced with the real client ip, so ACL's, balancing and logging use it
+// the ReverseProxy adds the ip of the connecting peer to X-Forwarded-For afterwards
+func (l *Listener) processForwarded(req *http.Request) (clientIP string, peerIP string) {
+	peerIP, port, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		peerIP = req.RemoteAddr
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	clientIP = peerIP
+	if !l.isTrustedProxy(peerIP) {
+		// headers from untrusted clients cannot be relied upon
+		req.Header.Del("X-Forwarded-For")
+		req.Header.Del("Forwarded")
+		req.Header.Set("X-Forwarded-Proto", proto)
+		req.Header.Set("X-Forwarded-Host", req.Host)
+		return clientIP, peerIP
+	}
+
+	// walk the chain from the nearest proxy back, the first untrusted address is the client
+	chain := forwardedChain(req.Header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		if net.ParseIP(chain[i]) == nil {
+			break
+		}
+
+		clientIP = chain[i]
+		if !l.isTrustedProxy(chain[i]) {
+			break
+		}
+	}
+
+	if req.Header.Get("X-Forwarded-Proto") == "" {
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	if req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+
+	if forwarded := req.Header.Get("Forwarded"); forwarded != "" {
+		req.Header.Set("Forwarded", fmt.Sprintf("%s, for=%s;host=%q;proto=%s", strings.Join(req.Header["Forwarded"], ", "), forwardedNode(peerIP), req.Host, proto))
+	}
+
+	if port == "" {
+		req.RemoteAddr = clientIP
+	} else {
+		req.RemoteAddr = net.JoinHostPort(clientIP, port)
+	}
+
+	return clientIP, peerIP
+}
+
+// remoteIP returns the ip of a remote address, which may or may not include a port
+func remoteIP(addr string) string {
+	if ip, _, err := net.SplitHostPort(addr); err == nil {
+		return ip
+	}
+
+	return addr
+}
+
+// forwardedChain returns the client addresses of the Forwarded header, or if not present the X-Forwarded-For header
+func forwardedChain(header http.Header) []string {
+	var chain []string
+	if forwarded, ok := header["Forwarded"]; ok {
+		for _, element := range strings.Split(strings.Join(forwarded, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					chain = append(chain, forwardedAddress(kv[1]))
+				}
+			}
+		}
+
+		return chain
+	}
+
+	for _, addr := range strings.Split(strings.Join(header["X-Forwarded-For"], ","), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			chain = append(chain, forwardedAddress(addr))
+		}
+	}
+
+	return chain
+}
+
+// forwardedAddress strips quotes, brackets and port from a forwarded address
+func forwardedAddress(addr string) string {
+	addr = strings.Trim(strings.TrimSpace(addr), "\"")
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return strings.Trim(addr, "[]")
+}
+
+// forwardedNode formats an ip for use in the Forwarded header, ipv6 addresses need to be quoted
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf("\"[%s]\"", ip)
+	}
+
+	return ip
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessForwarded(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	l := New("uuid", "test", 10)
+	l.SetTrustedProxies([]string{"10.0.0.0/8", "2001:db8::/32", "invalid"})
+	assert.Equal(t, 2, len(l.trustedNetworks))
+
+	// untrusted clients can not spoof their address
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("Forwarded", "for=1.2.3.4")
+	client, peer := l.processForwarded(req)
+	assert.Equal(t, "192.0.2.1", client)
+	assert.Equal(t, "192.0.2.1", peer)
+	assert.Equal(t, "", req.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "", req.Header.Get("Forwarded"))
+	assert.Equal(t, "http", req.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "example.com", req.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "192.0.2.1:1234", req.RemoteAddr)
+
+	// trusted proxy chain, the first untrusted address from the right is the client
+	req = httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "10.1.1.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 198.51.100.7, 10.2.2.2")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	client, peer = l.processForwarded(req)
+	assert.Equal(t, "198.51.100.7", client)
+	assert.Equal(t, "10.1.1.1", peer)
+	assert.Equal(t, "198.51.100.7:1234", req.RemoteAddr)
+	assert.Equal(t, "https", req.Header.Get("X-Forwarded-Proto"))
+
+	// Forwarded header takes precedence over X-Forwarded-For
+	req = httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "[2001:db8::1]:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("Forwarded", `for="[2001:db8:cafe::17]:4711";proto=https, for=203.0.113.9`)
+	client, _ = l.processForwarded(req)
+	assert.Equal(t, "203.0.113.9", client)
+	assert.Equal(t, `for="[2001:db8:cafe::17]:4711";proto=https, for=203.0.113.9, for="[2001:db8::1]";host="example.com";proto=http`, req.Header.Get("Forwarded"))
+
+	// obfuscated identifiers stop the chain
+	req = httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "10.1.1.1:1234"
+	req.Header.Set("Forwarded", "for=unknown, for=10.3.3.3")
+	client, _ = l.processForwarded(req)
+	assert.Equal(t, "10.3.3.3", client)
+}
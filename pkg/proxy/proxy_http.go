@@ -7,6 +7,7 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"html"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -23,6 +24,7 @@ import (
 
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
 	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/schubergphilis/mercury/pkg/tracing"
 )
 
 const (
@@ -40,7 +42,11 @@ func customStatusPage(statusCode int, statusMessage string, req *http.Request) *
 	var body []byte
 	nbody := &bytes.Buffer{}
 	t := time.Now()
-	msg := fmt.Sprintf("<head><title>%d %s</title></head><body><h1>%d %s</h1><br>- Generated by Mercury at %s</body>", statusCode, statusMessage, statusCode, statusMessage, t.Format("2006-01-02 15:04:05"))
+	msg := fmt.Sprintf("<head><title>%d %s</title></head><body><h1>%d %s</h1><br>- Generated by Mercury at %s", statusCode, statusMessage, statusCode, statusMessage, t.Format("2006-01-02 15:04:05"))
+	if id := req.Header.Get(requestIDHeader); id != "" {
+		msg += fmt.Sprintf("<br>- Request ID: %s", html.EscapeString(id))
+	}
+	msg += "</body>"
 	nbody.Write(append(body, []byte(msg)...))
 	b := ioutil.NopCloser(nbody)
 	nres := &http.Response{
@@ -64,8 +70,7 @@ func customStatusPage(statusCode int, statusMessage string, req *http.Request) *
 
 // RoundTrip does the actual http sending and receiving for the proxy
 func (t *customTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
-	remoteAddr := strings.Split(req.RemoteAddr, ":")
-	log := logging.For("proxy/roundtrip").WithField("clientip", remoteAddr[0])
+	log := logging.For("proxy/roundtrip").WithField("clientip", remoteIP(req.RemoteAddr)).WithField("requestid", req.Header.Get(requestIDHeader))
 	log.WithField("scheme", req.URL).Debug("Roundtrip scheme")
 	starttime := time.Now()
 	originalScheme := req.URL.Scheme
@@ -85,6 +90,10 @@ func (t *customTransport) RoundTrip(req *http.Request) (res *http.Response, err
 			statusmessage = http.StatusText(statuscode)
 		}
 		res = customStatusPage(statuscode, statusmessage, req)
+		if len(scheme) > 4 && statuscode == http.StatusUnauthorized {
+			// the authentication scheme to challenge the client with, set by the acl that denied the request
+			res.Header.Set("WWW-Authenticate", scheme[4])
+		}
 		return res, nil
 
 	case "maintenance":
@@ -100,11 +109,16 @@ func (t *customTransport) RoundTrip(req *http.Request) (res *http.Response, err
 
 	default: // http/https
 		req.URL.Scheme = scheme[0]
+		span := tracing.SpanFromContext(req.Context()).StartChild("backend round trip", tracing.SpanKindClient)
+		span.SetAttribute("http.method", req.Method).SetAttribute("net.peer.name", req.URL.Host)
+		span.Inject(req.Header)
 		res, err = t.Transport.RoundTrip(req)
 		if err != nil {
+			span.SetStatus(tracing.StatusError, err.Error())
 			// We have an error, generate a 500
 			res = customStatusPage(500, err.Error(), req)
 		}
+		finishSpan(span, res.StatusCode)
 
 		log = log.WithField("scheme", req.URL.Scheme)
 	}
@@ -122,6 +136,11 @@ func (t *customTransport) RoundTrip(req *http.Request) (res *http.Response, err
 	log = log.WithField("statuscode", res.StatusCode).WithField("contentlength", res.ContentLength).WithField("serverproto", res.Proto)
 	log.WithField("roundtriptime", roundtriptime.Seconds()).Info("HTTP response")
 
+	// The response handler is not called on errors, so end the request span here
+	if err != nil {
+		finishSpan(tracing.SpanFromContext(req.Context()), res.StatusCode)
+	}
+
 	// Save the original scheme, we need it when modifying output
 	res.Request.URL.Scheme = originalScheme
 	if res.Request.Header == nil {
@@ -132,7 +151,7 @@ func (t *customTransport) RoundTrip(req *http.Request) (res *http.Response, err
 
 // processACLVariables converts ###TAG###'s in to values based on backendnode
 func processACLVariables(acl []ACL, l *Listener, backendnode BackendNode, req *http.Request) []ACL {
-	log := logging.For("proxy/aclvariables").WithField("pool", l.Name).WithField("localip", l.IP).WithField("localport", l.Port).WithField("mode", l.ListenerMode)
+	log := logging.For("proxy/aclvariables").WithField("pool", l.Name).WithField("localip", l.IP).WithField("localport", l.Port).WithField("mode", l.ListenerMode).WithField("requestid", req.Header.Get(requestIDHeader))
 
 	fn := func(m string) string {
 		name := variableRegex.FindStringSubmatch(m)[1]
@@ -203,8 +222,10 @@ func getVariableValue(name string, l *Listener, backendnode *BackendNode, req *h
 		return "http", nil
 
 	case "CLIENT_IP":
-		val := strings.Split(req.RemoteAddr, ":")
-		return val[0], nil
+		return remoteIP(req.RemoteAddr), nil
+
+	case "REQUEST_ID":
+		return req.Header.Get(requestIDHeader), nil
 
 	case "CLIENT_CERT":
 		return getClientCertValue(req)
@@ -281,13 +302,21 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 
 	// directory is the main handler,
 	// it :
+	// - determines the real client ip if the request came through a trusted proxy
 	// - finds the backend for the client based on the requested
 	// - applies a client Cookie
 	// - applies inbound ACL's (to be sent to the backend server)
 	// - sets the url Scheme to be processed by the RoundTrip handler, and the ModifyResponse handler
 	director := func(req *http.Request) {
-		remoteAddr := strings.Split(req.RemoteAddr, ":")
-		clog := log.WithField("clientip", remoteAddr[0]).WithField("hostname", req.Host)
+		clientIP, peerIP := l.processForwarded(req)
+		requestID := processRequestID(req)
+		clog := log.WithField("clientip", clientIP).WithField("hostname", req.Host).WithField("requestid", requestID)
+		if clientIP != peerIP {
+			clog = clog.WithField("proxyip", peerIP)
+		}
+
+		span := l.startRequestSpan(req, clientIP, requestID)
+
 		// Update statistics of the Listener
 		l.Statistics.ClientsConnectsAdd(1)
 		l.updateClients()
@@ -315,6 +344,7 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 		reqHost := strings.Split(req.Host, ":")
 		backendname, backend := l.FindBackendByHost(reqHost[0])
 		clog = clog.WithField("backend", backendname)
+		span.SetAttribute("mercury.backend", backendname)
 		if backendname == "" {
 			// We don't have a backend match, this could be due to a hostname in the request which is unknown, and only if there is no default
 			other := l.FindAllHostNames()
@@ -340,9 +370,10 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 		}
 
 		// Get a Node to balance this request to
-		client := strings.Split(req.RemoteAddr, ":")
-		backendnode, status, err := backend.GetBackendNodeBalanced(backendname, client[0], stickyCookie, backend.BalanceMode)
+		selectSpan := span.StartChild("backend selection", tracing.SpanKindInternal).SetAttribute("mercury.balance_mode", backend.BalanceMode)
+		backendnode, status, err := backend.GetBackendNodeBalanced(backendname, clientIP, stickyCookie, backend.BalanceMode)
 		if err != nil {
+			selectSpan.SetStatus(tracing.StatusError, err.Error()).Finish()
 			clog.WithField("error", err).Error("No backend node available")
 			if status == healthcheck.Maintenance {
 				req.URL.Scheme = "maintenance//" + backendname + "//503//Service Unavailable - no backend available"
@@ -351,9 +382,26 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 			req.URL.Scheme = "error//" + backendname + "//503//Service Unavailable - no backend available"
 			return
 		}
+		selectSpan.SetAttribute("mercury.node", fmt.Sprintf("%s:%d", backendnode.IP, backendnode.Port)).Finish()
+		span.SetAttribute("mercury.node", fmt.Sprintf("%s:%d", backendnode.IP, backendnode.Port))
 		clog.WithField("backendip", backendnode.IP).WithField("backendport", backendnode.Port).Debug("Forwarding HTTP request to backend")
 
+		aclSpan := span.StartChild("acl", tracing.SpanKindInternal)
 		acl := processACLVariables(l.Backends[backendname].InboundACL, l, *backendnode, req)
+		// Validate JWT's first, so claims copied to headers can be used by other ACL's
+		for _, inacl := range acl {
+			if inacl.Action != jwtMatch {
+				continue
+			}
+
+			if statuscode, err := inacl.processJWT(req); err != nil {
+				clog.WithError(err).WithField("statuscode", statuscode).Infof("Client did not pass jwt acl")
+				aclSpan.SetAttribute("http.status_code", statuscode).SetStatus(tracing.StatusError, "jwt acl").Finish()
+				req.URL.Scheme = fmt.Sprintf("error//%s//%d//%s - invalid or missing token//Bearer", backendname, statuscode, http.StatusText(statuscode))
+				return
+			}
+		}
+
 		aclAllows := l.Backends[backendname].InboundACL.CountActions("allow")
 		aclDenies := l.Backends[backendname].InboundACL.CountActions("deny")
 
@@ -367,19 +415,31 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 
 		// Take actions based on allow/deny, you cannot combine allow and denies
 		if aclDenies > 0 && aclAllows > 0 {
-			log.Errorf("Found ALLOW and DENY ACL's in the same block, only allows will be processed")
+			clog.Errorf("Found ALLOW and DENY ACL's in the same block, only allows will be processed")
 		}
 
 		if aclAllows > 0 && aclsHit == 0 { // setting an allow ACL, will deny all who do not match atleast 1 allow
 			req.URL.Scheme = "error//" + backendname + "//403//Access denied - does not match ALLOW ACL"
 			clog.Infof("Client did not match allow acl")
+			aclSpan.SetAttribute("http.status_code", 403).SetStatus(tracing.StatusError, "allow acl").Finish()
 			return
 		} else if aclAllows == 0 && aclDenies > 0 && aclsHit > 0 { // setting an deny ACL, will deny all who match 1 of the denies
 			clog.Infof("Client matched deny acl")
+			aclSpan.SetAttribute("http.status_code", 403).SetStatus(tracing.StatusError, "deny acl").Finish()
 			req.URL.Scheme = "error//" + backendname + "403//Access denied - matched DENY ACL"
 			return
 		}
 
+		// Verify the request with the authentication service, if any
+		if statuscode, err := backend.processForwardAuth(req); err != nil {
+			clog.WithError(err).WithField("statuscode", statuscode).Infof("Client did not pass forward authentication")
+			aclSpan.SetAttribute("http.status_code", statuscode).SetStatus(tracing.StatusError, "forward authentication").Finish()
+			req.URL.Scheme = fmt.Sprintf("error//%s//%d//%s - authentication failed", backendname, statuscode, http.StatusText(statuscode))
+			return
+		}
+
+		aclSpan.Finish()
+
 		backendnode.Statistics.ClientsConnectsAdd(1)
 		backendnode.Statistics.TimeCounterAdd() // connections past 30 seconds
 		backendnode.Statistics.ClientsConnectedAdd(1)
@@ -394,6 +454,17 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 	}
 
 	modifyresponse := func(res *http.Response) error {
+		// Return the request id to the client
+		if res.Request != nil {
+			if id := res.Request.Header.Get(requestIDHeader); id != "" {
+				res.Header.Set(requestIDHeader, id)
+			}
+
+			defer func() {
+				finishSpan(tracing.SpanFromContext(res.Request.Context()), res.StatusCode)
+			}()
+		}
+
 		// Process OutboundACL if we have a valid request (does not apply to errors)
 		localerror := false
 		localmaintenance := false
@@ -428,7 +499,7 @@ func (l *Listener) NewHTTPProxy() *httputil.ReverseProxy {
 					acls := l.Backends[backendname].OutboundACL
 					node, err := l.Backends[backendname].GetBackendNodeByID(nodeid)
 					if err != nil {
-						log.WithError(err).Debug("Did not parse node ACL, since no node could be found:")
+						log.WithField("requestid", res.Request.Header.Get(requestIDHeader)).WithError(err).Debug("Did not parse node ACL, since no node could be found:")
 						node = &BackendNode{}
 					}
 					// Change ACL's to processed variables
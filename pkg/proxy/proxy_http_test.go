@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/schubergphilis/mercury/pkg/healthcheck"
@@ -152,6 +153,12 @@ func TestGetVariableValue(t *testing.T) {
 			expectedError: nil,
 			request:       httpRequest,
 		},
+		{
+			name:          "CLIENT_IP",
+			expectedValue: "2001:db8::1",
+			expectedError: nil,
+			request:       createHTTPRequestFromRemoteAddr("[2001:db8::1]:1234"),
+		},
 		{
 			name:          "CLIENT_CERT",
 			expectedValue: "",
@@ -176,6 +183,18 @@ func TestGetVariableValue(t *testing.T) {
 			expectedError: nil,
 			request:       httpsRequestWithMultipleCertificates,
 		},
+		{
+			name:          "REQUEST_ID",
+			expectedValue: "",
+			expectedError: nil,
+			request:       httpRequest,
+		},
+		{
+			name:          "REQUEST_ID",
+			expectedValue: "abc-123",
+			expectedError: nil,
+			request:       createHTTPRequestWithRequestID("abc-123"),
+		},
 	}
 
 	for index, data := range testData {
@@ -206,6 +225,18 @@ func createHTTPRequest() *http.Request {
 	return httptest.NewRequest("GET", "http://example.com:8080/foo?key-a=value-a&key-b", nil)
 }
 
+func createHTTPRequestWithRequestID(id string) *http.Request {
+	req := createHTTPRequest()
+	req.Header.Set(requestIDHeader, id)
+	return req
+}
+
+func createHTTPRequestFromRemoteAddr(addr string) *http.Request {
+	req := createHTTPRequest()
+	req.RemoteAddr = addr
+	return req
+}
+
 func createHTTPSRequest() *http.Request {
 	req := httptest.NewRequest("GET", "https://example.com:4443/foo?key-a=value-a&key-b", nil)
 	req.TLS = &tls.ConnectionState{}
@@ -228,3 +259,38 @@ func createHTTPSRequestWithMultipleClientCertificates() *http.Request {
 	}
 	return req
 }
+
+func TestProcessRequestID(t *testing.T) {
+	// valid incoming id's are kept
+	req := createHTTPRequestWithRequestID("abc-123")
+	assert.Equal(t, "abc-123", processRequestID(req))
+
+	// missing or invalid id's are replaced
+	for _, id := range []string{"", "contains spaces", "<script>", strings.Repeat("a", 129)} {
+		req = createHTTPRequestWithRequestID(id)
+		generated := processRequestID(req)
+		assert.NotEqual(t, id, generated)
+		assert.Len(t, generated, 36)
+		assert.Equal(t, generated, req.Header.Get(requestIDHeader))
+	}
+}
+
+func TestRoundTripErrorChallenge(t *testing.T) {
+	logging.Configure("stdout", "error")
+	transport := &customTransport{}
+
+	// only acl's that set an authentication scheme challenge the client
+	req := createHTTPRequest()
+	req.URL.Scheme = "error//backend//401//Unauthorized - invalid or missing token//Bearer"
+	res, err := transport.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, "Bearer", res.Header.Get("WWW-Authenticate"))
+
+	req = createHTTPRequest()
+	req.URL.Scheme = "error//backend//401//Unauthorized - authentication failed"
+	res, err = transport.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, "", res.Header.Get("WWW-Authenticate"))
+}
@@ -44,7 +44,12 @@ type Listener struct {
 	ReadTimeout     int // Timeout in seconds to wait for the client sending the request - https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
 	WriteTimeout    int // Timeout in seconds to wait for server reply to client
 	Uptime          time.Time
-	OCSPStapling    string // use OCSP Stapling
+	OCSPStapling    string   // use OCSP Stapling
+	CRLFiles        []string // CRL files to check client certificates against
+	CRLRefresh      int      // Interval in seconds to reload the CRL files
+	ClientOCSP      string   // check client certificates using OCSP
+	TrustedProxies  []string // networks of proxies we trust the forwarded headers of
+	trustedNetworks []*net.IPNet
 }
 
 // New creates a new proxy for using a listener
@@ -87,6 +92,7 @@ func (l *Listener) Start() {
 	var listener net.Listener
 	var err error
 	ocspQuit := make(chan bool)
+	revocationQuit := make(chan bool)
 	switch l.ListenerMode {
 	case "tcp":
 		// Start listener, and do actions based on that, do other functions
@@ -134,6 +140,12 @@ func (l *Listener) Start() {
 			return nil, nil
 		}
 
+		if l.revocationEnabled() {
+			revocation := tlsconfig.NewRevocationChecker(l.CRLFiles, time.Duration(l.CRLRefresh)*time.Second, l.ClientOCSP == YES)
+			l.TLSConfig.VerifyPeerCertificate = revocation.VerifyPeerCertificate
+			go revocation.CRLHandler(revocationQuit)
+		}
+
 		httpsrv = &http.Server{
 			ReadTimeout:  time.Duration(l.ReadTimeout) * time.Second,
 			WriteTimeout: time.Duration(l.WriteTimeout) * time.Second,
@@ -196,6 +208,14 @@ func (l *Listener) Start() {
 					}
 				}
 
+				if l.ListenerMode == HTTPS && l.revocationEnabled() {
+					log.Debug("Stopping of Proxy finished, stopping crl refresh")
+					select {
+					case revocationQuit <- true:
+					default:
+					}
+				}
+
 			case "udp":
 				// TODO: not implemented yet
 			}
@@ -278,6 +298,18 @@ func (l *Listener) SetListener(mode string, sourceIP string, ip string, port int
 	l.OCSPStapling = ocspStapling
 }
 
+// SetClientRevocation sets the revocation checks done on client certificates
+func (l *Listener) SetClientRevocation(crlFiles []string, crlRefresh int, clientOCSP string) {
+	l.CRLFiles = crlFiles
+	l.CRLRefresh = crlRefresh
+	l.ClientOCSP = clientOCSP
+}
+
+// revocationEnabled returns true if client certificates should be checked for revocation
+func (l *Listener) revocationEnabled() bool {
+	return len(l.CRLFiles) > 0 || l.ClientOCSP == YES
+}
+
 // UpdateBackend adds a backend to an existing proxy, or updates an existing one
 func (l *Listener) UpdateBackend(uuid string, name string, balancemode string, connectmode string, hostname []string, maxconnections int, errorPage ErrorPage, maintenancePage ErrorPage) {
 	if backend, ok := l.Backends[name]; ok {
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"net/http"
+	"regexp"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+)
+
+// validRequestID limits incoming request id's to a sane length and character set
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:+/=-]{1,128}$`)
+
+// processRequestID keeps a valid incoming request id or generates a new one, and sets it on the request
+func processRequestID(req *http.Request) string {
+	id := req.Header.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		newid, err := uuid.NewV4()
+		if err != nil {
+			return ""
+		}
+
+		id = newid.String()
+	}
+
+	req.Header.Set(requestIDHeader, id)
+	return id
+}
@@ -171,6 +171,12 @@ func TestACLCIDRDeny(t *testing.T) {
 		}
 	}
 
+	ipv6ACL := ACL{Action: "deny", CIDRS: []string{"2001:db8::/32"}}
+	req.RemoteAddr = "[2001:db8::1]:1234"
+	if !ipv6ACL.ProcessRequest(req) {
+		t.Errorf("ACL did not match deny of 2001:db8::/32 while remote addr is %s", req.RemoteAddr)
+	}
+
 }
 
 func TestACLCIDRAllow(t *testing.T) {
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/schubergphilis/mercury/pkg/tracing"
+)
+
+// startRequestSpan starts the server span of a proxied request, continuing the trace of the client if any
+// the span is stored in the request context, so the round trip and response handlers can use it
+func (l *Listener) startRequestSpan(req *http.Request, clientIP string, requestID string) *tracing.Span {
+	if !tracing.Enabled() {
+		return nil
+	}
+
+	parent, _ := tracing.Extract(req.Header)
+	span := tracing.StartSpan(fmt.Sprintf("HTTP %s", req.Method), tracing.SpanKindServer, parent)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.host", req.Host)
+	span.SetAttribute("http.target", req.URL.RequestURI())
+	span.SetAttribute("net.peer.ip", clientIP)
+	span.SetAttribute("mercury.pool", l.Name)
+	span.SetAttribute("mercury.request_id", requestID)
+
+	*req = *req.WithContext(tracing.ContextWithSpan(req.Context(), span))
+	return span
+}
+
+// finishSpan sets the http status on a span and ends it
+func finishSpan(span *tracing.Span, statusCode int) {
+	span.SetAttribute("http.status_code", statusCode)
+	if statusCode >= 500 {
+		span.SetStatus(tracing.StatusError, http.StatusText(statusCode))
+	}
+
+	span.Finish()
+}
@@ -127,6 +127,17 @@ func GetOCSPResult(certificates []*x509.Certificate) ([]byte, *ocsp.Response, er
 		return nil, nil, fmt.Errorf("Could not locate CA certificate issuer for OCSP checking, no CA cert included?")
 	}
 
+	return QueryOCSP(issuedCert, issuerCert)
+}
+
+// QueryOCSP sends the OCSP request for a certificate to its OCSP server and returns the reply
+func QueryOCSP(issuedCert, issuerCert *x509.Certificate) ([]byte, *ocsp.Response, error) {
+	var HTTPClient = http.Client{Timeout: 10 * time.Second}
+
+	if len(issuedCert.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("No OCSP server specified in certificate")
+	}
+
 	// Create OCSP request
 	ocspRequest, err := ocsp.CreateRequest(issuedCert, issuerCert, nil)
 	if err != nil {
@@ -147,7 +158,7 @@ func GetOCSPResult(certificates []*x509.Certificate) ([]byte, *ocsp.Response, er
 		return nil, nil, err
 	}
 
-	defer req.Body.Close()
+	defer res.Body.Close()
 
 	// Read Request
 	ocspResult, err := ioutil.ReadAll(res.Body)
@@ -155,8 +166,8 @@ func GetOCSPResult(certificates []*x509.Certificate) ([]byte, *ocsp.Response, er
 		return nil, nil, err
 	}
 
-	// Check response
-	ocspResultStatus, err := ocsp.ParseResponse(ocspResult, issuerCert)
+	// Check response, it must be of the certificate requested
+	ocspResultStatus, err := ocsp.ParseResponseForCert(ocspResult, issuedCert, issuerCert)
 	if err != nil {
 		return nil, nil, err
 	}
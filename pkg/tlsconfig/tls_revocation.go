@@ -0,0 +1,287 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// RevocationChecker verifies client certificates against CRL files and optionally OCSP
+type RevocationChecker struct {
+	CRLFiles []string
+	Refresh  time.Duration
+	OCSP     bool
+	lock     sync.RWMutex
+	revoked  map[string]time.Time
+	crls     map[string][]*x509.RevocationList // by issuer
+	verified map[string]error                  // result of the signature check of the CRLs, by issuer certificate
+	ocsp     map[string]ocspStatus
+}
+
+// ocspCacheSize is the maximum amount of cached OCSP replies
+const ocspCacheSize = 10000
+
+// ocspStatus is a cached OCSP reply for a client certificate
+type ocspStatus struct {
+	revoked    bool
+	nextUpdate time.Time
+}
+
+// NewRevocationChecker creates a new revocation checker, CRL files are loaded immediately
+func NewRevocationChecker(crlFiles []string, refresh time.Duration, useOCSP bool) *RevocationChecker {
+	log := logging.For("tlsconfig/revocation/new")
+	if refresh < 1*time.Minute {
+		refresh = 1 * time.Hour
+	}
+
+	r := &RevocationChecker{
+		CRLFiles: crlFiles,
+		Refresh:  refresh,
+		OCSP:     useOCSP,
+		revoked:  make(map[string]time.Time),
+		crls:     make(map[string][]*x509.RevocationList),
+		verified: make(map[string]error),
+		ocsp:     make(map[string]ocspStatus),
+	}
+
+	if err := r.LoadCRLs(); err != nil {
+		log.WithError(err).Warn("Initial CRL load failed")
+	}
+
+	return r
+}
+
+// CRLHandler reloads the CRL files on the configured interval
+func (r *RevocationChecker) CRLHandler(quit chan bool) {
+	log := logging.For("tlsconfig/revocation/handler")
+	ticker := time.NewTicker(r.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.LoadCRLs(); err != nil {
+				log.WithError(err).Warn("CRL reload failed, keeping previous revocation list")
+			} else {
+				log.WithField("files", r.CRLFiles).Debug("CRL reload succesfull")
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// LoadCRLs reads all CRL files, the existing list is only replaced if all files load
+func (r *RevocationChecker) LoadCRLs() error {
+	revoked := make(map[string]time.Time)
+	crls := make(map[string][]*x509.RevocationList)
+	for _, file := range r.CRLFiles {
+		crl, err := loadCRLFile(file, revoked)
+		if err != nil {
+			return err
+		}
+
+		crls[issuerKey(crl.RawIssuer)] = append(crls[issuerKey(crl.RawIssuer)], crl)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.revoked = revoked
+	r.crls = crls
+	r.verified = make(map[string]error)
+	return nil
+}
+
+// loadCRLFile parses a PEM or DER encoded CRL file and adds its revoked serials to the list
+// the signature of the CRL is checked once the certificate of its issuer is known, when verifying a client certificate
+func loadCRLFile(file string, revoked map[string]time.Time) (*x509.RevocationList, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read CRL file:%s error:%s", file, err)
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse CRL file:%s error:%s", file, err)
+	}
+
+	if !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(time.Now()) {
+		logging.For("tlsconfig/revocation/load").WithField("file", file).WithField("nextupdate", crl.NextUpdate).Warn("CRL file is past its next update time")
+	}
+
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[revocationKey(crl.RawIssuer, entry.SerialNumber.String())] = entry.RevocationTime
+	}
+
+	return crl, nil
+}
+
+// issuerKey returns the lookup key for an issuer
+func issuerKey(issuer []byte) string {
+	return fmt.Sprintf("%x", issuer)
+}
+
+// revocationKey returns the lookup key for a serial number of an issuer
+func revocationKey(issuer []byte, serial string) string {
+	return fmt.Sprintf("%s/%s", issuerKey(issuer), serial)
+}
+
+// VerifyPeerCertificate can be used as tls.Config.VerifyPeerCertificate to reject revoked client certificates
+func (r *RevocationChecker) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	log := logging.For("tlsconfig/revocation/verify")
+	if len(rawCerts) == 0 {
+		return nil
+	}
+
+	var cert, issuer *x509.Certificate
+	if len(verifiedChains) > 0 && len(verifiedChains[0]) > 0 {
+		cert = verifiedChains[0][0]
+		if len(verifiedChains[0]) > 1 {
+			issuer = verifiedChains[0][1]
+		}
+	} else {
+		var err error
+		cert, err = x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			log.WithError(err).Warn("Rejected client certificate: unable to parse certificate")
+			return fmt.Errorf("Unable to parse client certificate: %s", err)
+		}
+
+		if len(rawCerts) > 1 {
+			issuer, _ = x509.ParseCertificate(rawCerts[1])
+		}
+	}
+
+	if err := r.Check(cert, issuer); err != nil {
+		log.WithField("cn", cert.Subject.CommonName).WithField("serial", cert.SerialNumber.String()).WithField("issuer", cert.Issuer.CommonName).WithField("reason", err.Error()).Warn("Rejected client certificate")
+		return err
+	}
+
+	return nil
+}
+
+// Check returns an error if the certificate is revoked, issuer is required to verify the CRL of the issuer and for OCSP
+func (r *RevocationChecker) Check(cert, issuer *x509.Certificate) error {
+	if issuer == nil && bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		issuer = cert // self signed
+	}
+
+	r.lock.RLock()
+	crls, ok := r.crls[issuerKey(cert.RawIssuer)]
+	revokedAt, revoked := r.revoked[revocationKey(cert.RawIssuer, cert.SerialNumber.String())]
+	r.lock.RUnlock()
+	if ok {
+		// only trust the CRL if it is signed by the issuer, an altered CRL could otherwise hide revoked certificates
+		if err := r.checkCRLSignature(crls, issuer); err != nil {
+			return err
+		}
+
+		if revoked {
+			return fmt.Errorf("Client certificate serial %s revoked by CRL at %s", cert.SerialNumber, revokedAt.Format(time.RFC3339))
+		}
+	}
+
+	if !r.OCSP || len(cert.OCSPServer) == 0 {
+		return nil
+	}
+
+	if issuer == nil {
+		logging.For("tlsconfig/revocation/ocsp").WithField("cn", cert.Subject.CommonName).Debug("No issuer certificate available, skipping OCSP check")
+		return nil
+	}
+
+	return r.checkOCSP(cert, issuer)
+}
+
+// checkCRLSignature returns an error if the CRLs are not signed by the issuer, the result is kept until the CRLs are reloaded
+func (r *RevocationChecker) checkCRLSignature(crls []*x509.RevocationList, issuer *x509.Certificate) error {
+	if issuer == nil {
+		return fmt.Errorf("No issuer certificate available to verify the CRL")
+	}
+
+	key := string(issuer.Raw)
+	r.lock.RLock()
+	err, ok := r.verified[key]
+	r.lock.RUnlock()
+	if ok {
+		return err
+	}
+
+	for _, crl := range crls {
+		if err = crl.CheckSignatureFrom(issuer); err != nil {
+			err = fmt.Errorf("CRL of issuer %s has an invalid signature: %s", issuer.Subject.CommonName, err)
+			break
+		}
+	}
+
+	r.lock.Lock()
+	r.verified[key] = err
+	r.lock.Unlock()
+	return err
+}
+
+// checkOCSP queries the OCSP responder of the certificate, failures to reach the responder are not fatal
+func (r *RevocationChecker) checkOCSP(cert, issuer *x509.Certificate) error {
+	log := logging.For("tlsconfig/revocation/ocsp").WithField("cn", cert.Subject.CommonName).WithField("serial", cert.SerialNumber.String())
+	key := revocationKey(cert.RawIssuer, cert.SerialNumber.String())
+
+	r.lock.RLock()
+	cached, ok := r.ocsp[key]
+	r.lock.RUnlock()
+	if !ok || cached.nextUpdate.Before(time.Now()) {
+		_, response, err := QueryOCSP(cert, issuer)
+		if err != nil {
+			log.WithError(err).Warn("OCSP check of client certificate failed, allowing certificate")
+			return nil
+		}
+
+		cached = ocspStatus{
+			revoked:    response.Status == ocsp.Revoked,
+			nextUpdate: response.NextUpdate,
+		}
+
+		if cached.nextUpdate.IsZero() {
+			cached.nextUpdate = time.Now().Add(r.Refresh)
+		}
+
+		r.lock.Lock()
+		r.pruneOCSP()
+		r.ocsp[key] = cached
+		r.lock.Unlock()
+	}
+
+	if cached.revoked {
+		return fmt.Errorf("Client certificate serial %s revoked by OCSP", cert.SerialNumber)
+	}
+
+	return nil
+}
+
+// pruneOCSP removes the expired OCSP replies once the cache is full, and clears it if none expired, the caller must hold the lock
+func (r *RevocationChecker) pruneOCSP() {
+	if len(r.ocsp) < ocspCacheSize {
+		return
+	}
+
+	now := time.Now()
+	for key, status := range r.ocsp {
+		if status.nextUpdate.Before(now) {
+			delete(r.ocsp, key)
+		}
+	}
+
+	if len(r.ocsp) >= ocspCacheSize {
+		r.ocsp = make(map[string]ocspStatus)
+	}
+}
@@ -0,0 +1,102 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA creates a self signed CA certificate
+func testCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Mercury Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert, key
+}
+
+// testClientCert creates a client certificate signed by the CA
+func testClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, serial int64) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client" + strconv.FormatInt(serial, 10)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert
+}
+
+func TestRevocationChecker(t *testing.T) {
+	logging.Configure("stdout", "error")
+	ca, caKey := testCA(t)
+	valid := testClientCert(t, ca, caKey, 10)
+	revoked := testClientCert(t, ca, caKey, 11)
+
+	dir, err := ioutil.TempDir("", "mercury-crl")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeCRL := func(signer *x509.Certificate, signerKey *ecdsa.PrivateKey) string {
+		crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:                    big.NewInt(1),
+			ThisUpdate:                time.Now().Add(-time.Minute),
+			NextUpdate:                time.Now().Add(time.Hour),
+			RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(11), RevocationTime: time.Now().Add(-time.Minute)}},
+		}, signer, signerKey)
+		assert.Nil(t, err)
+		file := filepath.Join(dir, "crl.der")
+		assert.Nil(t, ioutil.WriteFile(file, crl, 0600))
+		return file
+	}
+
+	r := NewRevocationChecker([]string{writeCRL(ca, caKey)}, time.Hour, false)
+	assert.Nil(t, r.Check(valid, ca))
+	assert.NotNil(t, r.Check(revoked, ca))
+
+	// a CRL not signed by the issuer is not trusted
+	forged, forgedKey := testCA(t)
+	r = NewRevocationChecker([]string{writeCRL(forged, forgedKey)}, time.Hour, false)
+	assert.NotNil(t, r.Check(valid, ca))
+	assert.Nil(t, r.LoadCRLs())
+	assert.NotNil(t, r.Check(valid, ca))
+
+	// expired OCSP replies are removed once the cache is full
+	for i := 0; i < ocspCacheSize; i++ {
+		r.ocsp[strconv.Itoa(i)] = ocspStatus{nextUpdate: time.Now().Add(time.Duration(i%2*2-1) * time.Hour)}
+	}
+
+	r.pruneOCSP()
+	assert.Equal(t, ocspCacheSize/2, len(r.ocsp))
+}
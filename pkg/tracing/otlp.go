@@ -0,0 +1,175 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// otlpExporter sends spans to a collector using OTLP/HTTP with JSON encoding
+type otlpExporter struct {
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	client      *http.Client
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func newOTLPExporter(c Config) *otlpExporter {
+	return &otlpExporter{
+		endpoint:    c.Endpoint,
+		serviceName: c.ServiceName,
+		headers:     c.Headers,
+		client:      &http.Client{Timeout: time.Duration(c.Timeout) * time.Second},
+	}
+}
+
+// export sends a batch of spans to the collector
+func (e *otlpExporter) export(spans []*Span) error {
+	data, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Collector returned status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// encode converts spans to the OTLP JSON structure
+func (e *otlpExporter) encode(spans []*Span) otlpTraces {
+	var encoded []otlpSpan
+	for _, s := range spans {
+		s.Lock()
+		span := otlpSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			TraceState:        s.Context.TraceState,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+		}
+
+		if s.Parent != (SpanID{}) {
+			span.ParentSpanID = s.Parent.String()
+		}
+		s.Unlock()
+
+		encoded = append(encoded, span)
+	}
+
+	return otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: otlpAttributes(map[string]interface{}{"service.name": e.serviceName})},
+			ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "mercury"}, Spans: encoded}},
+		}},
+	}
+}
+
+// otlpAttributes converts attributes to OTLP key values
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	var kv []otlpKeyValue
+	for key, value := range attributes {
+		var v otlpValue
+		switch val := value.(type) {
+		case string:
+			v.StringValue = &val
+		case int:
+			i := strconv.Itoa(val)
+			v.IntValue = &i
+		case int64:
+			i := strconv.FormatInt(val, 10)
+			v.IntValue = &i
+		case float64:
+			v.DoubleValue = &val
+		case bool:
+			v.BoolValue = &val
+		default:
+			str := fmt.Sprintf("%v", val)
+			v.StringValue = &str
+		}
+
+		kv = append(kv, otlpKeyValue{Key: key, Value: v})
+	}
+
+	return kv
+}
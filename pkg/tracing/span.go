@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SpanKind describes the relationship of a span to its parent, values match OTLP
+type SpanKind int
+
+const (
+	// SpanKindInternal is an internal operation
+	SpanKindInternal SpanKind = 1
+	// SpanKindServer is a request received from a client
+	SpanKindServer SpanKind = 2
+	// SpanKindClient is a request sent to a server
+	SpanKindClient SpanKind = 3
+)
+
+// StatusCode is the status of a span, values match OTLP
+type StatusCode int
+
+const (
+	// StatusUnset is the default status
+	StatusUnset StatusCode = 0
+	// StatusOK marks a span as succesfull
+	StatusOK StatusCode = 1
+	// StatusError marks a span as failed
+	StatusError StatusCode = 2
+)
+
+// Span is a single timed operation within a trace
+type Span struct {
+	sync.Mutex
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	Status        StatusCode
+	StatusMessage string
+	ended         bool
+}
+
+type spanKey struct{}
+
+// StartSpan starts a new span as child of parent, or as a new trace if parent is not valid
+// spans are only exported if tracing is enabled and the trace is sampled
+func StartSpan(name string, kind SpanKind, parent SpanContext) *Span {
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+
+	if parent.Valid() {
+		s.Context = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, TraceState: parent.TraceState}
+		s.Parent = parent.SpanID
+	} else {
+		s.Context.TraceID = newTraceID()
+		if get().sample(s.Context.TraceID) {
+			s.Context.Flags |= flagSampled
+		}
+	}
+
+	s.Context.SpanID = newSpanID()
+	return s
+}
+
+// StartChild starts a new span as child of this span
+// all span functions can be called on a nil span, which makes them a no-op when tracing is disabled
+func (s *Span) StartChild(name string, kind SpanKind) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return StartSpan(name, kind, s.Context)
+}
+
+// Inject sets the trace context headers of this span on a request
+func (s *Span) Inject(header http.Header) {
+	if s == nil {
+		return
+	}
+
+	s.Context.Inject(header)
+}
+
+// SetAttribute sets an attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) *Span {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.Attributes[key] = value
+	return s
+}
+
+// SetStatus sets the status of the span
+func (s *Span) SetStatus(code StatusCode, message string) *Span {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.Status = code
+	s.StatusMessage = message
+	return s
+}
+
+// Finish ends the span and queues it for export, calling it more then once has no effect
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.End = time.Now()
+	s.Unlock()
+
+	if s.Context.Sampled() {
+		get().export(s)
+	}
+}
+
+// ContextWithSpan returns a context containing the span
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span in the context, or nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
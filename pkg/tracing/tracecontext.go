@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TraceParentHeader is the W3C trace context header containing the trace and parent span id
+	TraceParentHeader = "traceparent"
+	// TraceStateHeader is the W3C trace context header containing vendor specific trace data
+	TraceStateHeader = "tracestate"
+
+	flagSampled = 0x01
+)
+
+// TraceID is a 16 byte trace identifier
+type TraceID [16]byte
+
+// SpanID is an 8 byte span identifier
+type SpanID [8]byte
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+// String returns the hex representation of the trace id
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// String returns the hex representation of the span id
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// Valid returns true if the trace and span id are set
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Sampled returns true if the span is sampled
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&flagSampled == flagSampled
+}
+
+// TraceParent returns the span context as traceparent header value
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// Inject sets the trace context headers on a request
+func (sc SpanContext) Inject(header http.Header) {
+	header.Set(TraceParentHeader, sc.TraceParent())
+	if sc.TraceState != "" {
+		header.Set(TraceStateHeader, sc.TraceState)
+	} else {
+		header.Del(TraceStateHeader)
+	}
+}
+
+// Extract returns the span context of the trace context headers of a request
+func Extract(header http.Header) (SpanContext, bool) {
+	sc, ok := ParseTraceParent(header.Get(TraceParentHeader))
+	if !ok {
+		return SpanContext{}, false
+	}
+
+	sc.TraceState = strings.Join(header[http.CanonicalHeaderKey(TraceStateHeader)], ",")
+	return sc, true
+}
+
+// ParseTraceParent parses a traceparent header value
+func ParseTraceParent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	value = strings.TrimSpace(value)
+	if value != strings.ToLower(value) {
+		return sc, false
+	}
+
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+
+	// version ff is invalid, version 00 has exactly 4 fields, future versions may add more
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+
+	sc.Flags = flags[0]
+	return sc, sc.Valid()
+}
+
+// newTraceID returns a random trace id
+func newTraceID() (t TraceID) {
+	rand.Read(t[:])
+	return
+}
+
+// newSpanID returns a random span id
+func newSpanID() (s SpanID) {
+	rand.Read(s[:])
+	return
+}
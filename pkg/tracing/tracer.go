@@ -0,0 +1,214 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+)
+
+// Config contains the tracing settings
+type Config struct {
+	Endpoint      string            `toml:"endpoint" json:"endpoint"`             // OTLP/HTTP traces endpoint (e.g. http://localhost:4318/v1/traces), tracing is disabled if empty
+	ServiceName   string            `toml:"service_name" json:"service_name"`     // service.name resource attribute
+	SampleRatio   float64           `toml:"sample_ratio" json:"sample_ratio"`     // ratio of new traces to sample (0.0 - 1.0), incomming traces follow the parent
+	Headers       map[string]string `toml:"headers" json:"headers"`               // extra headers to send to the collector (e.g. authentication)
+	BatchSize     int               `toml:"batch_size" json:"batch_size"`         // maximum spans per export
+	QueueSize     int               `toml:"queue_size" json:"queue_size"`         // maximum spans waiting for export, new spans are dropped if full
+	FlushInterval int               `toml:"flush_interval" json:"flush_interval"` // seconds between exports
+	Timeout       int               `toml:"timeout" json:"timeout"`               // timeout in seconds for an export
+}
+
+// tracer holds the active configuration and export queue
+type tracer struct {
+	config    Config
+	threshold uint64
+	queue     chan *Span
+	quit      chan bool
+	done      chan bool
+	dropped   int64
+	exported  int64
+}
+
+var (
+	active *tracer
+	lock   sync.RWMutex
+)
+
+// SetDefaults sets the default values of the tracing config
+func (c *Config) SetDefaults() {
+	if c.ServiceName == "" {
+		c.ServiceName = "mercury"
+	}
+
+	if c.SampleRatio < 0 {
+		c.SampleRatio = 0
+	}
+
+	if c.SampleRatio > 1 {
+		c.SampleRatio = 1
+	}
+
+	if c.BatchSize == 0 {
+		c.BatchSize = 512
+	}
+
+	if c.QueueSize == 0 {
+		c.QueueSize = 2048
+	}
+
+	if c.FlushInterval == 0 {
+		c.FlushInterval = 5
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 10
+	}
+}
+
+// Configure activates a new tracing config, spans queued for the previous config are flushed first
+func Configure(c Config) {
+	log := logging.For("tracing/configure")
+	c.SetDefaults()
+
+	lock.Lock()
+	if active != nil && reflect.DeepEqual(active.config, c) {
+		lock.Unlock()
+		return
+	}
+
+	previous := active
+	active = newTracer(c)
+	if c.Endpoint != "" {
+		go active.exportHandler()
+		log.WithField("endpoint", c.Endpoint).WithField("sampleratio", c.SampleRatio).Info("Tracing enabled")
+	}
+	lock.Unlock()
+
+	if previous != nil && previous.config.Endpoint != "" {
+		previous.stop()
+	}
+}
+
+// Enabled returns true if spans are exported
+func Enabled() bool {
+	return get().config.Endpoint != ""
+}
+
+// Stats returns the number of exported and dropped spans
+func Stats() (exported int64, dropped int64) {
+	t := get()
+	return atomic.LoadInt64(&t.exported), atomic.LoadInt64(&t.dropped)
+}
+
+// get returns the active tracer, or a disabled one if tracing was never configured
+func get() *tracer {
+	lock.RLock()
+	defer lock.RUnlock()
+	if active == nil {
+		return disabled
+	}
+
+	return active
+}
+
+var disabled = newTracer(Config{})
+
+func newTracer(c Config) *tracer {
+	t := &tracer{
+		config: c,
+		queue:  make(chan *Span, c.QueueSize),
+		quit:   make(chan bool),
+		done:   make(chan bool),
+	}
+
+	// trace id based sampling, so all services using the same ratio make the same decision
+	switch {
+	case c.SampleRatio >= 1:
+		t.threshold = ^uint64(0)
+	case c.SampleRatio > 0:
+		t.threshold = uint64(c.SampleRatio * float64(^uint64(0)))
+	}
+
+	return t
+}
+
+// sample returns true if a new trace should be sampled
+func (t *tracer) sample(id TraceID) bool {
+	if t.config.Endpoint == "" || t.threshold == 0 {
+		return false
+	}
+
+	return binary.BigEndian.Uint64(id[8:]) <= t.threshold
+}
+
+// export queues a finished span for export
+func (t *tracer) export(s *Span) {
+	if t.config.Endpoint == "" {
+		return
+	}
+
+	select {
+	case t.queue <- s:
+	default:
+		atomic.AddInt64(&t.dropped, 1)
+	}
+}
+
+// stop flushes the queue and stops the export handler
+func (t *tracer) stop() {
+	close(t.quit)
+	<-t.done
+}
+
+// exportHandler sends the queued spans in batches to the collector
+func (t *tracer) exportHandler() {
+	log := logging.For("tracing/export").WithField("endpoint", t.config.Endpoint)
+	exporter := newOTLPExporter(t.config)
+	ticker := time.NewTicker(time.Duration(t.config.FlushInterval) * time.Second)
+	defer ticker.Stop()
+	defer close(t.done)
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := exporter.export(batch); err != nil {
+			log.WithError(err).WithField("spans", len(batch)).Warn("Failed to export spans")
+			atomic.AddInt64(&t.dropped, int64(len(batch)))
+		} else {
+			atomic.AddInt64(&t.exported, int64(len(batch)))
+		}
+
+		batch = nil
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= t.config.BatchSize {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+
+		case <-t.quit:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/schubergphilis/mercury/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	sc, ok := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.TraceParent())
+
+	for _, invalid := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-xyz92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, ok := ParseTraceParent(invalid)
+		assert.False(t, ok, invalid)
+	}
+
+	// future versions may have extra fields
+	_, ok = ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.True(t, ok)
+}
+
+func TestSpanPropagation(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	header.Set(TraceStateHeader, "vendor=value")
+
+	parent, ok := Extract(header)
+	assert.True(t, ok)
+
+	span := StartSpan("test", SpanKindServer, parent)
+	assert.Equal(t, parent.TraceID, span.Context.TraceID)
+	assert.Equal(t, parent.SpanID, span.Parent)
+	assert.NotEqual(t, parent.SpanID, span.Context.SpanID)
+	assert.False(t, span.Context.Sampled())
+
+	child := span.StartChild("child", SpanKindClient)
+	out := http.Header{}
+	child.Inject(out)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+child.Context.SpanID.String()+"-00", out.Get(TraceParentHeader))
+	assert.Equal(t, "vendor=value", out.Get(TraceStateHeader))
+
+	// nil spans are a no-op
+	var disabledSpan *Span
+	disabledSpan.StartChild("child", SpanKindInternal).SetAttribute("key", "value").SetStatus(StatusError, "error").Finish()
+}
+
+func TestOTLPExport(t *testing.T) {
+	logging.Configure("stdout", "error")
+
+	received := make(chan otlpTraces, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		body, _ := ioutil.ReadAll(r.Body)
+		var traces otlpTraces
+		assert.Nil(t, json.Unmarshal(body, &traces))
+		received <- traces
+	}))
+	defer collector.Close()
+
+	Configure(Config{Endpoint: collector.URL, SampleRatio: 1, Headers: map[string]string{"X-Api-Key": "secret"}, BatchSize: 2})
+	defer Configure(Config{})
+	assert.True(t, Enabled())
+
+	span := StartSpan("request", SpanKindServer, SpanContext{})
+	assert.True(t, span.Context.Sampled())
+	child := span.StartChild("round trip", SpanKindClient)
+	child.SetAttribute("http.status_code", 200).SetAttribute("mercury.pool", "pool")
+	child.Finish()
+	span.SetStatus(StatusError, "failed").Finish()
+
+	select {
+	case traces := <-received:
+		assert.Equal(t, "mercury", *traces.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+		spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+		assert.Len(t, spans, 2)
+		assert.Equal(t, "round trip", spans[0].Name)
+		assert.Equal(t, span.Context.SpanID.String(), spans[0].ParentSpanID)
+		assert.Equal(t, SpanKindClient, spans[0].Kind)
+		assert.Equal(t, "", spans[1].ParentSpanID)
+		assert.Equal(t, StatusError, spans[1].Status.Code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No spans received by collector")
+	}
+}
+
+func TestSampler(t *testing.T) {
+	never := newTracer(Config{Endpoint: "http://localhost", SampleRatio: 0})
+	always := newTracer(Config{Endpoint: "http://localhost", SampleRatio: 1})
+	half := newTracer(Config{Endpoint: "http://localhost", SampleRatio: 0.5})
+
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		id := newTraceID()
+		assert.False(t, never.sample(id))
+		assert.True(t, always.sample(id))
+		if half.sample(id) {
+			sampled++
+		}
+	}
+
+	assert.InDelta(t, 500, sampled, 100)
+}